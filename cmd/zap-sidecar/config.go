@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hanzoai/zap-sidecar/internal/config"
+)
+
+var modes = []string{"sql", "kv", "datastore", "documentdb"}
+
+// loadConfig builds the configuration from -config (or ZAP_CONFIG) when
+// given, otherwise from the flags and ZAP_* environment variables.
+func loadConfig(args []string) (*config.Config, error) {
+	fs := flag.NewFlagSet("zap-sidecar", flag.ExitOnError)
+	configPath := fs.String("config", "", "YAML/JSON config file; replaces the backend flags")
+	mode := fs.String("mode", "", "sidecar modes, comma-separated: sql, kv, datastore, documentdb")
+	nodeID := fs.String("node-id", "", "ZAP node ID (default: mode names)")
+	port := fs.Int("port", 9651, "ZAP listen port")
+	serviceType := fs.String("service-type", "_hanzo._tcp", "mDNS service type")
+	backend := fs.String("backend", "", "backend address (e.g. localhost:5432), default for every mode")
+	password := fs.String("password", "", "backend password (KV/Datastore)")
+	adminPort := fs.Int("admin-port", 0, "HTTP port for /healthz, /readyz and /metrics (0 = disabled)")
+	readOnly := fs.Bool("read-only", false, "reject every request that modifies data")
+	grace := fs.Duration("grace-period", config.DefaultGracePeriod, "time in-flight requests get to finish on shutdown")
+	backends := make(map[string]*string, len(modes))
+	for _, m := range modes {
+		backends[m] = fs.String(m+"-backend", "", m+" backend address (overrides -backend)")
+	}
+	fs.Parse(args)
+
+	if *configPath == "" {
+		*configPath = os.Getenv("ZAP_CONFIG")
+	}
+	if *adminPort == 0 {
+		*adminPort, _ = strconv.Atoi(os.Getenv("ZAP_ADMIN_PORT"))
+	}
+	if !*readOnly {
+		*readOnly, _ = strconv.ParseBool(os.Getenv("ZAP_READ_ONLY"))
+	}
+
+	if *configPath != "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Admin.Port == 0 {
+			cfg.Admin.Port = *adminPort
+		}
+		if *readOnly {
+			cfg.SetReadOnly()
+		}
+		return cfg, nil
+	}
+
+	if *mode == "" {
+		*mode = os.Getenv("ZAP_MODE")
+	}
+	if *backend == "" {
+		*backend = os.Getenv("ZAP_BACKEND")
+	}
+	if *password == "" {
+		*password = os.Getenv("ZAP_PASSWORD")
+	}
+	selected, err := parseModes(*mode)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config.Config{
+		Node:     config.Node{ID: *nodeID, Port: *port, ServiceType: *serviceType},
+		Admin:    config.Admin{Port: *adminPort},
+		Shutdown: config.Shutdown{GracePeriod: config.Duration(*grace)},
+	}
+	for _, m := range selected {
+		addr := *backends[m]
+		if addr == "" {
+			addr = os.Getenv("ZAP_" + strings.ToUpper(m) + "_BACKEND")
+		}
+		if addr == "" {
+			addr = *backend
+		}
+		switch m {
+		case "sql":
+			cfg.SQL = &config.SQL{DSN: addr}
+		case "kv":
+			cfg.KV = &config.KV{Addr: addr, Password: *password}
+		case "datastore":
+			cfg.Datastore = &config.Datastore{
+				Addr:     addr,
+				User:     os.Getenv("ZAP_USER"),
+				Password: *password,
+				Database: os.Getenv("ZAP_DATABASE"),
+			}
+		case "documentdb":
+			cfg.DocumentDB = &config.DocumentDB{Addr: addr, Database: os.Getenv("ZAP_DATABASE")}
+		}
+	}
+	if cfg.Node.ID == "" {
+		cfg.Node.ID = strings.Join(selected, "-")
+	}
+	if *readOnly {
+		cfg.SetReadOnly()
+	}
+	return cfg, cfg.Validate()
+}
+
+func parseModes(s string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, m := range strings.Split(s, ",") {
+		m = strings.TrimSpace(m)
+		if m == "" {
+			continue
+		}
+		known := false
+		for _, k := range modes {
+			known = known || k == m
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown mode %q, use: sql, kv, datastore, or documentdb", m)
+		}
+		if seen[m] {
+			return nil, fmt.Errorf("duplicate mode %q", m)
+		}
+		seen[m] = true
+		out = append(out, m)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no mode given, use -mode, ZAP_MODE or -config")
+	}
+	return out, nil
+}
+
+// configCommand implements "zap-sidecar config validate [file]".
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "usage: zap-sidecar config validate [file]")
+		return 2
+	}
+	path := os.Getenv("ZAP_CONFIG")
+	if len(args) > 1 {
+		path = args[1]
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "usage: zap-sidecar config validate [file]")
+		return 2
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%s: ok (%s)\n", path, strings.Join(cfg.Modes(), ", "))
+	return 0
+}
@@ -2,112 +2,253 @@
 //
 // It runs as a sidecar container alongside each service, accepting ZAP
 // connections from the Hanzo Gateway and translating them to native
-// protocol calls against the co-located backend.
+// protocol calls against the co-located backend. Several backends can be
+// served from one process, either with a comma-separated -mode or with a
+// config file (-config); each proxy registers its own message type on a
+// shared ZAP node.
+//
+//	zap-sidecar -mode sql -backend postgres://localhost:5432/app
+//	zap-sidecar -config /etc/zap/sidecar.yaml
+//	zap-sidecar config validate /etc/zap/sidecar.yaml
+//
+// SIGHUP re-reads the configuration and swaps freshly connected backend
+// clients into each proxy, draining the old ones; with reload.watch_interval
+// set, changes to the config file or the secrets it references do the same.
+//
+// SIGINT/SIGTERM drain the sidecar: /readyz starts failing, new ZAP
+// requests are refused, in-flight ones get the shutdown grace period to
+// finish and are then canceled, and only then are the backends closed.
 //
 // Any service implementing the ZAP schema gets MCP for free via zapd.
 package main
 
 import (
 	"context"
-	"flag"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
+
+	"github.com/luxfi/zap"
 
+	"github.com/hanzoai/zap-sidecar/internal/admin"
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+	"github.com/hanzoai/zap-sidecar/internal/config"
 	"github.com/hanzoai/zap-sidecar/internal/datastore"
 	"github.com/hanzoai/zap-sidecar/internal/documentdb"
+	"github.com/hanzoai/zap-sidecar/internal/drain"
 	"github.com/hanzoai/zap-sidecar/internal/kv"
 	"github.com/hanzoai/zap-sidecar/internal/sql"
 )
 
 func main() {
-	mode := flag.String("mode", "", "sidecar mode: sql, kv, or datastore")
-	nodeID := flag.String("node-id", "", "ZAP node ID (default: mode name)")
-	port := flag.Int("port", 9651, "ZAP listen port")
-	serviceType := flag.String("service-type", "_hanzo._tcp", "mDNS service type")
-	backend := flag.String("backend", "", "backend address (e.g. localhost:5432)")
-	password := flag.String("password", "", "backend password (KV/Datastore)")
-	flag.Parse()
-
-	if *mode == "" {
-		*mode = os.Getenv("ZAP_MODE")
-	}
-	if *backend == "" {
-		*backend = os.Getenv("ZAP_BACKEND")
-	}
-	if *password == "" {
-		*password = os.Getenv("ZAP_PASSWORD")
-	}
-	if *nodeID == "" {
-		*nodeID = *mode
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
 	}
 
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
 	}))
 
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	authn, err := cfg.Auth.Authenticator()
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	auth.Use(authn)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
-	var svc Sidecar
-	var err error
+	var adm *admin.Server
+	if cfg.Admin.Port > 0 {
+		adm = admin.New(logger, cfg.Admin.Port)
+		if err := adm.Start(); err != nil {
+			logger.Error("failed to start admin listener", "error", err)
+			os.Exit(1)
+		}
+	}
 
-	switch *mode {
-	case "sql":
-		svc, err = sql.New(ctx, logger, sql.Config{
-			NodeID:      *nodeID,
-			Port:        *port,
-			ServiceType: *serviceType,
-			DSN:         *backend,
-		})
-	case "kv":
-		svc, err = kv.New(ctx, logger, kv.Config{
-			NodeID:      *nodeID,
-			Port:        *port,
-			ServiceType: *serviceType,
-			Addr:        *backend,
-			Password:    *password,
-		})
-	case "datastore":
-		svc, err = datastore.New(ctx, logger, datastore.Config{
-			NodeID:      *nodeID,
-			Port:        *port,
-			ServiceType: *serviceType,
-			Addr:        *backend,
-			User:        os.Getenv("ZAP_USER"),
-			Password:    *password,
-			Database:    os.Getenv("ZAP_DATABASE"),
-		})
-	case "documentdb":
-		svc, err = documentdb.New(ctx, logger, documentdb.Config{
-			NodeID:      *nodeID,
-			Port:        *port,
-			ServiceType: *serviceType,
-			Addr:        *backend,
-			Database:    os.Getenv("ZAP_DATABASE"),
-		})
-	default:
-		logger.Error("unknown mode, use: sql, kv, datastore, or documentdb", "mode", *mode)
-		os.Exit(1)
+	var svcs sidecars
+	for _, m := range cfg.Modes() {
+		svc, err := newSidecar(ctx, logger, cfg, m)
+		if err != nil {
+			logger.Error("failed to start sidecar", "mode", m, "error", err)
+			svcs.Stop()
+			os.Exit(1)
+		}
+		svcs = append(svcs, svc)
 	}
 
-	if err != nil {
-		logger.Error("failed to start sidecar", "error", err)
+	node := zap.NewNode(zap.NodeConfig{
+		NodeID:      cfg.Node.ID,
+		ServiceType: cfg.Node.ServiceType,
+		Port:        cfg.Node.Port,
+		Logger:      logger,
+	})
+	// Handlers run under reqCtx so shutdown can cancel stragglers.
+	reqCtx, abort := context.WithCancel(ctx)
+	defer abort()
+	svcs.Register(reqCtx, node)
+	if err := node.Start(); err != nil {
+		logger.Error("failed to start zap node", "error", err)
+		svcs.Stop()
 		os.Exit(1)
 	}
 
-	logger.Info("hanzo zap sidecar started", "mode", *mode, "node_id", *nodeID, "port", *port, "backend", *backend)
+	if adm != nil {
+		adm.SetReady(svcs.Ready)
+	}
+
+	logger.Info("hanzo zap sidecar started", "modes", cfg.Modes(), "node_id", cfg.Node.ID, "port", cfg.Node.Port)
+
+	changed := make(chan struct{}, 1)
+	if d := time.Duration(cfg.Reload.WatchInterval); d > 0 {
+		go watch(ctx, cfg.Files(), d, changed)
+	}
+
+	// Reloads run one at a time off the signal loop, so a SIGTERM during a
+	// slow reload is handled at once: it cancels the reload, and shutdown
+	// waits only for the reload to give up.
+	var (
+		reloadMu sync.Mutex // serializes reloads and guards cfg
+		reloads  sync.WaitGroup
+	)
+	reloadCtx, stopReloads := context.WithCancel(ctx)
+	startReload := func(trigger string) {
+		reloads.Add(1)
+		go func() {
+			defer reloads.Done()
+			reloadMu.Lock()
+			defer reloadMu.Unlock()
+			if reloadCtx.Err() != nil {
+				return
+			}
+			logger.Info("reloading configuration", "trigger", trigger)
+			cfg = reload(reloadCtx, logger, cfg, svcs)
+		}()
+	}
+
+	for running := true; running; {
+		select {
+		case <-sig:
+			running = false
+		case <-hup:
+			startReload("sighup")
+		case <-changed:
+			startReload("watch")
+		}
+	}
+	stopReloads()
+	reloads.Wait()
+	grace := time.Duration(cfg.Shutdown.GracePeriod)
+	logger.Info("shutting down", "grace_period", grace.String(), "in_flight", drain.InFlight())
+	if adm != nil {
+		adm.SetDraining()
+	}
+	drain.Close()
+	if err := waitDrained(grace); err != nil {
+		logger.Warn("grace period expired, canceling in-flight requests", "in_flight", drain.InFlight())
+		abort()
+		if err := waitDrained(abortTimeout); err != nil {
+			logger.Warn("requests still running after cancel", "in_flight", drain.InFlight())
+		}
+	}
+	node.Stop()
+	svcs.Stop()
+	if adm != nil {
+		adm.Stop()
+	}
+	logger.Info("shutdown complete")
+}
+
+// abortTimeout is how long canceled requests get to send their error
+// response before the node is stopped.
+const abortTimeout = 5 * time.Second
 
-	<-sig
-	logger.Info("shutting down")
-	svc.Stop()
+func waitDrained(d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return drain.Wait(ctx)
+}
+
+func newSidecar(ctx context.Context, logger *slog.Logger, cfg *config.Config, mode string) (Sidecar, error) {
+	switch mode {
+	case "sql":
+		c, err := cfg.SQL.Proxy()
+		if err != nil {
+			return nil, err
+		}
+		return sql.New(ctx, logger, c)
+	case "kv":
+		c, err := cfg.KV.Proxy()
+		if err != nil {
+			return nil, err
+		}
+		return kv.New(ctx, logger, c)
+	case "datastore":
+		c, err := cfg.Datastore.Proxy()
+		if err != nil {
+			return nil, err
+		}
+		return datastore.New(ctx, logger, c)
+	case "documentdb":
+		c, err := cfg.DocumentDB.Proxy()
+		if err != nil {
+			return nil, err
+		}
+		return documentdb.New(ctx, logger, c)
+	}
+	return nil, fmt.Errorf("unknown mode %q", mode)
 }
 
 // Sidecar is the interface for all ZAP sidecar backends.
 type Sidecar interface {
+	// Register installs the backend's message handler on the shared node.
+	// Requests run under ctx; canceling it aborts them.
+	Register(ctx context.Context, node *zap.Node)
+	// Ready reports whether the backend is reachable.
+	Ready(ctx context.Context) error
+	// Stop closes the backend once no requests are in flight.
 	Stop()
 }
+
+// sidecars runs several backends behind one node.
+type sidecars []Sidecar
+
+func (s sidecars) Register(ctx context.Context, node *zap.Node) {
+	for _, svc := range s {
+		svc.Register(ctx, node)
+	}
+}
+
+func (s sidecars) Ready(ctx context.Context) error {
+	var errs []error
+	for _, svc := range s {
+		if err := svc.Ready(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s sidecars) Stop() {
+	for _, svc := range s {
+		svc.Stop()
+	}
+}
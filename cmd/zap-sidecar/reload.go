@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+	"github.com/hanzoai/zap-sidecar/internal/config"
+	"github.com/hanzoai/zap-sidecar/internal/datastore"
+	"github.com/hanzoai/zap-sidecar/internal/documentdb"
+	"github.com/hanzoai/zap-sidecar/internal/kv"
+	"github.com/hanzoai/zap-sidecar/internal/metrics"
+	"github.com/hanzoai/zap-sidecar/internal/sql"
+)
+
+// reloadTimeout bounds connecting each backend during a reload.
+const reloadTimeout = 30 * time.Second
+
+// reload re-reads the configuration and swaps fresh backend clients into
+// svcs, which run the modes of cur in order. Backends that fail to
+// reconnect keep their old client, as do all remaining ones once ctx is
+// canceled. It returns the config now in effect.
+func reload(ctx context.Context, logger *slog.Logger, cur *config.Config, svcs sidecars) *config.Config {
+	next, err := loadConfig(os.Args[1:])
+	if err != nil {
+		logger.Error("config reload failed", "error", err)
+		metrics.Reloads.Inc("config", "error")
+		return cur
+	}
+	if !slices.Equal(next.Modes(), cur.Modes()) {
+		logger.Error("config reload failed", "error", "modes changed, restart required",
+			"modes", cur.Modes(), "new_modes", next.Modes())
+		metrics.Reloads.Inc("config", "error")
+		return cur
+	}
+	if next.Node != cur.Node || next.Admin != cur.Admin {
+		logger.Warn("node and admin settings changed, restart required to apply")
+	}
+	authn, err := next.Auth.Authenticator()
+	if err != nil {
+		logger.Error("config reload failed", "error", err)
+		metrics.Reloads.Inc("config", "error")
+		return cur
+	}
+	auth.Use(authn)
+	logger.Info("auth policy reloaded", "mode", next.Auth.Mode)
+
+	for i, m := range next.Modes() {
+		if ctx.Err() != nil {
+			logger.Warn("backend reload aborted", "mode", m, "error", ctx.Err())
+			metrics.Reloads.Inc(m, "error")
+			continue
+		}
+		rctx, cancel := context.WithTimeout(ctx, reloadTimeout)
+		err := reloadSidecar(rctx, svcs[i], next)
+		cancel()
+		if err != nil {
+			logger.Error("backend reload failed", "mode", m, "error", err)
+			metrics.Reloads.Inc(m, "error")
+			continue
+		}
+		logger.Info("backend reloaded", "mode", m)
+		metrics.Reloads.Inc(m, "ok")
+	}
+	return next
+}
+
+func reloadSidecar(ctx context.Context, svc Sidecar, cfg *config.Config) error {
+	switch p := svc.(type) {
+	case *sql.Proxy:
+		c, err := cfg.SQL.Proxy()
+		if err != nil {
+			return err
+		}
+		return p.Reload(ctx, c)
+	case *kv.Proxy:
+		c, err := cfg.KV.Proxy()
+		if err != nil {
+			return err
+		}
+		return p.Reload(ctx, c)
+	case *datastore.Proxy:
+		c, err := cfg.Datastore.Proxy()
+		if err != nil {
+			return err
+		}
+		return p.Reload(ctx, c)
+	case *documentdb.Proxy:
+		c, err := cfg.DocumentDB.Proxy()
+		if err != nil {
+			return err
+		}
+		return p.Reload(ctx, c)
+	}
+	return fmt.Errorf("%T does not support reload", svc)
+}
+
+// watch polls the modification time and size of files every interval and
+// signals changed when any of them differs from the previous poll.
+func watch(ctx context.Context, files []string, interval time.Duration, changed chan<- struct{}) {
+	type stamp struct {
+		mod  time.Time
+		size int64
+	}
+	snapshot := func() map[string]stamp {
+		s := make(map[string]stamp, len(files))
+		for _, f := range files {
+			if fi, err := os.Stat(f); err == nil {
+				s[f] = stamp{fi.ModTime(), fi.Size()}
+			}
+		}
+		return s
+	}
+
+	last := snapshot()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		cur := snapshot()
+		if !maps.Equal(cur, last) {
+			last = cur
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+	"github.com/hanzoai/zap-sidecar/internal/config"
+	"github.com/hanzoai/zap-sidecar/internal/datastore"
+)
+
+// reloadFrom writes doc to a config file and reloads cur from it as
+// SIGHUP does, returning the config in effect and the log output.
+func reloadFrom(t *testing.T, doc string, cur *config.Config, svcs sidecars) (*config.Config, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sidecar.yaml")
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	args := os.Args
+	os.Args = []string{"zap-sidecar", "-config", path}
+	defer func() { os.Args = args }()
+
+	var log bytes.Buffer
+	next := reload(context.Background(), slog.New(slog.NewTextHandler(&log, nil)), cur, svcs)
+	return next, log.String()
+}
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		doc     string
+		applied bool
+		wantLog string
+	}{
+		{"datastore: {addr: ch:9000}\nkv: {addr: cache:6379}", false, "modes changed, restart required"},
+		{"kv: {addr: cache:6379}", false, "modes changed, restart required"},
+		{"datastore: {addr: ''}", false, "datastore.addr: required"},
+		// The proxy refuses to switch databases before connecting.
+		{"datastore: {addr: ch:9000, database: analytics}", true, "backend reload failed"},
+	}
+	for _, tt := range tests {
+		cur := &config.Config{Datastore: &config.Datastore{Addr: "ch:9000"}}
+		next, log := reloadFrom(t, tt.doc, cur, sidecars{&datastore.Proxy{}})
+		if applied := next != cur; applied != tt.applied {
+			t.Errorf("%q: config applied = %v, want %v", tt.doc, applied, tt.applied)
+		}
+		if !strings.Contains(log, tt.wantLog) {
+			t.Errorf("%q: log missing %q:\n%s", tt.doc, tt.wantLog, log)
+		}
+	}
+}
+
+func TestReloadAuth(t *testing.T) {
+	auth.Use(nil)
+	t.Cleanup(func() { auth.Use(nil) })
+	cur := &config.Config{Datastore: &config.Datastore{Addr: "ch:9000"}}
+	svcs := sidecars{&datastore.Proxy{}}
+	billing := []byte(`{"Authorization": ["Bearer t1"]}`)
+
+	reloadFrom(t, `
+datastore: {addr: ch:9000, database: analytics}
+auth:
+  mode: token
+  tokens: [{caller: billing, token: t1}]
+`, cur, svcs)
+	if id, err := auth.Authenticate(billing); err != nil || id.Caller != "billing" {
+		t.Errorf("after reload: Authenticate(t1) = %+v, %v", id, err)
+	}
+	if _, err := auth.Authenticate(nil); err == nil {
+		t.Error("after reload: anonymous caller authenticated")
+	}
+
+	// A broken auth section leaves the running policy in place.
+	_, log := reloadFrom(t, "datastore: {addr: ch:9000}\nauth: {mode: mtls}", cur, svcs)
+	if !strings.Contains(log, `unknown mode \"mtls\"`) {
+		t.Errorf("log missing the auth error:\n%s", log)
+	}
+	if id, err := auth.Authenticate(billing); err != nil || id.Caller != "billing" {
+		t.Errorf("after failed reload: Authenticate(t1) = %+v, %v", id, err)
+	}
+}
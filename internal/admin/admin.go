@@ -0,0 +1,122 @@
+// Package admin serves the sidecar's HTTP health, readiness and metrics
+// endpoints on a port separate from the ZAP node:
+//
+//	/healthz  process is alive
+//	/readyz   backend answers its /health check and the sidecar is not draining
+//	/metrics  Prometheus text exposition of the metrics registry
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanzoai/zap-sidecar/internal/metrics"
+)
+
+// ReadyFunc reports whether the sidecar can serve traffic.
+type ReadyFunc func(ctx context.Context) error
+
+type Server struct {
+	srv      *http.Server
+	ready    atomic.Pointer[ReadyFunc]
+	draining atomic.Bool
+	started  time.Time
+	logger   *slog.Logger
+}
+
+func New(logger *slog.Logger, port int) *Server {
+	s := &Server{started: time.Now(), logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/metrics", s.metrics)
+
+	s.srv = &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// SetReady installs the readiness check. Until it is called /readyz
+// reports the sidecar as starting.
+func (s *Server) SetReady(fn ReadyFunc) {
+	s.ready.Store(&fn)
+}
+
+// SetDraining makes /readyz fail from now on so load balancers stop
+// routing to the sidecar while it shuts down.
+func (s *Server) SetDraining() {
+	s.draining.Store(true)
+}
+
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return fmt.Errorf("admin: listen failed: %w", err)
+	}
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("admin: serve failed", "error", err)
+		}
+	}()
+	s.logger.Info("admin listener ready", "addr", s.srv.Addr)
+	return nil
+}
+
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = s.srv.Shutdown(ctx)
+}
+
+func (s *Server) healthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+	fn := s.ready.Load()
+	if fn == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "starting"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	if err := (*fn)(ctx); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) metrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintf(w, "# HELP zap_sidecar_up Whether the sidecar process is running.\n")
+	fmt.Fprintf(w, "# TYPE zap_sidecar_up gauge\nzap_sidecar_up 1\n")
+	fmt.Fprintf(w, "# HELP zap_sidecar_uptime_seconds Seconds since the sidecar started.\n")
+	fmt.Fprintf(w, "# TYPE zap_sidecar_uptime_seconds gauge\nzap_sidecar_uptime_seconds %g\n", time.Since(s.started).Seconds())
+	fmt.Fprintf(w, "# HELP go_goroutines Number of goroutines that currently exist.\n")
+	fmt.Fprintf(w, "# TYPE go_goroutines gauge\ngo_goroutines %d\n", runtime.NumGoroutine())
+	metrics.Write(w)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hanzoai/zap-sidecar/internal/metrics"
+)
+
+// get serves path from s and returns the status and body.
+func get(s *Server, path string) (int, string) {
+	w := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestReadyz(t *testing.T) {
+	down := errors.New("connection refused")
+	tests := []struct {
+		ready    ReadyFunc // nil leaves the check unset
+		want     int
+		wantBody string
+	}{
+		{nil, http.StatusServiceUnavailable, `{"status":"starting"}`},
+		{func(context.Context) error { return nil }, http.StatusOK, `{"status":"ok"}`},
+		{func(context.Context) error { return down }, http.StatusServiceUnavailable, `{"error":"connection refused","status":"unavailable"}`},
+	}
+	for _, tt := range tests {
+		s := New(slog.New(slog.NewTextHandler(io.Discard, nil)), 0)
+		if tt.ready != nil {
+			s.SetReady(tt.ready)
+		}
+		status, body := get(s, "/readyz")
+		if status != tt.want || strings.TrimSpace(body) != tt.wantBody {
+			t.Errorf("/readyz = %d %s, want %d %s", status, body, tt.want, tt.wantBody)
+		}
+	}
+}
+
+func TestReadyzDraining(t *testing.T) {
+	s := New(slog.New(slog.NewTextHandler(io.Discard, nil)), 0)
+	s.SetReady(func(context.Context) error { return nil })
+	s.SetDraining()
+	if status, body := get(s, "/readyz"); status != http.StatusServiceUnavailable || strings.TrimSpace(body) != `{"status":"draining"}` {
+		t.Errorf("/readyz while draining = %d %s", status, body)
+	}
+	if status, _ := get(s, "/healthz"); status != http.StatusOK {
+		t.Errorf("/healthz while draining = %d, want 200", status)
+	}
+}
+
+func TestHealthzIgnoresReadiness(t *testing.T) {
+	s := New(slog.New(slog.NewTextHandler(io.Discard, nil)), 0)
+	s.SetReady(func(context.Context) error { return errors.New("down") })
+	if status, body := get(s, "/healthz"); status != http.StatusOK {
+		t.Errorf("/healthz = %d %s, want 200", status, body)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := New(slog.New(slog.NewTextHandler(io.Discard, nil)), 0)
+	metrics.Start("sql", "/query")(http.StatusOK)
+	status, body := get(s, "/metrics")
+	if status != http.StatusOK {
+		t.Fatalf("/metrics = %d", status)
+	}
+	for _, want := range []string{
+		"# TYPE zap_sidecar_up gauge\nzap_sidecar_up 1\n",
+		"# TYPE zap_sidecar_uptime_seconds gauge\nzap_sidecar_uptime_seconds ",
+		"# TYPE go_goroutines gauge\ngo_goroutines ",
+		`zap_sidecar_requests_total{mode="sql",path="/query",status="200"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics missing %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+// Package auth authenticates ZAP callers and authorizes them against a
+// policy of allowed paths and tools.
+//
+// A caller is identified by a bearer token in the request's Authorization
+// header: a static token or an HS256-signed JWT whose "sub" is the
+// caller. The ZAP peer ID is not an identity: the node takes it from the
+// remote's handshake over plain TCP without verifying it. The policy maps
+// callers to the paths and MCP tools they may use:
+//
+//	policy:
+//	  - caller: billing-api
+//	    allow: [sql_query, "sql:/exec"]
+//	    schemas: [billing]
+//	  - caller: reports
+//	    allow: ["sql:/prepared/*"]
+//	    statements: [daily_revenue]
+//	  - caller: portal
+//	    allow: [sql_query, sql_exec]
+//	    role: portal_user
+//	    settable: [app.tenant_id]
+//	  - caller: "*"
+//	    allow: ["/tools/list", "/resources/*"]
+//
+// The active Authenticator is process-wide; main installs it with Use and
+// every proxy calls Authenticate and Authorize on each request.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanzoai/zap-sidecar/internal"
+)
+
+// Modes select how callers are identified.
+const (
+	ModeNone  = "none"  // no authentication; the policy, if any, is not applied
+	ModeToken = "token" // Authorization: Bearer <static token or HS256 JWT>
+)
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+)
+
+type Config struct {
+	Mode   string
+	Secret []byte // HS256 key for signed tokens; nil disables JWTs
+	Tokens []Token
+	Policy []Rule
+}
+
+// Token is a static bearer token standing for a caller.
+type Token struct {
+	Token  string
+	Caller string
+}
+
+// Rule grants a caller ("*" for any authenticated caller) access to the
+// listed paths. An entry is a path ("/query"), a path prefix ("/tx/*"),
+// "*", or an MCP tool name, optionally scoped to one mode ("sql:/exec").
+// Schemas, when set, confines SQL statements to those schemas; Statements,
+// when set, limits the caller to the listed named SQL statements preloaded
+// from config, never ones registered with /prepare. Role and
+// Settings are the Postgres role and configuration settings the caller's
+// SQL transactions run with, for row-level security; Settable lists the
+// settings a request may set itself. Statements run with a role or
+// settings are refused if they could change them (SET ROLE, RESET,
+// set_config and the like).
+type Rule struct {
+	Caller     string
+	Allow      []string
+	Schemas    []string
+	Statements []string
+	Role       string
+	Settings   map[string]string
+	Settable   []string
+}
+
+// Identity is an authenticated caller.
+type Identity struct {
+	Caller     string
+	Schemas    []string          // SQL schemas the caller is confined to; nil means any
+	Statements []string          // preloaded SQL statements the caller may run; nil means any
+	Role       string            // SQL role to run as; "" keeps the connection's
+	Settings   map[string]string // SQL settings applied to each transaction
+	Settable   []string          // SQL settings a request may set
+}
+
+// MayRun reports whether the caller may run the named SQL statement,
+// preloaded from config or not. A caller with a Statements list runs
+// only preloaded ones: registered names are first come, first served,
+// and any caller allowed /prepare could take a listed name.
+func (id *Identity) MayRun(name string, preloaded bool) bool {
+	return id.Statements == nil || preloaded && slices.Contains(id.Statements, name)
+}
+
+type Authenticator struct {
+	mode   string
+	secret []byte
+	tokens map[[sha256.Size]byte]string
+	rules  map[string]*rule
+}
+
+type rule struct {
+	allow      []grant
+	schemas    []string
+	statements []string
+	role       string
+	settings   map[string]string
+	settable   []string
+}
+
+type grant struct {
+	mode   string // "" for any mode
+	path   string
+	prefix bool
+}
+
+// New compiles cfg, resolving tool names in Allow to their mode and path.
+func New(cfg Config) (*Authenticator, error) {
+	a := &Authenticator{
+		mode:   cfg.Mode,
+		secret: cfg.Secret,
+		tokens: make(map[[sha256.Size]byte]string, len(cfg.Tokens)),
+		rules:  make(map[string]*rule, len(cfg.Policy)),
+	}
+	switch a.mode {
+	case "":
+		a.mode = ModeNone
+	case ModeNone, ModeToken:
+	case "peer":
+		return nil, errors.New("auth: mode peer is not supported, ZAP peer IDs are unverified; use token")
+	default:
+		return nil, fmt.Errorf("auth: unknown mode %q, use none or token", cfg.Mode)
+	}
+	if a.mode == ModeNone && len(cfg.Policy) > 0 {
+		return nil, errors.New("auth: a policy needs mode token")
+	}
+	if a.mode == ModeToken && len(cfg.Secret) == 0 && len(cfg.Tokens) == 0 {
+		return nil, errors.New("auth: token mode needs a secret or static tokens")
+	}
+	for i, t := range cfg.Tokens {
+		if t.Token == "" || t.Caller == "" {
+			return nil, errors.New("auth: static tokens need a token and a caller")
+		}
+		// A token standing for two callers would authenticate as whichever
+		// came last.
+		sum := sha256.Sum256([]byte(t.Token))
+		if caller, dup := a.tokens[sum]; dup && caller != t.Caller {
+			return nil, fmt.Errorf("auth: tokens[%d]: token already stands for caller %q", i, caller)
+		}
+		a.tokens[sum] = t.Caller
+	}
+
+	for i, r := range cfg.Policy {
+		if r.Caller == "" {
+			return nil, fmt.Errorf("auth: policy[%d]: caller required", i)
+		}
+		if _, dup := a.rules[r.Caller]; dup {
+			return nil, fmt.Errorf("auth: policy[%d]: duplicate caller %q", i, r.Caller)
+		}
+		for name := range r.Settings {
+			if name == "" || name == "role" {
+				return nil, fmt.Errorf("auth: policy[%d]: invalid setting %q", i, name)
+			}
+		}
+		cr := &rule{schemas: r.Schemas, statements: r.Statements, role: r.Role, settings: r.Settings, settable: r.Settable}
+		for _, entry := range r.Allow {
+			g, err := parseGrant(entry)
+			if err != nil {
+				return nil, fmt.Errorf("auth: policy[%d]: %w", i, err)
+			}
+			cr.allow = append(cr.allow, g)
+		}
+		a.rules[r.Caller] = cr
+	}
+	return a, nil
+}
+
+// toolSets maps each mode to its MCP tools.
+var toolSets = map[string][]internal.ToolDef{
+	"sql":        internal.SQLTools,
+	"kv":         internal.KVTools,
+	"datastore":  internal.DatastoreTools,
+	"documentdb": internal.DocumentDBTools,
+}
+
+func parseGrant(entry string) (grant, error) {
+	var g grant
+	for mode, tools := range toolSets {
+		if tool, ok := internal.FindTool(tools, entry); ok {
+			return grant{mode: mode, path: tool.Path}, nil
+		}
+	}
+	if m, rest, ok := strings.Cut(entry, ":"); ok && !strings.HasPrefix(entry, "/") {
+		g.mode, entry = m, rest
+	}
+	switch {
+	case entry == "*":
+		g.prefix = true
+	case strings.HasSuffix(entry, "/*") && strings.HasPrefix(entry, "/"):
+		g.path, g.prefix = strings.TrimSuffix(entry, "*"), true
+	case strings.HasPrefix(entry, "/"):
+		g.path = entry
+	default:
+		return g, fmt.Errorf("allow %q: not a path or known tool", entry)
+	}
+	return g, nil
+}
+
+func (g grant) match(mode, path string) bool {
+	if g.mode != "" && g.mode != mode {
+		return false
+	}
+	if g.prefix {
+		return strings.HasPrefix(path, g.path)
+	}
+	return path == g.path
+}
+
+// Authenticate identifies the caller of a request from its JSON-encoded
+// headers.
+func (a *Authenticator) Authenticate(headers []byte) (*Identity, error) {
+	var caller string
+	switch a.mode {
+	case ModeNone:
+		return &Identity{}, nil
+	case ModeToken:
+		token, ok := bearer(headers)
+		if !ok {
+			return nil, fmt.Errorf("%w: missing bearer token", ErrUnauthenticated)
+		}
+		var err error
+		if caller, err = a.verify(token); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+		}
+	}
+
+	id := &Identity{Caller: caller}
+	if r := a.rule(caller); r != nil {
+		id.Schemas = r.schemas
+		id.Statements = r.statements
+		id.Role, id.Settings, id.Settable = r.role, r.settings, r.settable
+	}
+	return id, nil
+}
+
+// Authorize reports whether id may call path on the given mode: a caller
+// without a matching rule is denied everything. Without authentication
+// every request is allowed.
+func (a *Authenticator) Authorize(id *Identity, mode, path string) error {
+	if a.mode == ModeNone {
+		return nil
+	}
+	if r := a.rule(id.Caller); r != nil {
+		for _, g := range r.allow {
+			if g.match(mode, path) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %s may not call %s %s", ErrForbidden, id.Caller, mode, path)
+}
+
+func (a *Authenticator) rule(caller string) *rule {
+	if r, ok := a.rules[caller]; ok {
+		return r
+	}
+	return a.rules["*"]
+}
+
+// verify returns the caller a bearer token stands for.
+func (a *Authenticator) verify(token string) (string, error) {
+	// Looking up the hash keeps the comparison independent of the token.
+	if caller, ok := a.tokens[sha256.Sum256([]byte(token))]; ok {
+		return caller, nil
+	}
+	if a.secret == nil || strings.Count(token, ".") != 2 {
+		return "", errors.New("unknown token")
+	}
+	return verifyJWT(token, a.secret, time.Now())
+}
+
+type claims struct {
+	Sub string `json:"sub"`
+	Exp int64  `json:"exp"`
+	Nbf int64  `json:"nbf"`
+}
+
+// verifyJWT checks an HS256 JWT and returns its subject.
+func verifyJWT(token string, secret []byte, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", fmt.Errorf("token header: %w", err)
+	}
+	if header.Alg != "HS256" {
+		return "", fmt.Errorf("token algorithm %q not supported", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("token signature: malformed")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", errors.New("token signature: invalid")
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return "", fmt.Errorf("token claims: %w", err)
+	}
+	switch {
+	case c.Sub == "":
+		return "", errors.New("token has no subject")
+	case c.Exp != 0 && now.Unix() >= c.Exp:
+		return "", errors.New("token expired")
+	case c.Nbf != 0 && now.Unix() < c.Nbf:
+		return "", errors.New("token not yet valid")
+	}
+	return c.Sub, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// bearer extracts the token from an Authorization header in headers, a
+// JSON object of header name to values.
+func bearer(headers []byte) (string, bool) {
+	var h map[string][]string
+	if len(headers) == 0 || json.Unmarshal(headers, &h) != nil {
+		return "", false
+	}
+	for name, vals := range h {
+		if !strings.EqualFold(name, "Authorization") || len(vals) == 0 {
+			continue
+		}
+		scheme, token, ok := strings.Cut(vals[0], " ")
+		if ok && strings.EqualFold(scheme, "Bearer") && token != "" {
+			return strings.TrimSpace(token), true
+		}
+	}
+	return "", false
+}
+
+// ================================================================
+// Process-wide authenticator
+// ================================================================
+
+var active atomic.Pointer[Authenticator]
+
+// Use installs a as the process-wide authenticator. Until it is called,
+// and after Use(nil), every request is allowed.
+func Use(a *Authenticator) {
+	active.Store(a)
+}
+
+// Authenticate identifies a caller with the active authenticator.
+func Authenticate(headers []byte) (*Identity, error) {
+	a := active.Load()
+	if a == nil {
+		return &Identity{}, nil
+	}
+	return a.Authenticate(headers)
+}
+
+type ctxKey struct{}
+
+// WithIdentity returns ctx carrying id.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the caller identity of a request, or an anonymous
+// identity when there is none.
+func FromContext(ctx context.Context) *Identity {
+	if id, ok := ctx.Value(ctxKey{}).(*Identity); ok {
+		return id
+	}
+	return &Identity{}
+}
+
+// Authorize checks the request's caller in ctx against the active policy.
+func Authorize(ctx context.Context, mode, path string) error {
+	a := active.Load()
+	if a == nil {
+		return nil
+	}
+	return a.Authorize(FromContext(ctx), mode, path)
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var secret = []byte("test-secret")
+
+// sign builds a JWT with the given header algorithm, signed with HS256.
+func sign(t *testing.T, alg string, claims map[string]interface{}, key []byte) string {
+	t.Helper()
+	var segs []string
+	for _, v := range []interface{}{map[string]string{"alg": alg, "typ": "JWT"}, claims} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segs = append(segs, base64.RawURLEncoding.EncodeToString(b))
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(segs[0] + "." + segs[1]))
+	return segs[0] + "." + segs[1] + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		cfg     Config
+		wantErr string
+	}{
+		{Config{}, ""},
+		{Config{Mode: ModeToken, Secret: secret}, ""},
+		{Config{Mode: "peer"}, "peer IDs are unverified"},
+		{Config{Mode: "mtls"}, `unknown mode "mtls"`},
+		{Config{Policy: []Rule{{Caller: "a"}}}, "a policy needs mode token"},
+		{Config{Mode: ModeToken}, "needs a secret or static tokens"},
+		{Config{Mode: ModeToken, Tokens: []Token{{Caller: "a"}}}, "need a token and a caller"},
+		{Config{Mode: ModeToken, Tokens: []Token{{"t", "a"}, {"t", "a"}}}, ""},
+		{Config{Mode: ModeToken, Tokens: []Token{{"t", "a"}, {"t", "b"}}}, `tokens[1]: token already stands for caller "a"`},
+		{Config{Mode: ModeToken, Secret: secret, Policy: []Rule{{Allow: []string{"*"}}}}, "policy[0]: caller required"},
+		{Config{Mode: ModeToken, Secret: secret, Policy: []Rule{{Caller: "a"}, {Caller: "a"}}}, `policy[1]: duplicate caller "a"`},
+		{Config{Mode: ModeToken, Secret: secret, Policy: []Rule{{Caller: "a", Settings: map[string]string{"role": "x"}}}}, `invalid setting "role"`},
+		{Config{Mode: ModeToken, Secret: secret, Policy: []Rule{{Caller: "a", Allow: []string{"query"}}}}, `allow "query": not a path or known tool`},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.cfg)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("New(%+v): %v", tt.cfg, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("New(%+v): err = %v, want it to contain %q", tt.cfg, err, tt.wantErr)
+		}
+	}
+}
+
+func TestVerifyJWT(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	valid := sign(t, "HS256", map[string]interface{}{"sub": "a"}, secret)
+	tests := []struct {
+		token   string
+		want    string
+		wantErr string
+	}{
+		{valid, "a", ""},
+		{sign(t, "HS256", map[string]interface{}{"sub": "a", "nbf": now.Unix() - 1, "exp": now.Unix() + 1}, secret), "a", ""},
+		{sign(t, "HS256", map[string]interface{}{"sub": "a"}, []byte("other")), "", "signature: invalid"},
+		{sign(t, "none", map[string]interface{}{"sub": "a"}, secret), "", `algorithm "none"`},
+		{sign(t, "HS256", map[string]interface{}{}, secret), "", "no subject"},
+		{sign(t, "HS256", map[string]interface{}{"sub": "a", "exp": now.Unix()}, secret), "", "expired"},
+		{sign(t, "HS256", map[string]interface{}{"sub": "a", "nbf": now.Unix() + 1}, secret), "", "not yet valid"},
+		{"!!.e30.sig", "", "token header"},
+		{valid[:strings.LastIndex(valid, ".")] + ".!!", "", "signature: malformed"},
+	}
+	for i, tt := range tests {
+		got, err := verifyJWT(tt.token, secret, now)
+		switch {
+		case tt.wantErr == "" && (err != nil || got != tt.want):
+			t.Errorf("case %d: got %q, %v; want %q", i, got, err, tt.want)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("case %d: err = %v, want it to contain %q", i, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a, err := New(Config{
+		Mode:   ModeToken,
+		Secret: secret,
+		Tokens: []Token{{"static-token", "reports"}},
+		Policy: []Rule{
+			{Caller: "billing", Allow: []string{"sql_query"}, Schemas: []string{"billing"}},
+			{Caller: "portal", Allow: []string{"sql_exec"}, Role: "portal_user", Settable: []string{"app.tenant_id"}},
+			{Caller: "*", Allow: []string{"/tools/list"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	billing := sign(t, "HS256", map[string]interface{}{"sub": "billing"}, secret)
+	portal := sign(t, "HS256", map[string]interface{}{"sub": "portal"}, secret)
+	tests := []struct {
+		headers string
+		want    Identity
+		wantErr bool
+	}{
+		{headers: `{"Authorization": ["Bearer static-token"]}`, want: Identity{Caller: "reports"}},
+		{headers: `{"authorization": ["bearer static-token"]}`, want: Identity{Caller: "reports"}},
+		{headers: `{"Authorization": ["Bearer ` + billing + `"]}`, want: Identity{Caller: "billing", Schemas: []string{"billing"}}},
+		{
+			headers: `{"Authorization": ["Bearer ` + portal + `"]}`,
+			want:    Identity{Caller: "portal", Role: "portal_user", Settable: []string{"app.tenant_id"}},
+		},
+		{headers: `{"Authorization": ["Bearer nope"]}`, wantErr: true},
+		{headers: `{"Authorization": ["Basic dXNlcjpwYXNz"]}`, wantErr: true},
+		{headers: ``, wantErr: true},
+	}
+	for _, tt := range tests {
+		id, err := a.Authenticate([]byte(tt.headers))
+		if tt.wantErr {
+			if !errors.Is(err, ErrUnauthenticated) {
+				t.Errorf("%s: err = %v, want ErrUnauthenticated", tt.headers, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.headers, err)
+			continue
+		}
+		if id.Caller != tt.want.Caller || id.Role != tt.want.Role ||
+			!slices.Equal(id.Schemas, tt.want.Schemas) || !slices.Equal(id.Settable, tt.want.Settable) {
+			t.Errorf("%s: identity %+v, want %+v", tt.headers, *id, tt.want)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	a, err := New(Config{
+		Mode:   ModeToken,
+		Secret: secret,
+		Policy: []Rule{
+			{Caller: "billing", Allow: []string{"sql_query", "sql:/tx/*", "kv:/get"}},
+			{Caller: "admin", Allow: []string{"*"}},
+			{Caller: "*", Allow: []string{"/tools/list"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		caller, mode, path string
+		want               bool
+	}{
+		{"billing", "sql", "/query", true},
+		{"billing", "kv", "/query", false},
+		{"billing", "sql", "/exec", false},
+		{"billing", "sql", "/tx/begin", true},
+		{"billing", "sql", "/txfoo", false},
+		{"billing", "kv", "/get", true},
+		{"billing", "sql", "/get", false},
+		{"billing", "sql", "/tools/list", false}, // a caller's own rule replaces "*"
+		{"admin", "documentdb", "/anything", true},
+		{"someone", "kv", "/tools/list", true},
+		{"someone", "kv", "/get", false},
+	}
+	for _, tt := range tests {
+		err := a.Authorize(&Identity{Caller: tt.caller}, tt.mode, tt.path)
+		if got := err == nil; got != tt.want {
+			t.Errorf("Authorize(%s, %s, %s) = %v, want allowed %v", tt.caller, tt.mode, tt.path, err, tt.want)
+		}
+		if err != nil && !errors.Is(err, ErrForbidden) {
+			t.Errorf("Authorize(%s, %s, %s) = %v, want ErrForbidden", tt.caller, tt.mode, tt.path, err)
+		}
+	}
+}
+
+func TestModeNoneAllowsAll(t *testing.T) {
+	a, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := a.Authenticate(nil)
+	if err != nil || id.Caller != "" {
+		t.Fatalf("Authenticate = %+v, %v", id, err)
+	}
+	if err := a.Authorize(id, "sql", "/exec"); err != nil {
+		t.Errorf("Authorize = %v", err)
+	}
+}
+
+func TestMayRun(t *testing.T) {
+	tests := []struct {
+		statements []string
+		name       string
+		preloaded  bool
+		want       bool
+	}{
+		{nil, "anything", false, true},
+		{nil, "anything", true, true},
+		{[]string{"daily"}, "daily", true, true},
+		{[]string{"daily"}, "daily", false, false}, // registered with /prepare under a listed name
+		{[]string{"daily"}, "other", true, false},
+		{[]string{}, "daily", true, false},
+	}
+	for _, tt := range tests {
+		id := &Identity{Statements: tt.statements}
+		if got := id.MayRun(tt.name, tt.preloaded); got != tt.want {
+			t.Errorf("MayRun(%q, %v) with %v = %v, want %v", tt.name, tt.preloaded, tt.statements, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// Auth configures caller authentication and the access policy. Rules
+// come from Policy and, appended after them, from PolicyFile, a YAML list
+// of rules in the same form.
+//
+//	auth:
+//	  mode: token
+//	  hmac_secret: ${file:/run/secrets/zap-hmac}
+//	  tokens:
+//	    - {caller: billing-api, token: "${BILLING_TOKEN}"}
+//	  policy_file: /etc/zap/policy.yaml
+type Auth struct {
+	Mode       string  `yaml:"mode"` // none (default) or token
+	HMACSecret string  `yaml:"hmac_secret"`
+	Tokens     []Token `yaml:"tokens"`
+	PolicyFile string  `yaml:"policy_file"`
+	Policy     []Rule  `yaml:"policy"`
+}
+
+// Token is one static token entry; see auth.Token.
+type Token struct {
+	Caller string `yaml:"caller"`
+	Token  string `yaml:"token"`
+}
+
+// Rule is one policy entry; see auth.Rule.
+type Rule struct {
+	Caller     string            `yaml:"caller"`
+	Allow      []string          `yaml:"allow"`
+	Schemas    []string          `yaml:"schemas"`
+	Statements []string          `yaml:"statements"`
+	Role       string            `yaml:"role"`
+	Settings   map[string]string `yaml:"settings"`
+	Settable   []string          `yaml:"settable"`
+}
+
+// Authenticator loads the policy file and compiles the auth section.
+func (a *Auth) Authenticator() (*auth.Authenticator, error) {
+	rules := append([]Rule(nil), a.Policy...)
+	if a.PolicyFile != "" {
+		data, err := os.ReadFile(a.PolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth.policy_file: %w", err)
+		}
+		var fileRules []Rule
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&fileRules); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("auth.policy_file: %s: %w", a.PolicyFile, err)
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	cfg := auth.Config{Mode: a.Mode}
+	if a.HMACSecret != "" {
+		cfg.Secret = []byte(a.HMACSecret)
+	}
+	for _, t := range a.Tokens {
+		cfg.Tokens = append(cfg.Tokens, auth.Token{Token: t.Token, Caller: t.Caller})
+	}
+	for _, r := range rules {
+		cfg.Policy = append(cfg.Policy, auth.Rule{
+			Caller:     r.Caller,
+			Allow:      r.Allow,
+			Schemas:    r.Schemas,
+			Statements: r.Statements,
+			Role:       r.Role,
+			Settings:   r.Settings,
+			Settable:   r.Settable,
+		})
+	}
+	return auth.New(cfg)
+}
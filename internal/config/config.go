@@ -0,0 +1,573 @@
+// Package config loads the sidecar configuration file.
+//
+// The file is YAML (JSON is accepted as a YAML subset) and describes the
+// ZAP node plus one section per backend. Any scalar may reference the
+// environment as ${NAME} or ${NAME:-default}; an undefined variable
+// without a default is an error. ${file:/path} is replaced by the file's
+// contents without the trailing newline, for mounted secrets. Unknown keys
+// are rejected.
+//
+//	node:
+//	  id: api
+//	  port: 9651
+//	admin:
+//	  port: 9652
+//	reload:
+//	  watch_interval: 10s
+//	shutdown:
+//	  grace_period: 25s
+//	sql:
+//	  dsn: postgres://app:${file:/run/secrets/pgpassword}@localhost:5432/app
+//	  pool: {max_conns: 20, max_conn_idle_time: 5m}
+//	  query_timeout: 30s
+//	kv:
+//	  addr: localhost:6379
+//	  password: ${KV_PASSWORD:-}
+//	  tls: {ca_file: /etc/ssl/kv-ca.pem}
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/hanzoai/zap-sidecar/internal/datastore"
+	"github.com/hanzoai/zap-sidecar/internal/documentdb"
+	"github.com/hanzoai/zap-sidecar/internal/kv"
+	"github.com/hanzoai/zap-sidecar/internal/sql"
+)
+
+type Config struct {
+	Node     Node     `yaml:"node"`
+	Admin    Admin    `yaml:"admin"`
+	Reload   Reload   `yaml:"reload"`
+	Shutdown Shutdown `yaml:"shutdown"`
+	Auth     Auth     `yaml:"auth"`
+	ReadOnly bool     `yaml:"read_only"` // all backends; sections may set their own
+
+	SQL        *SQL        `yaml:"sql"`
+	KV         *KV         `yaml:"kv"`
+	Datastore  *Datastore  `yaml:"datastore"`
+	DocumentDB *DocumentDB `yaml:"documentdb"`
+
+	files []string // config file and ${file:...} references, for Files
+}
+
+type Node struct {
+	ID          string `yaml:"id"`
+	Port        int    `yaml:"port"`
+	ServiceType string `yaml:"service_type"`
+}
+
+type Admin struct {
+	Port int `yaml:"port"` // 0 disables the admin listener
+}
+
+// Reload controls hot reloading. SIGHUP always reloads; WatchInterval
+// additionally polls Files for changes.
+type Reload struct {
+	WatchInterval Duration `yaml:"watch_interval"` // 0 disables the watch
+}
+
+// Shutdown controls the drain on SIGINT/SIGTERM: in-flight requests get
+// GracePeriod to finish before they are canceled.
+type Shutdown struct {
+	GracePeriod Duration `yaml:"grace_period"` // default 25s
+}
+
+// DefaultGracePeriod leaves headroom under Kubernetes' default 30s
+// terminationGracePeriodSeconds.
+const DefaultGracePeriod = 25 * time.Second
+
+// Pool holds connection pool limits. Each backend honors the subset its
+// driver supports; see the per-backend Proxy methods.
+type Pool struct {
+	MaxConns        int      `yaml:"max_conns"`
+	MinConns        int      `yaml:"min_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns"`
+	MaxConnLifetime Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdleTime Duration `yaml:"max_conn_idle_time"`
+}
+
+// TLS configures client TLS to a backend. It is enabled when Enabled is
+// set or any file is given.
+type TLS struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type SQL struct {
+	DSN             string   `yaml:"dsn"`
+	Pool            Pool     `yaml:"pool"`
+	ConnectTimeout  Duration `yaml:"connect_timeout"`
+	QueryTimeout    Duration `yaml:"query_timeout"`
+	MaxQueryTimeout Duration `yaml:"max_query_timeout"` // default 5m; caps timeout_ms
+	TxIdleTimeout   Duration `yaml:"tx_idle_timeout"`
+	MaxResultBytes  int64    `yaml:"max_result_bytes"` // default 32 MiB
+	TLS             TLS      `yaml:"tls"`
+	ReadOnly        bool     `yaml:"read_only"`
+	// Statements preloads named statements, name to SQL.
+	Statements map[string]string `yaml:"statements"`
+}
+
+type KV struct {
+	Addr         string   `yaml:"addr"`
+	Username     string   `yaml:"username"`
+	Password     string   `yaml:"password"`
+	DB           int      `yaml:"db"`
+	Pool         Pool     `yaml:"pool"`
+	DialTimeout  Duration `yaml:"dial_timeout"`
+	ReadTimeout  Duration `yaml:"read_timeout"`
+	WriteTimeout Duration `yaml:"write_timeout"`
+	TLS          TLS      `yaml:"tls"`
+	ReadOnly     bool     `yaml:"read_only"`
+	// MaxStreamBlock caps /stream/read-group's block_ms; default 5s.
+	MaxStreamBlock Duration `yaml:"max_stream_block"`
+}
+
+type Datastore struct {
+	Addr         string   `yaml:"addr"`
+	User         string   `yaml:"user"`
+	Password     string   `yaml:"password"`
+	Database     string   `yaml:"database"`
+	Pool         Pool     `yaml:"pool"`
+	DialTimeout  Duration `yaml:"dial_timeout"`
+	QueryTimeout Duration `yaml:"query_timeout"`
+	TLS          TLS      `yaml:"tls"`
+	ReadOnly     bool     `yaml:"read_only"`
+}
+
+type DocumentDB struct {
+	Addr           string   `yaml:"addr"`
+	Database       string   `yaml:"database"`
+	Pool           Pool     `yaml:"pool"`
+	ConnectTimeout Duration `yaml:"connect_timeout"`
+	QueryTimeout   Duration `yaml:"query_timeout"`
+	TLS            TLS      `yaml:"tls"`
+	ReadOnly       bool     `yaml:"read_only"`
+}
+
+// Duration is a time.Duration written as a Go duration string ("30s").
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", n.Line, s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// Load reads, interpolates and validates the config file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	cfg.files = append([]string{path}, cfg.files...)
+	return cfg, nil
+}
+
+// Files returns the files the config was built from: the config file
+// itself, every ${file:...} reference, the TLS certificates and keys and
+// the auth policy file.
+func (c *Config) Files() []string {
+	files := append([]string(nil), c.files...)
+	var tlss []TLS
+	if c.SQL != nil {
+		tlss = append(tlss, c.SQL.TLS)
+	}
+	if c.KV != nil {
+		tlss = append(tlss, c.KV.TLS)
+	}
+	if c.Datastore != nil {
+		tlss = append(tlss, c.Datastore.TLS)
+	}
+	if c.DocumentDB != nil {
+		tlss = append(tlss, c.DocumentDB.TLS)
+	}
+	for _, t := range tlss {
+		for _, f := range []string{t.CAFile, t.CertFile, t.KeyFile} {
+			if f != "" {
+				files = append(files, f)
+			}
+		}
+	}
+	if c.Auth.PolicyFile != "" {
+		files = append(files, c.Auth.PolicyFile)
+	}
+	return files
+}
+
+// Parse interpolates and validates a config document.
+func Parse(data []byte) (*Config, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var files []string
+	if err := interpolate(&doc, &files); err != nil {
+		return nil, err
+	}
+	expanded, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{files: files}
+	dec := yaml.NewDecoder(bytes.NewReader(expanded))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return nil, err
+	}
+	cfg.setDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+var (
+	envRef  = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+	fileRef = regexp.MustCompile(`\$\{file:([^}]+)\}`)
+)
+
+// interpolate expands ${NAME}, ${NAME:-default} and ${file:/path} in
+// every scalar, appending each referenced file to files.
+func interpolate(n *yaml.Node, files *[]string) error {
+	if n.Kind == yaml.ScalarNode {
+		orig := n.Value
+		var missing []string
+		n.Value = envRef.ReplaceAllStringFunc(n.Value, func(ref string) string {
+			m := envRef.FindStringSubmatch(ref)
+			if v, ok := os.LookupEnv(m[1]); ok {
+				return v
+			}
+			if m[2] != "" {
+				return m[3]
+			}
+			missing = append(missing, m[1])
+			return ""
+		})
+		if len(missing) > 0 {
+			return fmt.Errorf("line %d: undefined environment variable %s", n.Line, missing[0])
+		}
+		var errs []error
+		n.Value = fileRef.ReplaceAllStringFunc(n.Value, func(ref string) string {
+			path := fileRef.FindStringSubmatch(ref)[1]
+			*files = append(*files, path)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				errs = append(errs, err)
+				return ""
+			}
+			return strings.TrimRight(string(data), "\r\n")
+		})
+		if len(errs) > 0 {
+			return fmt.Errorf("line %d: %w", n.Line, errs[0])
+		}
+		// Plain scalars are re-resolved so ${PORT} can fill an int, but an
+		// empty or null-looking value must stay a string.
+		if n.Value != orig && n.Style == 0 {
+			n.Tag = ""
+		}
+		switch n.Value {
+		case "", "~", "null", "Null", "NULL":
+			if n.Style == 0 {
+				n.Style = yaml.DoubleQuotedStyle
+			}
+		}
+		return nil
+	}
+	for _, c := range n.Content {
+		if err := interpolate(c, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) setDefaults() {
+	if c.Node.Port == 0 {
+		c.Node.Port = 9651
+	}
+	if c.Node.ServiceType == "" {
+		c.Node.ServiceType = "_hanzo._tcp"
+	}
+	if c.Node.ID == "" {
+		c.Node.ID = strings.Join(c.Modes(), "-")
+	}
+	if c.Shutdown.GracePeriod == 0 {
+		c.Shutdown.GracePeriod = Duration(DefaultGracePeriod)
+	}
+	if c.ReadOnly {
+		c.SetReadOnly()
+	}
+}
+
+// SetReadOnly puts every configured backend in read-only mode.
+func (c *Config) SetReadOnly() {
+	c.ReadOnly = true
+	if c.SQL != nil {
+		c.SQL.ReadOnly = true
+	}
+	if c.KV != nil {
+		c.KV.ReadOnly = true
+	}
+	if c.Datastore != nil {
+		c.Datastore.ReadOnly = true
+	}
+	if c.DocumentDB != nil {
+		c.DocumentDB.ReadOnly = true
+	}
+}
+
+// Modes returns the configured backends in a stable order.
+func (c *Config) Modes() []string {
+	var modes []string
+	if c.SQL != nil {
+		modes = append(modes, "sql")
+	}
+	if c.KV != nil {
+		modes = append(modes, "kv")
+	}
+	if c.Datastore != nil {
+		modes = append(modes, "datastore")
+	}
+	if c.DocumentDB != nil {
+		modes = append(modes, "documentdb")
+	}
+	return modes
+}
+
+// Validate reports every problem in the config, one per line.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(len(c.Modes()) > 0, "at least one of sql, kv, datastore or documentdb must be configured")
+	check(c.Node.Port > 0 && c.Node.Port < 65536, "node.port: %d out of range", c.Node.Port)
+	check(c.Admin.Port >= 0 && c.Admin.Port < 65536, "admin.port: %d out of range", c.Admin.Port)
+	check(c.Admin.Port == 0 || c.Admin.Port != c.Node.Port, "admin.port: must differ from node.port")
+	check(c.Reload.WatchInterval >= 0, "reload.watch_interval: must not be negative")
+	check(c.Shutdown.GracePeriod >= 0, "shutdown.grace_period: must not be negative")
+	if _, err := c.Auth.Authenticator(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if s := c.SQL; s != nil {
+		check(s.DSN != "", "sql.dsn: required")
+		errs = append(errs, s.Pool.validate("sql.pool")...)
+		check(s.ConnectTimeout >= 0, "sql.connect_timeout: must not be negative")
+		check(s.QueryTimeout >= 0, "sql.query_timeout: must not be negative")
+		check(s.MaxQueryTimeout >= 0, "sql.max_query_timeout: must not be negative")
+		check(s.TxIdleTimeout >= 0, "sql.tx_idle_timeout: must not be negative")
+		check(s.MaxResultBytes >= 0, "sql.max_result_bytes: must not be negative")
+		for name, stmt := range s.Statements {
+			check(name != "" && stmt != "", "sql.statements.%s: name and SQL required", name)
+		}
+		errs = append(errs, s.TLS.validate("sql.tls")...)
+	}
+	if k := c.KV; k != nil {
+		check(k.Addr != "", "kv.addr: required")
+		check(k.DB >= 0, "kv.db: must not be negative")
+		errs = append(errs, k.Pool.validate("kv.pool")...)
+		check(k.DialTimeout >= 0, "kv.dial_timeout: must not be negative")
+		check(k.ReadTimeout >= 0, "kv.read_timeout: must not be negative")
+		check(k.WriteTimeout >= 0, "kv.write_timeout: must not be negative")
+		check(k.MaxStreamBlock >= 0, "kv.max_stream_block: must not be negative")
+		errs = append(errs, k.TLS.validate("kv.tls")...)
+	}
+	if d := c.Datastore; d != nil {
+		check(d.Addr != "", "datastore.addr: required")
+		errs = append(errs, d.Pool.validate("datastore.pool")...)
+		check(d.DialTimeout >= 0, "datastore.dial_timeout: must not be negative")
+		check(d.QueryTimeout >= 0, "datastore.query_timeout: must not be negative")
+		errs = append(errs, d.TLS.validate("datastore.tls")...)
+	}
+	if d := c.DocumentDB; d != nil {
+		check(d.Addr != "", "documentdb.addr: required")
+		errs = append(errs, d.Pool.validate("documentdb.pool")...)
+		check(d.ConnectTimeout >= 0, "documentdb.connect_timeout: must not be negative")
+		check(d.QueryTimeout >= 0, "documentdb.query_timeout: must not be negative")
+		errs = append(errs, d.TLS.validate("documentdb.tls")...)
+	}
+	return errors.Join(errs...)
+}
+
+func (p Pool) validate(prefix string) []error {
+	var errs []error
+	if p.MaxConns < 0 || p.MinConns < 0 || p.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("%s: connection counts must not be negative", prefix))
+	}
+	if p.MaxConns > 0 && p.MinConns > p.MaxConns {
+		errs = append(errs, fmt.Errorf("%s.min_conns: %d exceeds max_conns %d", prefix, p.MinConns, p.MaxConns))
+	}
+	if p.MaxConns > 0 && p.MaxIdleConns > p.MaxConns {
+		errs = append(errs, fmt.Errorf("%s.max_idle_conns: %d exceeds max_conns %d", prefix, p.MaxIdleConns, p.MaxConns))
+	}
+	if p.MaxConnLifetime < 0 || p.MaxConnIdleTime < 0 {
+		errs = append(errs, fmt.Errorf("%s: durations must not be negative", prefix))
+	}
+	return errs
+}
+
+func (t TLS) enabled() bool {
+	return t.Enabled || t.CAFile != "" || t.CertFile != "" || t.KeyFile != ""
+}
+
+func (t TLS) validate(prefix string) []error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return []error{fmt.Errorf("%s: cert_file and key_file must be set together", prefix)}
+	}
+	if _, err := t.Build(); err != nil {
+		return []error{fmt.Errorf("%s: %w", prefix, err)}
+	}
+	return nil
+}
+
+// Build returns the client TLS config, or nil when TLS is not enabled.
+func (t TLS) Build() (*tls.Config, error) {
+	if !t.enabled() {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// ================================================================
+// Proxy configs
+// ================================================================
+
+func (s *SQL) Proxy() (sql.Config, error) {
+	tlsCfg, err := s.TLS.Build()
+	if err != nil {
+		return sql.Config{}, err
+	}
+	return sql.Config{
+		DSN:             s.DSN,
+		MaxConns:        int32(s.Pool.MaxConns),
+		MinConns:        int32(s.Pool.MinConns),
+		MaxConnLifetime: time.Duration(s.Pool.MaxConnLifetime),
+		MaxConnIdleTime: time.Duration(s.Pool.MaxConnIdleTime),
+		ConnectTimeout:  time.Duration(s.ConnectTimeout),
+		QueryTimeout:    time.Duration(s.QueryTimeout),
+		MaxQueryTimeout: time.Duration(s.MaxQueryTimeout),
+		TxIdleTimeout:   time.Duration(s.TxIdleTimeout),
+		MaxResultBytes:  s.MaxResultBytes,
+		Statements:      s.Statements,
+		TLS:             tlsCfg,
+		ReadOnly:        s.ReadOnly,
+	}, nil
+}
+
+// Proxy maps max_conns to PoolSize and min_conns to MinIdleConns.
+func (k *KV) Proxy() (kv.Config, error) {
+	tlsCfg, err := k.TLS.Build()
+	if err != nil {
+		return kv.Config{}, err
+	}
+	return kv.Config{
+		Addr:           k.Addr,
+		Username:       k.Username,
+		Password:       k.Password,
+		DB:             k.DB,
+		PoolSize:       k.Pool.MaxConns,
+		MinIdleConns:   k.Pool.MinConns,
+		DialTimeout:    time.Duration(k.DialTimeout),
+		ReadTimeout:    time.Duration(k.ReadTimeout),
+		WriteTimeout:   time.Duration(k.WriteTimeout),
+		TLS:            tlsCfg,
+		ReadOnly:       k.ReadOnly,
+		MaxStreamBlock: time.Duration(k.MaxStreamBlock),
+	}, nil
+}
+
+func (d *Datastore) Proxy() (datastore.Config, error) {
+	tlsCfg, err := d.TLS.Build()
+	if err != nil {
+		return datastore.Config{}, err
+	}
+	return datastore.Config{
+		Addr:            d.Addr,
+		User:            d.User,
+		Password:        d.Password,
+		Database:        d.Database,
+		MaxOpenConns:    d.Pool.MaxConns,
+		MaxIdleConns:    d.Pool.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(d.Pool.MaxConnLifetime),
+		DialTimeout:     time.Duration(d.DialTimeout),
+		QueryTimeout:    time.Duration(d.QueryTimeout),
+		TLS:             tlsCfg,
+		ReadOnly:        d.ReadOnly,
+	}, nil
+}
+
+func (d *DocumentDB) Proxy() (documentdb.Config, error) {
+	tlsCfg, err := d.TLS.Build()
+	if err != nil {
+		return documentdb.Config{}, err
+	}
+	return documentdb.Config{
+		Addr:            d.Addr,
+		Database:        d.Database,
+		MaxPoolSize:     uint64(d.Pool.MaxConns),
+		MinPoolSize:     uint64(d.Pool.MinConns),
+		MaxConnIdleTime: time.Duration(d.Pool.MaxConnIdleTime),
+		ConnectTimeout:  time.Duration(d.ConnectTimeout),
+		QueryTimeout:    time.Duration(d.QueryTimeout),
+		TLS:             tlsCfg,
+		ReadOnly:        d.ReadOnly,
+	}, nil
+}
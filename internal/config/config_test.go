@@ -0,0 +1,136 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseInterpolation(t *testing.T) {
+	t.Setenv("ZAP_TEST_ADDR", "cache:6379")
+	t.Setenv("ZAP_TEST_PORT", "9700")
+	t.Setenv("ZAP_TEST_EMPTY", "")
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		doc     string
+		check   func(*Config) bool
+		wantErr string
+	}{
+		{
+			doc:   "kv:\n  addr: ${ZAP_TEST_ADDR}",
+			check: func(c *Config) bool { return c.KV.Addr == "cache:6379" },
+		},
+		{
+			doc:   "node:\n  port: ${ZAP_TEST_PORT}\nkv: {addr: a:1}",
+			check: func(c *Config) bool { return c.Node.Port == 9700 },
+		},
+		{
+			doc:   "kv: {addr: a:1, password: '${ZAP_TEST_UNSET:-fallback}'}",
+			check: func(c *Config) bool { return c.KV.Password == "fallback" },
+		},
+		{
+			doc:   "kv:\n  addr: a:1\n  password: ${ZAP_TEST_EMPTY}",
+			check: func(c *Config) bool { return c.KV.Password == "" },
+		},
+		{
+			doc:   "kv: {addr: a:1, password: '${file:" + secret + "}'}",
+			check: func(c *Config) bool { return c.KV.Password == "s3cret" && slices.Contains(c.Files(), secret) },
+		},
+		{doc: "kv:\n  addr: ${ZAP_TEST_UNSET}", wantErr: "undefined environment variable ZAP_TEST_UNSET"},
+		{doc: "kv: {addr: a:1, password: '${file:/nonexistent/zap-test}'}", wantErr: "/nonexistent/zap-test"},
+		{doc: "kv: {addr: a:1, adress: b:2}", wantErr: "adress"},
+		{doc: "kv: {addr: a:1, dial_timeout: soon}", wantErr: `invalid duration "soon"`},
+	}
+	for _, tt := range tests {
+		cfg, err := Parse([]byte(tt.doc))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: err = %v, want it to contain %q", tt.doc, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.doc, err)
+			continue
+		}
+		if !tt.check(cfg) {
+			t.Errorf("%q: unexpected config %+v", tt.doc, cfg)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		doc     string
+		wantErr []string
+	}{
+		{doc: "sql: {dsn: postgres://localhost/app}"},
+		{doc: "node: {id: x}", wantErr: []string{"at least one of sql, kv, datastore or documentdb"}},
+		{
+			doc:     "node: {port: 70000}\nadmin: {port: 70000}\nkv: {addr: a:1}",
+			wantErr: []string{"node.port: 70000 out of range", "admin.port: 70000 out of range"},
+		},
+		{doc: "node: {port: 9651}\nadmin: {port: 9651}\nkv: {addr: a:1}", wantErr: []string{"admin.port: must differ from node.port"}},
+		{
+			doc:     "sql: {query_timeout: -1s}\nkv: {db: -1, max_stream_block: -1s}",
+			wantErr: []string{"sql.dsn: required", "sql.query_timeout", "kv.addr: required", "kv.db", "kv.max_stream_block"},
+		},
+		{
+			doc:     "kv: {addr: a:1, pool: {max_conns: 2, min_conns: 3, max_idle_conns: 4}}",
+			wantErr: []string{"kv.pool.min_conns: 3 exceeds max_conns 2", "kv.pool.max_idle_conns: 4 exceeds max_conns 2"},
+		},
+		{doc: "shutdown: {grace_period: -1s}\nkv: {addr: a:1}", wantErr: []string{"shutdown.grace_period: must not be negative"}},
+		{doc: "kv: {addr: a:1, tls: {cert_file: /c.pem}}", wantErr: []string{"kv.tls: cert_file and key_file must be set together"}},
+		{doc: "sql: {dsn: x, statements: {get_user: ''}}", wantErr: []string{"sql.statements.get_user: name and SQL required"}},
+		{doc: "kv: {addr: a:1}\nauth: {mode: token}", wantErr: []string{"needs a secret or static tokens"}},
+		{
+			doc:     "kv: {addr: a:1}\nauth: {mode: token, tokens: [{caller: a, token: t}, {caller: b, token: t}]}",
+			wantErr: []string{`tokens[1]: token already stands for caller "a"`},
+		},
+		{doc: "kv: {addr: a:1}\nauth: {mode: token, hmac_secret: s, policy_file: /nonexistent.yaml}", wantErr: []string{"auth.policy_file"}},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.doc))
+		if len(tt.wantErr) == 0 {
+			if err != nil {
+				t.Errorf("%q: %v", tt.doc, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%q: no error, want %q", tt.doc, tt.wantErr)
+			continue
+		}
+		for _, want := range tt.wantErr {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%q: err = %v, want it to contain %q", tt.doc, err, want)
+			}
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	cfg, err := Parse([]byte("read_only: true\nsql: {dsn: x}\nkv: {addr: a:1}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Node.Port != 9651 || cfg.Node.ID != "sql-kv" || cfg.Node.ServiceType != "_hanzo._tcp" {
+		t.Errorf("node = %+v", cfg.Node)
+	}
+	if time.Duration(cfg.Shutdown.GracePeriod) != DefaultGracePeriod {
+		t.Errorf("grace period = %v, want %v", time.Duration(cfg.Shutdown.GracePeriod), DefaultGracePeriod)
+	}
+	if !cfg.SQL.ReadOnly || !cfg.KV.ReadOnly {
+		t.Errorf("read_only not applied to every backend: sql %v, kv %v", cfg.SQL.ReadOnly, cfg.KV.ReadOnly)
+	}
+	if got := cfg.Modes(); !slices.Equal(got, []string{"sql", "kv"}) {
+		t.Errorf("Modes() = %v", got)
+	}
+}
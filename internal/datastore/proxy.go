@@ -3,26 +3,39 @@
 //
 // Accepts ZAP connections and translates to ClickHouse native protocol.
 // Optimized for bulk insert of AI telemetry, ad-tech analytics, and traces.
-// Exposes MCP-compatible tools: datastore_query, datastore_insert, datastore_exec.
+// Exposes MCP-compatible tools: datastore_query, datastore_insert, datastore_exec,
+// served over /tools/list and /tools/call alongside /resources/list and
+// /resources/read.
 package datastore
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal"
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+	"github.com/hanzoai/zap-sidecar/internal/drain"
+	"github.com/hanzoai/zap-sidecar/internal/metrics"
 )
 
 const MsgTypeDatastore uint16 = 302
 
+// writePaths modify data and are rejected in read-only mode.
+var writePaths = map[string]bool{"/exec": true, "/insert": true}
+
 const (
-	fieldPath = 4
-	fieldBody = 12
+	fieldPath    = 4
+	fieldHeaders = 8
+	fieldBody    = 12
 
 	respStatus  = 0
 	respBody    = 4
@@ -30,59 +43,44 @@ const (
 )
 
 type Config struct {
-	NodeID      string
-	Port        int
-	ServiceType string
-	Addr        string // host:9000 (native TCP)
-	User        string
-	Password    string
-	Database    string
+	Addr     string // host:9000 (native TCP)
+	User     string
+	Password string
+	Database string
+
+	// Pool and timeout overrides; zero keeps the defaults below.
+	MaxOpenConns    int           // default 10
+	MaxIdleConns    int           // default 5
+	ConnMaxLifetime time.Duration // default 1h
+	DialTimeout     time.Duration // default 10s
+	QueryTimeout    time.Duration // default 30s; inserts get twice this
+
+	TLS *tls.Config
+
+	// ReadOnly rejects every path that modifies data.
+	ReadOnly bool
 }
 
 type Proxy struct {
-	node     *zap.Node
-	conn     clickhouse.Conn
-	database string
-	logger   *slog.Logger
+	node         *zap.Node
+	conn         atomic.Value // clickhouse.Conn, swapped by Reload
+	database     string
+	queryTimeout atomic.Int64 // time.Duration
+	readOnly     atomic.Bool
+	logger       *slog.Logger
 }
 
 func New(ctx context.Context, logger *slog.Logger, cfg Config) (*Proxy, error) {
-	if cfg.Database == "" {
-		cfg.Database = "default"
-	}
-	if cfg.User == "" {
-		cfg.User = "default"
-	}
-
-	// Connect via native TCP protocol (port 9000)
-	opts := &clickhouse.Options{
-		Addr: []string{cfg.Addr},
-		Auth: clickhouse.Auth{
-			Database: cfg.Database,
-			Username: cfg.User,
-			Password: cfg.Password,
-		},
-		DialTimeout:      10 * time.Second,
-		MaxOpenConns:     10,
-		MaxIdleConns:     5,
-		ConnMaxLifetime:  time.Hour,
-		ConnOpenStrategy: clickhouse.ConnOpenInOrder,
-	}
+	cfg = withDefaults(cfg)
 
 	var conn clickhouse.Conn
 	var err error
 
 	// Retry loop — wait for ClickHouse to be ready (Docker startup order)
 	for i := 0; i < 30; i++ {
-		conn, err = clickhouse.Open(opts)
+		conn, err = connect(ctx, cfg)
 		if err == nil {
-			pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-			err = conn.Ping(pingCtx)
-			cancel()
-			if err == nil {
-				break
-			}
-			conn.Close()
+			break
 		}
 		logger.Warn("datastore not ready, retrying", "attempt", i+1, "error", err)
 		time.Sleep(2 * time.Second)
@@ -92,60 +90,189 @@ func New(ctx context.Context, logger *slog.Logger, cfg Config) (*Proxy, error) {
 	}
 
 	p := &Proxy{
-		conn:     conn,
 		database: cfg.Database,
 		logger:   logger,
 	}
+	p.conn.Store(conn)
+	p.queryTimeout.Store(int64(cfg.QueryTimeout))
+	p.readOnly.Store(cfg.ReadOnly)
+	metrics.OnScrape(p.poolStats)
 
-	node := zap.NewNode(zap.NodeConfig{
-		NodeID:      cfg.NodeID,
-		ServiceType: cfg.ServiceType,
-		Port:        cfg.Port,
-		Logger:      logger,
-	})
+	logger.Info("datastore sidecar ready (native TCP)", "addr", cfg.Addr, "db", cfg.Database)
+	return p, nil
+}
 
-	node.Handle(MsgTypeDatastore, func(_ context.Context, _ string, msg *zap.Message) (*zap.Message, error) {
-		return p.handle(msg), nil
-	})
+// Reload connects with cfg and swaps the new connection in. The old one
+// is closed once in-flight inserts have had time to finish. The database
+// is fixed for the life of the process.
+func (p *Proxy) Reload(ctx context.Context, cfg Config) error {
+	cfg = withDefaults(cfg)
+	if cfg.Database != p.database {
+		return fmt.Errorf("datastore: reload: database changed from %q to %q, restart required", p.database, cfg.Database)
+	}
+	conn, err := connect(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("datastore: reload: %w", err)
+	}
+	old, drain := p.db(), 2*p.timeout() // longest an insert may still run
+	p.conn.Store(conn)
+	p.queryTimeout.Store(int64(cfg.QueryTimeout))
+	p.readOnly.Store(cfg.ReadOnly)
+	time.AfterFunc(drain, func() { old.Close() })
+	return nil
+}
 
-	if err := node.Start(); err != nil {
+func withDefaults(cfg Config) Config {
+	if cfg.Database == "" {
+		cfg.Database = "default"
+	}
+	if cfg.User == "" {
+		cfg.User = "default"
+	}
+	if cfg.MaxOpenConns == 0 {
+		cfg.MaxOpenConns = 10
+	}
+	if cfg.MaxIdleConns == 0 {
+		cfg.MaxIdleConns = 5
+	}
+	if cfg.ConnMaxLifetime == 0 {
+		cfg.ConnMaxLifetime = time.Hour
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = 10 * time.Second
+	}
+	if cfg.QueryTimeout == 0 {
+		cfg.QueryTimeout = 30 * time.Second
+	}
+	return cfg
+}
+
+// connect opens a native TCP (port 9000) connection and pings it.
+func connect(ctx context.Context, cfg Config) (clickhouse.Conn, error) {
+	conn, err := clickhouse.Open(&clickhouse.Options{
+		Addr: []string{cfg.Addr},
+		Auth: clickhouse.Auth{
+			Database: cfg.Database,
+			Username: cfg.User,
+			Password: cfg.Password,
+		},
+		DialTimeout:      cfg.DialTimeout,
+		MaxOpenConns:     cfg.MaxOpenConns,
+		MaxIdleConns:     cfg.MaxIdleConns,
+		ConnMaxLifetime:  cfg.ConnMaxLifetime,
+		ConnOpenStrategy: clickhouse.ConnOpenInOrder,
+		TLS:              cfg.TLS,
+	})
+	if err != nil {
+		return nil, err
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := conn.Ping(pingCtx); err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("datastore: node start failed: %w", err)
+		return nil, err
 	}
+	return conn, nil
+}
+
+// db returns the current connection.
+func (p *Proxy) db() clickhouse.Conn {
+	return p.conn.Load().(clickhouse.Conn)
+}
+
+// timeout returns the current query timeout.
+func (p *Proxy) timeout() time.Duration {
+	return time.Duration(p.queryTimeout.Load())
+}
 
+// Register installs the proxy's handler for MsgTypeDatastore on node.
+func (p *Proxy) Register(ctx context.Context, node *zap.Node) {
 	p.node = node
-	logger.Info("datastore sidecar ready (native TCP)", "addr", cfg.Addr, "db", cfg.Database)
-	return p, nil
+	node.Handle(MsgTypeDatastore, func(_ context.Context, _ string, msg *zap.Message) (*zap.Message, error) {
+		return p.handle(ctx, msg), nil
+	})
 }
 
+// Stop closes the backend connection. The node is owned by the caller.
 func (p *Proxy) Stop() {
-	if p.node != nil {
-		p.node.Stop()
+	if conn, ok := p.conn.Load().(clickhouse.Conn); ok {
+		conn.Close()
 	}
-	if p.conn != nil {
-		p.conn.Close()
+}
+
+// Ready reports whether the backend answers the /health check.
+func (p *Proxy) Ready(ctx context.Context) error {
+	if status, body := unpack(p.health(ctx)); status != http.StatusOK {
+		return fmt.Errorf("datastore: %s", body)
 	}
+	return nil
+}
+
+func (p *Proxy) poolStats() {
+	st := p.db().Stats()
+	metrics.PoolConns.Set(float64(st.Open), "datastore", "open")
+	metrics.PoolConns.Set(float64(st.Idle), "datastore", "idle")
+	metrics.PoolConns.Set(float64(st.MaxOpenConns), "datastore", "max")
 }
 
-func (p *Proxy) handle(msg *zap.Message) *zap.Message {
+func (p *Proxy) handle(ctx context.Context, msg *zap.Message) *zap.Message {
 	root := msg.Root()
 	path := root.Text(fieldPath)
-	body := root.Bytes(fieldBody)
+	done := metrics.Start("datastore", path)
+	if !drain.Enter() {
+		done(http.StatusServiceUnavailable)
+		return respond(http.StatusServiceUnavailable, map[string]string{"error": drain.ErrDraining.Error()})
+	}
+	defer drain.Leave()
+
+	id, err := auth.Authenticate(root.Bytes(fieldHeaders))
+	if err != nil {
+		done(http.StatusUnauthorized)
+		return respond(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	ctx = auth.WithIdentity(ctx, id)
 
+	resp := p.route(ctx, path, root.Bytes(fieldBody))
+	if ctx.Err() != nil {
+		resp = respond(http.StatusServiceUnavailable, map[string]string{"error": drain.ErrAborted.Error()})
+	}
+	status, _ := unpack(resp)
+	done(status)
+	return resp
+}
+
+func (p *Proxy) route(ctx context.Context, path string, body []byte) *zap.Message {
+	// /tools/call is authorized on the path of the tool it dispatches to.
+	if path != "/tools/call" {
+		if err := auth.Authorize(ctx, "datastore", path); err != nil {
+			return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+	if writePaths[path] && p.readOnly.Load() {
+		return respond(http.StatusForbidden, map[string]string{"error": "read-only mode: " + path + " is disabled"})
+	}
 	switch path {
 	case "/health":
-		return p.health()
+		return p.health(ctx)
 	case "/query":
-		return p.query(body)
+		return p.query(ctx, body)
 	case "/exec":
-		return p.exec(body)
+		return p.exec(ctx, body)
 	case "/insert":
-		return p.insert(body)
+		return p.insert(ctx, body)
 	case "/tables":
-		return p.tables(body)
+		return p.tables(ctx, body)
+	case "/tools/list":
+		return respond(http.StatusOK, map[string]interface{}{"tools": internal.DatastoreTools})
+	case "/tools/call":
+		return p.callTool(ctx, body)
+	case "/resources/list":
+		return respond(http.StatusOK, map[string]interface{}{"resources": internal.DatastoreResources})
+	case "/resources/read":
+		return p.readResource(ctx, body)
 	default:
 		if len(body) > 0 {
-			return p.query(body)
+			return p.query(ctx, body)
 		}
 		return respond(http.StatusNotFound, map[string]string{"error": "unknown: " + path})
 	}
@@ -160,16 +287,18 @@ type dsQuery struct {
 	Database string `json:"database,omitempty"`
 }
 
-func (p *Proxy) query(body []byte) *zap.Message {
+func (p *Proxy) query(ctx context.Context, body []byte) *zap.Message {
 	var req dsQuery
 	if err := json.Unmarshal(body, &req); err != nil {
 		req.SQL = string(body)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, p.timeout())
 	defer cancel()
+	// readonly=1 makes ClickHouse refuse anything but reads.
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{"readonly": 1}))
 
-	rows, err := p.conn.Query(ctx, req.SQL)
+	rows, err := p.db().Query(ctx, req.SQL)
 	if err != nil {
 		return respond(http.StatusBadGateway, map[string]string{"error": err.Error()})
 	}
@@ -216,16 +345,16 @@ type dsExec struct {
 	Args []interface{} `json:"args,omitempty"`
 }
 
-func (p *Proxy) exec(body []byte) *zap.Message {
+func (p *Proxy) exec(ctx context.Context, body []byte) *zap.Message {
 	var req dsExec
 	if err := json.Unmarshal(body, &req); err != nil {
 		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, p.timeout())
 	defer cancel()
 
-	err := p.conn.Exec(ctx, req.SQL, req.Args...)
+	err := p.db().Exec(ctx, req.SQL, req.Args...)
 	if err != nil {
 		return respond(http.StatusBadGateway, map[string]string{"error": err.Error()})
 	}
@@ -243,7 +372,7 @@ type insertReq struct {
 	Rows     []map[string]interface{} `json:"rows"`
 }
 
-func (p *Proxy) insert(body []byte) *zap.Message {
+func (p *Proxy) insert(ctx context.Context, body []byte) *zap.Message {
 	var req insertReq
 	if err := json.Unmarshal(body, &req); err != nil {
 		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
@@ -275,10 +404,10 @@ func (p *Proxy) insert(body []byte) *zap.Message {
 	}
 	sql := fmt.Sprintf("INSERT INTO %s (%s)", table, colList)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 2*p.timeout())
 	defer cancel()
 
-	batch, err := p.conn.PrepareBatch(ctx, sql)
+	batch, err := p.db().PrepareBatch(ctx, sql)
 	if err != nil {
 		return respond(http.StatusBadGateway, map[string]string{"error": err.Error()})
 	}
@@ -313,7 +442,7 @@ type tablesReq struct {
 	Database string `json:"database,omitempty"`
 }
 
-func (p *Proxy) tables(body []byte) *zap.Message {
+func (p *Proxy) tables(ctx context.Context, body []byte) *zap.Message {
 	var req tablesReq
 	json.Unmarshal(body, &req)
 	db := req.Database
@@ -321,10 +450,10 @@ func (p *Proxy) tables(body []byte) *zap.Message {
 		db = p.database
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	rows, err := p.conn.Query(ctx,
+	rows, err := p.db().Query(ctx,
 		"SELECT name, engine, total_rows, total_bytes FROM system.tables WHERE database = ?", db)
 	if err != nil {
 		return respond(http.StatusBadGateway, map[string]string{"error": err.Error()})
@@ -352,19 +481,97 @@ func (p *Proxy) tables(body []byte) *zap.Message {
 	})
 }
 
+// ================================================================
+// hanzo://datastore/tables — table definitions with columns
+// ================================================================
+
+type tableDef struct {
+	Name        string      `json:"name"`
+	Engine      string      `json:"engine"`
+	CreateQuery string      `json:"create_query"`
+	TotalRows   *uint64     `json:"total_rows"`
+	TotalBytes  *uint64     `json:"total_bytes"`
+	Columns     []columnDef `json:"columns"`
+}
+
+type columnDef struct {
+	Name              string `json:"name"`
+	Type              string `json:"type"`
+	DefaultKind       string `json:"default_kind,omitempty"`
+	DefaultExpression string `json:"default_expression,omitempty"`
+	Comment           string `json:"comment,omitempty"`
+	InPrimaryKey      bool   `json:"in_primary_key"`
+	InSortingKey      bool   `json:"in_sorting_key"`
+}
+
+func (p *Proxy) tableDefs(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rows, err := p.db().Query(ctx,
+		"SELECT name, engine, create_table_query, total_rows, total_bytes FROM system.tables WHERE database = ? ORDER BY name", p.database)
+	if err != nil {
+		return nil, fmt.Errorf("system.tables: %w", err)
+	}
+	var tables []*tableDef
+	byName := make(map[string]*tableDef)
+	for rows.Next() {
+		t := &tableDef{Columns: []columnDef{}}
+		if err := rows.Scan(&t.Name, &t.Engine, &t.CreateQuery, &t.TotalRows, &t.TotalBytes); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("system.tables: %w", err)
+		}
+		tables = append(tables, t)
+		byName[t.Name] = t
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("system.tables: %w", err)
+	}
+
+	rows, err = p.db().Query(ctx,
+		`SELECT table, name, type, default_kind, default_expression, comment, is_in_primary_key, is_in_sorting_key
+		FROM system.columns WHERE database = ? ORDER BY table, position`, p.database)
+	if err != nil {
+		return nil, fmt.Errorf("system.columns: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		var c columnDef
+		var inPK, inSK uint8
+		if err := rows.Scan(&table, &c.Name, &c.Type, &c.DefaultKind, &c.DefaultExpression, &c.Comment, &inPK, &inSK); err != nil {
+			return nil, fmt.Errorf("system.columns: %w", err)
+		}
+		c.InPrimaryKey = inPK == 1
+		c.InSortingKey = inSK == 1
+		if t := byName[table]; t != nil {
+			t.Columns = append(t.Columns, c)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("system.columns: %w", err)
+	}
+
+	return map[string]interface{}{
+		"database": p.database,
+		"tables":   tables,
+	}, nil
+}
+
 // ================================================================
 // /health — native ping
 // ================================================================
 
-func (p *Proxy) health() *zap.Message {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (p *Proxy) health(ctx context.Context) *zap.Message {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	if err := p.conn.Ping(ctx); err != nil {
+	if err := p.db().Ping(ctx); err != nil {
 		return respond(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
 	}
 
-	info, _ := p.conn.ServerVersion()
+	info, _ := p.db().ServerVersion()
 	ver := ""
 	if info != nil {
 		ver = fmt.Sprintf("%d.%d.%d", info.Version.Major, info.Version.Minor, info.Version.Patch)
@@ -378,6 +585,44 @@ func (p *Proxy) health() *zap.Message {
 	})
 }
 
+// ================================================================
+// /tools/call, /resources/read — MCP catalog
+// ================================================================
+
+func (p *Proxy) callTool(ctx context.Context, body []byte) *zap.Message {
+	var call internal.ToolCall
+	if err := json.Unmarshal(body, &call); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	tool, ok := internal.FindTool(internal.DatastoreTools, call.Name)
+	if !ok {
+		return respond(http.StatusNotFound, map[string]string{"error": "unknown tool: " + call.Name})
+	}
+	status, out := unpack(p.route(ctx, tool.Path, call.Arguments))
+	return respond(http.StatusOK, internal.NewToolResult(status, out))
+}
+
+func (p *Proxy) readResource(ctx context.Context, body []byte) *zap.Message {
+	var req internal.ResourceRead
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	res, ok := internal.FindResource(internal.DatastoreResources, req.URI)
+	if !ok {
+		return respond(http.StatusNotFound, map[string]string{"error": "unknown resource: " + req.URI})
+	}
+
+	data, err := p.tableDefs(ctx)
+	if err != nil {
+		return respond(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	contents, err := internal.JSONContents(res, data)
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, map[string]interface{}{"contents": contents})
+}
+
 // ================================================================
 // ZAP response builders
 // ================================================================
@@ -393,3 +638,9 @@ func respond(status int, data interface{}) *zap.Message {
 	msg, _ := zap.Parse(b.Finish())
 	return msg
 }
+
+// unpack returns the status and body of a response built by respond.
+func unpack(msg *zap.Message) (int, []byte) {
+	root := msg.Root()
+	return int(root.Uint32(respStatus)), root.Bytes(respBody)
+}
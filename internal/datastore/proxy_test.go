@@ -0,0 +1,158 @@
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+
+	"github.com/hanzoai/zap-sidecar/internal"
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// testProxy connects to the ClickHouse server in
+// ZAP_SIDECAR_TEST_CLICKHOUSE (host:9000), skipping the test when it is
+// not set.
+func testProxy(t *testing.T) *Proxy {
+	t.Helper()
+	addr := os.Getenv("ZAP_SIDECAR_TEST_CLICKHOUSE")
+	if addr == "" {
+		t.Skip("ZAP_SIDECAR_TEST_CLICKHOUSE not set")
+	}
+	conn, err := clickhouse.Open(&clickhouse.Options{Addr: []string{addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	p := &Proxy{database: "default"}
+	p.conn.Store(conn)
+	return p
+}
+
+func TestReadResourceRequest(t *testing.T) {
+	p := &Proxy{}
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"uri": "hanzo://datastore/unknown"}`, http.StatusNotFound},
+		{`{"uri": `, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if status, body := unpack(p.readResource(context.Background(), []byte(tt.body))); status != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.body, status, tt.want, body)
+		}
+	}
+}
+
+func TestReadResourceTables(t *testing.T) {
+	p := testProxy(t)
+	ctx := context.Background()
+	for _, sql := range []string{
+		"DROP TABLE IF EXISTS zap_resource_test",
+		`CREATE TABLE zap_resource_test (
+			id UInt64,
+			name String DEFAULT 'x' COMMENT 'display name'
+		) ENGINE = MergeTree ORDER BY id`,
+	} {
+		if err := p.db().Exec(ctx, sql); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+	t.Cleanup(func() { p.db().Exec(context.Background(), "DROP TABLE zap_resource_test") })
+
+	status, body := unpack(p.readResource(context.Background(), []byte(`{"uri": "hanzo://datastore/tables"}`)))
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var out struct {
+		Contents []internal.ResourceContents `json:"contents"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil || len(out.Contents) != 1 {
+		t.Fatalf("contents %s: %v", body, err)
+	}
+	var defs struct {
+		Database string     `json:"database"`
+		Tables   []tableDef `json:"tables"`
+	}
+	if err := json.Unmarshal([]byte(out.Contents[0].Text), &defs); err != nil {
+		t.Fatal(err)
+	}
+	i := slices.IndexFunc(defs.Tables, func(t tableDef) bool { return t.Name == "zap_resource_test" })
+	if defs.Database != "default" || i < 0 {
+		t.Fatalf("table missing from %s", out.Contents[0].Text)
+	}
+	want := []columnDef{
+		{Name: "id", Type: "UInt64", InPrimaryKey: true, InSortingKey: true},
+		{Name: "name", Type: "String", DefaultKind: "DEFAULT", DefaultExpression: "'x'", Comment: "display name"},
+	}
+	if got := defs.Tables[i]; got.Engine != "MergeTree" || !slices.Equal(got.Columns, want) {
+		t.Errorf("table %+v, want MergeTree with columns %+v", got, want)
+	}
+}
+
+func TestReloadDatabaseChange(t *testing.T) {
+	p := &Proxy{database: "default"}
+	err := p.Reload(context.Background(), Config{Addr: "ch:9000", Database: "analytics"})
+	if err == nil || !strings.Contains(err.Error(), "restart required") {
+		t.Errorf("Reload = %v, want a restart required error", err)
+	}
+	if p.conn.Load() != nil {
+		t.Error("Reload swapped in a connection")
+	}
+}
+
+func TestRouteAuthorization(t *testing.T) {
+	a, err := auth.New(auth.Config{
+		Mode:   auth.ModeToken,
+		Secret: []byte("test-secret"),
+		Policy: []auth.Rule{{Caller: "reader", Allow: []string{"datastore_tables", "datastore:/resources/*"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Use(a)
+	t.Cleanup(func() { auth.Use(nil) })
+	ctx := auth.WithIdentity(context.Background(), &auth.Identity{Caller: "reader"})
+
+	p := &Proxy{}
+	tests := []struct {
+		path, body string
+		want       int
+		wantBody   string
+	}{
+		{"/resources/list", "", http.StatusOK, "hanzo://datastore/tables"},
+		{"/exec", `{"sql": "DROP TABLE t"}`, http.StatusForbidden, "forbidden"},
+		{"/tools/list", "", http.StatusForbidden, "forbidden"},
+		// A tool call is judged by the path of its tool, and a refusal
+		// comes back as a tool error.
+		{"/tools/call", `{"name": "datastore_exec", "arguments": {"sql": "DROP TABLE t"}}`, http.StatusOK, "forbidden"},
+		{"/tools/call", `{"name": "nope"}`, http.StatusNotFound, "unknown tool"},
+	}
+	for _, tt := range tests {
+		status, body := unpack(p.route(ctx, tt.path, []byte(tt.body)))
+		if status != tt.want || !strings.Contains(string(body), tt.wantBody) {
+			t.Errorf("%s %s: %d %s, want %d containing %q", tt.path, tt.body, status, body, tt.want, tt.wantBody)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	p := &Proxy{}
+	p.readOnly.Store(true)
+	for _, tt := range []struct{ path, body string }{
+		{"/exec", `{"sql": "DROP TABLE t"}`},
+		{"/insert", `{"table": "t", "rows": [{"a": 1}]}`},
+		{"/tools/call", `{"name": "datastore_exec", "arguments": {"sql": "DROP TABLE t"}}`},
+	} {
+		_, body := unpack(p.route(context.Background(), tt.path, []byte(tt.body)))
+		if !strings.Contains(string(body), "read-only mode") {
+			t.Errorf("%s %s: %s, want a read-only refusal", tt.path, tt.body, body)
+		}
+	}
+}
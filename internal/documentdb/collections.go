@@ -0,0 +1,71 @@
+package documentdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type collectionInfo struct {
+	Name    string          `json:"name"`
+	Type    string          `json:"type"`
+	Indexes []indexInfo     `json:"indexes"`
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+type indexInfo struct {
+	Name               string          `json:"name"`
+	Keys               json.RawMessage `json:"keys"`
+	Unique             bool            `json:"unique,omitempty"`
+	Sparse             bool            `json:"sparse,omitempty"`
+	ExpireAfterSeconds *int32          `json:"expire_after_seconds,omitempty"`
+}
+
+// collections lists the collections of the default database with their indexes.
+func (p *Proxy) collections(ctx context.Context) (map[string]interface{}, error) {
+	db := p.client.Load().Database(p.db)
+	specs, err := db.ListCollectionSpecifications(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("listCollections: %w", err)
+	}
+
+	colls := make([]collectionInfo, 0, len(specs))
+	for _, spec := range specs {
+		c := collectionInfo{Name: spec.Name, Type: spec.Type, Indexes: []indexInfo{}}
+		if len(spec.Options) > 0 {
+			c.Options = extJSON(spec.Options)
+		}
+		if spec.Type == "collection" {
+			idxs, err := db.Collection(spec.Name).Indexes().ListSpecifications(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("listIndexes %s: %w", spec.Name, err)
+			}
+			for _, idx := range idxs {
+				c.Indexes = append(c.Indexes, indexInfo{
+					Name:               idx.Name,
+					Keys:               extJSON(idx.KeysDocument),
+					Unique:             idx.Unique != nil && *idx.Unique,
+					Sparse:             idx.Sparse != nil && *idx.Sparse,
+					ExpireAfterSeconds: idx.ExpireAfterSeconds,
+				})
+			}
+		}
+		colls = append(colls, c)
+	}
+
+	return map[string]interface{}{
+		"database":    p.db,
+		"collections": colls,
+	}, nil
+}
+
+// extJSON renders a BSON document as relaxed Extended JSON, keeping key order.
+func extJSON(doc bson.Raw) json.RawMessage {
+	b, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return b
+}
@@ -5,54 +5,82 @@
 // of PostgreSQL, so this proxy enables document-store operations through
 // the ZAP zero-copy protocol.
 // Exposes MCP-compatible tools: documentdb_find, documentdb_insert,
-// documentdb_update, documentdb_delete, documentdb_health.
+// documentdb_update, documentdb_delete, documentdb_health, served over
+// /tools/list and /tools/call alongside /resources/list and /resources/read.
 package documentdb
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/luxfi/zap"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/hanzoai/zap-sidecar/internal"
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+	"github.com/hanzoai/zap-sidecar/internal/drain"
+	"github.com/hanzoai/zap-sidecar/internal/metrics"
 )
 
 const MsgTypeDocumentDB uint16 = 303
 
+// writePaths modify data and are rejected in read-only mode.
+var writePaths = map[string]bool{"/insert": true, "/update": true, "/delete": true}
+
 const (
-	fieldPath   = 4
-	fieldBody   = 12
-	respStatus  = 0
-	respBody    = 4
-	respHeaders = 8
+	fieldPath    = 4
+	fieldHeaders = 8
+	fieldBody    = 12
+	respStatus   = 0
+	respBody     = 4
+	respHeaders  = 8
 )
 
 type Config struct {
-	NodeID      string
-	Port        int
-	ServiceType string
-	Addr        string // MongoDB-compatible connection string (e.g. mongodb://localhost:27017)
-	Database    string // Default database name
+	Addr     string // MongoDB-compatible connection string (e.g. mongodb://localhost:27017)
+	Database string // Default database name
+
+	// Pool and timeout overrides; zero keeps the URI or driver default.
+	MaxPoolSize     uint64
+	MinPoolSize     uint64
+	MaxConnIdleTime time.Duration
+	ConnectTimeout  time.Duration
+	QueryTimeout    time.Duration
+
+	TLS *tls.Config
+
+	// ReadOnly rejects every path that modifies data.
+	ReadOnly bool
 }
 
+// drainTimeout bounds how long Reload waits for in-flight operations on a
+// replaced client before disconnecting it.
+const drainTimeout = 30 * time.Second
+
 type Proxy struct {
-	node   *zap.Node
-	client *mongo.Client
-	db     string
-	logger *slog.Logger
+	node     *zap.Node
+	client   atomic.Pointer[mongo.Client] // swapped by Reload
+	db       string
+	readOnly atomic.Bool
+	logger   *slog.Logger
 }
 
 func New(ctx context.Context, logger *slog.Logger, cfg Config) (*Proxy, error) {
 	var client *mongo.Client
 	var err error
 
+	opts := clientOptions(cfg)
 	for i := 0; i < 30; i++ {
-		client, err = mongo.Connect(options.Client().ApplyURI(cfg.Addr))
+		client, err = mongo.Connect(opts)
 		if err == nil {
 			if pingErr := client.Ping(ctx, nil); pingErr == nil {
 				break
@@ -68,48 +96,148 @@ func New(ctx context.Context, logger *slog.Logger, cfg Config) (*Proxy, error) {
 		time.Sleep(2 * time.Second)
 	}
 
-	db := cfg.Database
-	if db == "" {
-		db = "hanzo"
+	db := databaseName(cfg)
+	p := &Proxy{db: db, logger: logger}
+	p.client.Store(client)
+	p.readOnly.Store(cfg.ReadOnly)
+
+	logger.Info("documentdb sidecar ready", "addr", cfg.Addr, "database", db)
+	return p, nil
+}
+
+// Reload connects a new client from cfg and swaps it in. The old client
+// is disconnected once its in-flight operations finish or drainTimeout
+// passes. The default database is fixed for the life of the process.
+func (p *Proxy) Reload(ctx context.Context, cfg Config) error {
+	if db := databaseName(cfg); db != p.db {
+		return fmt.Errorf("documentdb: reload: database changed from %q to %q, restart required", p.db, db)
 	}
+	client, err := mongo.Connect(clientOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("documentdb: reload: %w", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(ctx)
+		return fmt.Errorf("documentdb: reload: %w", err)
+	}
+	old := p.client.Swap(client)
+	p.readOnly.Store(cfg.ReadOnly)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		_ = old.Disconnect(ctx)
+	}()
+	return nil
+}
 
-	p := &Proxy{client: client, db: db, logger: logger}
+func databaseName(cfg Config) string {
+	if cfg.Database == "" {
+		return "hanzo"
+	}
+	return cfg.Database
+}
 
-	node := zap.NewNode(zap.NodeConfig{
-		NodeID:      cfg.NodeID,
-		ServiceType: cfg.ServiceType,
-		Port:        cfg.Port,
-		Logger:      logger,
-	})
+func clientOptions(cfg Config) *options.ClientOptions {
+	opts := options.Client().ApplyURI(cfg.Addr).SetPoolMonitor(poolMonitor())
+	if cfg.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+	if cfg.MinPoolSize > 0 {
+		opts.SetMinPoolSize(cfg.MinPoolSize)
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(cfg.MaxConnIdleTime)
+	}
+	if cfg.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(cfg.ConnectTimeout)
+	}
+	if cfg.QueryTimeout > 0 {
+		opts.SetTimeout(cfg.QueryTimeout)
+	}
+	if cfg.TLS != nil {
+		opts.SetTLSConfig(cfg.TLS)
+	}
+	return opts
+}
 
+// Register installs the proxy's handler for MsgTypeDocumentDB on node.
+func (p *Proxy) Register(ctx context.Context, node *zap.Node) {
+	p.node = node
 	node.Handle(MsgTypeDocumentDB, func(_ context.Context, _ string, msg *zap.Message) (*zap.Message, error) {
 		return p.handle(ctx, msg), nil
 	})
+}
 
-	if err := node.Start(); err != nil {
-		_ = client.Disconnect(ctx)
-		return nil, fmt.Errorf("documentdb: node start failed: %w", err)
+// Stop closes the backend connection. The node is owned by the caller.
+func (p *Proxy) Stop() {
+	if client := p.client.Load(); client != nil {
+		_ = client.Disconnect(context.Background())
 	}
-
-	p.node = node
-	logger.Info("documentdb sidecar ready", "addr", cfg.Addr, "database", db)
-	return p, nil
 }
 
-func (p *Proxy) Stop() {
-	if p.node != nil {
-		p.node.Stop()
+// Ready reports whether the backend answers the /health check.
+func (p *Proxy) Ready(ctx context.Context) error {
+	if status, body := unpack(p.health(ctx)); status != http.StatusOK {
+		return fmt.Errorf("documentdb: %s", body)
 	}
-	if p.client != nil {
-		_ = p.client.Disconnect(context.Background())
+	return nil
+}
+
+// poolMonitor mirrors driver connection pool events into metrics.
+func poolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			metrics.PoolEvents.Inc("documentdb", e.Type)
+			switch e.Type {
+			case "ConnectionCreated":
+				metrics.PoolConns.Add(1, "documentdb", "total")
+			case "ConnectionClosed":
+				metrics.PoolConns.Add(-1, "documentdb", "total")
+			case "ConnectionCheckedOut":
+				metrics.PoolConns.Add(1, "documentdb", "acquired")
+			case "ConnectionCheckedIn":
+				metrics.PoolConns.Add(-1, "documentdb", "acquired")
+			}
+		},
 	}
 }
 
 func (p *Proxy) handle(ctx context.Context, msg *zap.Message) *zap.Message {
 	root := msg.Root()
 	path := root.Text(fieldPath)
-	body := root.Bytes(fieldBody)
+	done := metrics.Start("documentdb", path)
+	if !drain.Enter() {
+		done(http.StatusServiceUnavailable)
+		return respond(http.StatusServiceUnavailable, map[string]string{"error": drain.ErrDraining.Error()})
+	}
+	defer drain.Leave()
 
+	id, err := auth.Authenticate(root.Bytes(fieldHeaders))
+	if err != nil {
+		done(http.StatusUnauthorized)
+		return respond(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	ctx = auth.WithIdentity(ctx, id)
+
+	resp := p.route(ctx, path, root.Bytes(fieldBody))
+	if ctx.Err() != nil {
+		resp = respond(http.StatusServiceUnavailable, map[string]string{"error": drain.ErrAborted.Error()})
+	}
+	status, _ := unpack(resp)
+	done(status)
+	return resp
+}
+
+func (p *Proxy) route(ctx context.Context, path string, body []byte) *zap.Message {
+	// /tools/call is authorized on the path of the tool it dispatches to.
+	if path != "/tools/call" {
+		if err := auth.Authorize(ctx, "documentdb", path); err != nil {
+			return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+	if writePaths[path] && p.readOnly.Load() {
+		return respond(http.StatusForbidden, map[string]string{"error": "read-only mode: " + path + " is disabled"})
+	}
 	switch path {
 	case "/find":
 		return p.find(ctx, body)
@@ -121,6 +249,14 @@ func (p *Proxy) handle(ctx context.Context, msg *zap.Message) *zap.Message {
 		return p.del(ctx, body)
 	case "/health":
 		return p.health(ctx)
+	case "/tools/list":
+		return respond(http.StatusOK, map[string]interface{}{"tools": internal.DocumentDBTools})
+	case "/tools/call":
+		return p.callTool(ctx, body)
+	case "/resources/list":
+		return respond(http.StatusOK, map[string]interface{}{"resources": internal.DocumentDBResources})
+	case "/resources/read":
+		return p.readResource(ctx, body)
 	default:
 		return respond(http.StatusNotFound, map[string]string{"error": "unknown path: " + path})
 	}
@@ -144,7 +280,7 @@ func (p *Proxy) find(ctx context.Context, body []byte) *zap.Message {
 		db = req.Database
 	}
 
-	coll := p.client.Database(db).Collection(req.Collection)
+	coll := p.client.Load().Database(db).Collection(req.Collection)
 	opts := options.Find()
 	if req.Limit > 0 {
 		opts.SetLimit(req.Limit)
@@ -184,7 +320,7 @@ func (p *Proxy) insert(ctx context.Context, body []byte) *zap.Message {
 		db = req.Database
 	}
 
-	coll := p.client.Database(db).Collection(req.Collection)
+	coll := p.client.Load().Database(db).Collection(req.Collection)
 
 	docs := make([]interface{}, len(req.Documents))
 	for i, d := range req.Documents {
@@ -220,7 +356,7 @@ func (p *Proxy) update(ctx context.Context, body []byte) *zap.Message {
 		db = req.Database
 	}
 
-	coll := p.client.Database(db).Collection(req.Collection)
+	coll := p.client.Load().Database(db).Collection(req.Collection)
 	result, err := coll.UpdateMany(ctx, req.Filter, req.Update)
 	if err != nil {
 		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
@@ -249,7 +385,7 @@ func (p *Proxy) del(ctx context.Context, body []byte) *zap.Message {
 		db = req.Database
 	}
 
-	coll := p.client.Database(db).Collection(req.Collection)
+	coll := p.client.Load().Database(db).Collection(req.Collection)
 	result, err := coll.DeleteMany(ctx, req.Filter)
 	if err != nil {
 		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
@@ -261,12 +397,46 @@ func (p *Proxy) del(ctx context.Context, body []byte) *zap.Message {
 }
 
 func (p *Proxy) health(ctx context.Context) *zap.Message {
-	if err := p.client.Ping(ctx, nil); err != nil {
+	if err := p.client.Load().Ping(ctx, nil); err != nil {
 		return respond(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
 	}
 	return respond(http.StatusOK, map[string]string{"status": "ok", "service": "hanzo-documentdb"})
 }
 
+func (p *Proxy) callTool(ctx context.Context, body []byte) *zap.Message {
+	var call internal.ToolCall
+	if err := json.Unmarshal(body, &call); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	tool, ok := internal.FindTool(internal.DocumentDBTools, call.Name)
+	if !ok {
+		return respond(http.StatusNotFound, map[string]string{"error": "unknown tool: " + call.Name})
+	}
+	status, out := unpack(p.route(ctx, tool.Path, call.Arguments))
+	return respond(http.StatusOK, internal.NewToolResult(status, out))
+}
+
+func (p *Proxy) readResource(ctx context.Context, body []byte) *zap.Message {
+	var req internal.ResourceRead
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	res, ok := internal.FindResource(internal.DocumentDBResources, req.URI)
+	if !ok {
+		return respond(http.StatusNotFound, map[string]string{"error": "unknown resource: " + req.URI})
+	}
+
+	data, err := p.collections(ctx)
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	contents, err := internal.JSONContents(res, data)
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, map[string]interface{}{"contents": contents})
+}
+
 func respond(status int, data interface{}) *zap.Message {
 	b := zap.NewBuilder(4096)
 	ob := b.StartObject(12)
@@ -278,3 +448,9 @@ func respond(status int, data interface{}) *zap.Message {
 	msg, _ := zap.Parse(b.Finish())
 	return msg
 }
+
+// unpack returns the status and body of a response built by respond.
+func unpack(msg *zap.Message) (int, []byte) {
+	root := msg.Root()
+	return int(root.Uint32(respStatus)), root.Bytes(respBody)
+}
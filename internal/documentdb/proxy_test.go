@@ -0,0 +1,166 @@
+package documentdb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/hanzoai/zap-sidecar/internal"
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// testProxy connects to the server in ZAP_SIDECAR_TEST_MONGODB (a
+// connection string), skipping the test when it is not set. Its default
+// database is dropped when the test ends.
+func testProxy(t *testing.T) *Proxy {
+	t.Helper()
+	uri := os.Getenv("ZAP_SIDECAR_TEST_MONGODB")
+	if uri == "" {
+		t.Skip("ZAP_SIDECAR_TEST_MONGODB not set")
+	}
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proxy{db: "zap_sidecar_test"}
+	p.client.Store(client)
+	t.Cleanup(func() {
+		ctx := context.Background()
+		client.Database(p.db).Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return p
+}
+
+func TestReadResourceRequest(t *testing.T) {
+	p := &Proxy{}
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"uri": "hanzo://documentdb/unknown"}`, http.StatusNotFound},
+		{`{"uri": `, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if status, body := unpack(p.readResource(context.Background(), []byte(tt.body))); status != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.body, status, tt.want, body)
+		}
+	}
+}
+
+func TestReadResourceCollections(t *testing.T) {
+	p := testProxy(t)
+	ctx := context.Background()
+	_, err := p.client.Load().Database(p.db).Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetName("email_unique").SetUnique(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := unpack(p.readResource(ctx, []byte(`{"uri": "hanzo://documentdb/collections"}`)))
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var out struct {
+		Contents []internal.ResourceContents `json:"contents"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil || len(out.Contents) != 1 {
+		t.Fatalf("contents %s: %v", body, err)
+	}
+	var defs struct {
+		Database    string           `json:"database"`
+		Collections []collectionInfo `json:"collections"`
+	}
+	if err := json.Unmarshal([]byte(out.Contents[0].Text), &defs); err != nil {
+		t.Fatal(err)
+	}
+	i := slices.IndexFunc(defs.Collections, func(c collectionInfo) bool { return c.Name == "users" })
+	if defs.Database != p.db || i < 0 || defs.Collections[i].Type != "collection" {
+		t.Fatalf("users collection missing from %s", out.Contents[0].Text)
+	}
+
+	type index struct {
+		name, keys string
+		unique     bool
+	}
+	var got []index
+	for _, idx := range defs.Collections[i].Indexes {
+		got = append(got, index{idx.Name, string(idx.Keys), idx.Unique})
+	}
+	want := []index{{"_id_", `{"_id":1}`, false}, {"email_unique", `{"email":1}`, true}}
+	if !slices.Equal(got, want) {
+		t.Errorf("indexes %v, want %v", got, want)
+	}
+}
+
+func TestReloadDatabaseChange(t *testing.T) {
+	p := &Proxy{db: "hanzo"}
+	err := p.Reload(context.Background(), Config{Addr: "mongodb://localhost:27017", Database: "app"})
+	if err == nil || !strings.Contains(err.Error(), "restart required") {
+		t.Errorf("Reload = %v, want a restart required error", err)
+	}
+	if p.client.Load() != nil {
+		t.Error("Reload swapped in a client")
+	}
+}
+
+func TestRouteAuthorization(t *testing.T) {
+	a, err := auth.New(auth.Config{
+		Mode:   auth.ModeToken,
+		Secret: []byte("test-secret"),
+		Policy: []auth.Rule{{Caller: "reader", Allow: []string{"documentdb_find", "documentdb:/resources/*"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Use(a)
+	t.Cleanup(func() { auth.Use(nil) })
+	ctx := auth.WithIdentity(context.Background(), &auth.Identity{Caller: "reader"})
+
+	p := &Proxy{}
+	tests := []struct {
+		path, body string
+		want       int
+		wantBody   string
+	}{
+		{"/resources/list", "", http.StatusOK, "hanzo://documentdb/collections"},
+		{"/find", `{"collection": `, http.StatusBadRequest, "unexpected end"},
+		{"/delete", `{"collection": "users"}`, http.StatusForbidden, "forbidden"},
+		// A tool call is judged by the path of its tool, and a refusal
+		// comes back as a tool error.
+		{"/tools/call", `{"name": "documentdb_delete", "arguments": {"collection": "users"}}`, http.StatusOK, "forbidden"},
+		{"/tools/call", `{"name": "documentdb_find", "arguments": "users"}`, http.StatusOK, "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		status, body := unpack(p.route(ctx, tt.path, []byte(tt.body)))
+		if status != tt.want || !strings.Contains(string(body), tt.wantBody) {
+			t.Errorf("%s %s: %d %s, want %d containing %q", tt.path, tt.body, status, body, tt.want, tt.wantBody)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	p := &Proxy{}
+	p.readOnly.Store(true)
+	for _, tt := range []struct{ path, body string }{
+		{"/insert", `{"collection": "users", "documents": [{}]}`},
+		{"/update", `{"collection": "users", "update": {"$set": {"a": 1}}}`},
+		{"/delete", `{"collection": "users"}`},
+		{"/tools/call", `{"name": "documentdb_delete", "arguments": {"collection": "users"}}`},
+	} {
+		_, body := unpack(p.route(context.Background(), tt.path, []byte(tt.body)))
+		if !strings.Contains(string(body), "read-only mode") {
+			t.Errorf("%s %s: %s, want a read-only refusal", tt.path, tt.body, body)
+		}
+	}
+}
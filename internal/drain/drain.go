@@ -0,0 +1,91 @@
+// Package drain tracks in-flight ZAP requests so the sidecar can shut down
+// without dropping them. Every proxy brackets a request with Enter and
+// Leave; on shutdown main calls Close to stop admitting requests, then
+// Wait to let the admitted ones finish within the grace period.
+package drain
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrDraining is reported to requests that arrive after Close.
+	ErrDraining = errors.New("sidecar is shutting down")
+	// ErrAborted is reported to requests canceled when the grace period
+	// expired before they finished.
+	ErrAborted = errors.New("request canceled: sidecar shut down before it completed")
+)
+
+var state struct {
+	sync.Mutex
+	closed bool
+	active int
+	idle   chan struct{} // closed when active drops to zero
+}
+
+// Enter admits a request. It returns false once Close has been called;
+// otherwise the caller must call Leave when the request is answered.
+func Enter() bool {
+	state.Lock()
+	defer state.Unlock()
+	if state.closed {
+		return false
+	}
+	state.active++
+	return true
+}
+
+// Leave marks an admitted request as answered.
+func Leave() {
+	state.Lock()
+	defer state.Unlock()
+	state.active--
+	if state.active == 0 && state.idle != nil {
+		close(state.idle)
+		state.idle = nil
+	}
+}
+
+// Close stops admitting new requests.
+func Close() {
+	state.Lock()
+	state.closed = true
+	state.Unlock()
+}
+
+// Closed reports whether Close has been called.
+func Closed() bool {
+	state.Lock()
+	defer state.Unlock()
+	return state.closed
+}
+
+// InFlight returns the number of admitted requests not yet answered.
+func InFlight() int {
+	state.Lock()
+	defer state.Unlock()
+	return state.active
+}
+
+// Wait blocks until no admitted request is in flight or ctx is done.
+func Wait(ctx context.Context) error {
+	state.Lock()
+	if state.active == 0 {
+		state.Unlock()
+		return nil
+	}
+	if state.idle == nil {
+		state.idle = make(chan struct{})
+	}
+	idle := state.idle
+	state.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,92 @@
+package drain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// reset reopens the package state between tests.
+func reset() {
+	state.Lock()
+	state.closed, state.active, state.idle = false, 0, nil
+	state.Unlock()
+}
+
+func TestEnterAfterClose(t *testing.T) {
+	t.Cleanup(reset)
+	if !Enter() || !Enter() {
+		t.Fatal("Enter refused before Close")
+	}
+	Close()
+	if Enter() {
+		t.Error("Enter admitted a request after Close")
+	}
+	if !Closed() {
+		t.Error("Closed() = false after Close")
+	}
+	if got := InFlight(); got != 2 {
+		t.Errorf("InFlight() = %d, want the 2 admitted requests", got)
+	}
+	Leave()
+	Leave()
+	if got := InFlight(); got != 0 {
+		t.Errorf("InFlight() = %d after both left", got)
+	}
+}
+
+func TestWait(t *testing.T) {
+	t.Cleanup(reset)
+	tests := []struct {
+		inFlight int
+		leave    int // requests that finish before the grace period ends
+		want     error
+	}{
+		{0, 0, nil},
+		{2, 2, nil},
+		{2, 1, context.DeadlineExceeded}, // main then cancels the rest
+	}
+	for _, tt := range tests {
+		reset()
+		for i := 0; i < tt.inFlight; i++ {
+			Enter()
+		}
+		Close()
+		go func() {
+			for i := 0; i < tt.leave; i++ {
+				time.Sleep(5 * time.Millisecond)
+				Leave()
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		err := Wait(ctx)
+		cancel()
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%d in flight, %d leave: Wait = %v, want %v", tt.inFlight, tt.leave, err, tt.want)
+		}
+		if got, want := InFlight(), tt.inFlight-tt.leave; got != want {
+			t.Errorf("%d in flight, %d leave: InFlight() = %d, want %d", tt.inFlight, tt.leave, got, want)
+		}
+	}
+}
+
+func TestWaitAfterAbort(t *testing.T) {
+	t.Cleanup(reset)
+	Enter()
+	Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := Wait(ctx); err == nil {
+		t.Fatal("Wait returned with a request in flight")
+	}
+
+	// The canceled request answers with ErrAborted and leaves; a second
+	// Wait, bounded by the abort timeout, sees it go.
+	go Leave()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := Wait(ctx); err != nil {
+		t.Errorf("Wait after abort = %v", err)
+	}
+}
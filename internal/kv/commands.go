@@ -0,0 +1,48 @@
+package kv
+
+import "strings"
+
+// readCommands are the commands that never modify the keyspace. In
+// read-only mode /cmd refuses everything else.
+var readCommands = map[string]bool{
+	// Keys and server
+	"EXISTS": true, "TTL": true, "PTTL": true, "EXPIRETIME": true, "PEXPIRETIME": true,
+	"TYPE": true, "KEYS": true, "SCAN": true, "RANDOMKEY": true, "DBSIZE": true,
+	"DUMP": true, "OBJECT": true, "PING": true, "ECHO": true, "INFO": true, "TIME": true,
+	// Strings and bitmaps
+	"GET": true, "MGET": true, "STRLEN": true, "GETRANGE": true, "SUBSTR": true, "LCS": true,
+	"GETBIT": true, "BITCOUNT": true, "BITPOS": true,
+	// Hashes
+	"HGET": true, "HMGET": true, "HGETALL": true, "HKEYS": true, "HVALS": true,
+	"HLEN": true, "HEXISTS": true, "HSTRLEN": true, "HSCAN": true, "HRANDFIELD": true,
+	// Lists
+	"LRANGE": true, "LLEN": true, "LINDEX": true, "LPOS": true,
+	// Sets
+	"SMEMBERS": true, "SISMEMBER": true, "SMISMEMBER": true, "SCARD": true,
+	"SRANDMEMBER": true, "SSCAN": true, "SINTER": true, "SINTERCARD": true,
+	"SUNION": true, "SDIFF": true,
+	// Sorted sets
+	"ZRANGE": true, "ZRANGEBYSCORE": true, "ZRANGEBYLEX": true, "ZREVRANGE": true,
+	"ZREVRANGEBYSCORE": true, "ZREVRANGEBYLEX": true, "ZSCORE": true, "ZMSCORE": true,
+	"ZRANK": true, "ZREVRANK": true, "ZCARD": true, "ZCOUNT": true, "ZLEXCOUNT": true,
+	"ZSCAN": true, "ZRANDMEMBER": true, "ZINTER": true, "ZUNION": true, "ZDIFF": true,
+	// Streams, HyperLogLog and geo
+	"XRANGE": true, "XREVRANGE": true, "XLEN": true, "XREAD": true, "XINFO": true, "XPENDING": true,
+	"PFCOUNT": true, "GEOPOS": true, "GEODIST": true, "GEOHASH": true, "GEOSEARCH": true,
+	"GEORADIUS_RO": true, "GEORADIUSBYMEMBER_RO": true,
+}
+
+// connCommands change the state of the connection they run on, which
+// the commands of a pipeline share, or take it over. They cannot be
+// pipelined; /tx issues MULTI, EXEC and WATCH itself.
+var connCommands = map[string]bool{
+	"MULTI": true, "EXEC": true, "DISCARD": true, "WATCH": true, "UNWATCH": true,
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "SSUBSCRIBE": true,
+	"UNSUBSCRIBE": true, "PUNSUBSCRIBE": true, "SUNSUBSCRIBE": true,
+	"MONITOR": true, "SELECT": true, "RESET": true, "QUIT": true, "HELLO": true, "AUTH": true,
+}
+
+// isRead reports whether cmd is a read-only command.
+func isRead(cmd string) bool {
+	return readCommands[strings.ToUpper(cmd)]
+}
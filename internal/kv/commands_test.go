@@ -0,0 +1,32 @@
+package kv
+
+import "testing"
+
+func TestIsRead(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want bool
+	}{
+		{"GET", true},
+		{"get", true},
+		{"HGetAll", true},
+		{"SCAN", true},
+		{"XREAD", true},
+		{"GEORADIUS_RO", true},
+		{"SET", false},
+		{"DEL", false},
+		{"EXPIRE", false},
+		{"XREADGROUP", false}, // moves entries into the pending list
+		{"GEORADIUS", false},  // STORE writes
+		{"SORT", false},       // STORE writes
+		{"EVAL", false},
+		{"FLUSHALL", false},
+		{"CONFIG", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isRead(tt.cmd); got != tt.want {
+			t.Errorf("isRead(%q) = %v, want %v", tt.cmd, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package kv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/luxfi/zap"
+)
+
+// Value encodings. utf8 carries values as JSON strings, which suits text;
+// base64 carries them as base64 JSON strings; raw carries them as bytes
+// in the ZAP message body, outside JSON.
+//
+// A raw request puts its parameters in the path's query string and its
+// value, if any, in the body:
+//
+//	/set?key=blob&ttl=60   body: the value
+//	/get?key=blob          response body: the value
+//	/mget?key=a&key=b      response body: the values, concatenated
+//	/cmd?cmd=HSET&arg=h&arg=f   body: the last argument
+//
+// A raw response carries the lengths of the values in its body in the
+// X-Value-Lengths header, comma-separated, with -1 for a nil value. A
+// reply that is not a string, integer, nil or flat array of those, such
+// as the nested reply of XRANGE, comes back as JSON with base64 values.
+const (
+	encUTF8   = "utf8"
+	encBase64 = "base64"
+	encRaw    = "raw"
+)
+
+func validEncoding(enc string) bool {
+	switch enc {
+	case "", encUTF8, encBase64, encRaw:
+		return true
+	}
+	return false
+}
+
+// splitQuery splits a request path into the path and the parameters of
+// its query string, nil if it has none.
+func splitQuery(path string) (string, url.Values, error) {
+	path, query, ok := strings.Cut(path, "?")
+	if !ok {
+		return path, nil, nil
+	}
+	q, err := url.ParseQuery(query)
+	if err == nil && q == nil {
+		q = url.Values{}
+	}
+	return path, q, err
+}
+
+// rawEncoding returns the encoding of a raw request, raw unless the
+// query string asks for another.
+func rawEncoding(q url.Values) string {
+	if enc := q.Get("encoding"); enc != "" {
+		return enc
+	}
+	return encRaw
+}
+
+// boolParam parses a flag such as "nx" or "nx=true".
+func boolParam(q url.Values, name string) (bool, error) {
+	if !q.Has(name) {
+		return false, nil
+	}
+	if v := q.Get(name); v != "" {
+		return strconv.ParseBool(v)
+	}
+	return true, nil
+}
+
+func intParam(q url.Values, name string) (int64, error) {
+	if v := q.Get(name); v != "" {
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, nil
+}
+
+// decodeValue returns the bytes of a request value given in enc.
+func decodeValue(enc, v string) (string, error) {
+	if enc != encBase64 {
+		return v, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return "", errors.New("value is not base64")
+	}
+	return string(b), nil
+}
+
+// encodeReply base64-encodes the strings in a reply.
+func encodeReply(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return base64.StdEncoding.EncodeToString([]byte(v))
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = encodeReply(e)
+		}
+		return out
+	}
+	return v
+}
+
+// respondValue returns a reply under name in a JSON object, or, for raw,
+// as the response body.
+func respondValue(enc, name string, v interface{}) *zap.Message {
+	switch enc {
+	case encRaw:
+		if body, lengths, ok := rawReply(v); ok {
+			return respondBytes(http.StatusOK, body, map[string][]string{
+				"Content-Type":    {"application/octet-stream"},
+				"X-Value-Lengths": {strings.Join(lengths, ",")},
+			})
+		}
+		v = encodeReply(v)
+	case encBase64:
+		v = encodeReply(v)
+	}
+	return respond(http.StatusOK, map[string]interface{}{name: v})
+}
+
+// rawReply concatenates a flat reply, reporting each value's length.
+func rawReply(v interface{}) ([]byte, []string, bool) {
+	vals, ok := v.([]interface{})
+	if !ok {
+		vals = []interface{}{v}
+	}
+	var body []byte
+	lengths := make([]string, len(vals))
+	for i, e := range vals {
+		var b []byte
+		switch e := e.(type) {
+		case nil:
+			lengths[i] = "-1"
+			continue
+		case string:
+			b = []byte(e)
+		case int64:
+			b = strconv.AppendInt(nil, e, 10)
+		default:
+			return nil, nil, false
+		}
+		body = append(body, b...)
+		lengths[i] = strconv.Itoa(len(b))
+	}
+	return body, lengths, true
+}
+
+// respondBytes builds a response carrying body as is.
+func respondBytes(status int, body []byte, headers map[string][]string) *zap.Message {
+	h, _ := json.Marshal(headers)
+	b := zap.NewBuilder(len(body) + 256)
+	ob := b.StartObject(12)
+	ob.SetUint32(respStatus, uint32(status))
+	ob.SetBytes(respBody, body)
+	ob.SetBytes(respHeaders, h)
+	ob.FinishAsRoot()
+	msg, _ := zap.Parse(b.Finish())
+	return msg
+}
@@ -0,0 +1,95 @@
+package kv
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		enc, v  string
+		want    string
+		wantErr bool
+	}{
+		{"", "hello", "hello", false},
+		{encUTF8, "héllo", "héllo", false},
+		{encRaw, "\x00\xff", "\x00\xff", false},
+		{encBase64, "AP8=", "\x00\xff", false},
+		{encBase64, "", "", false},
+		{encBase64, "not base64!", "", true},
+		{encBase64, "AP8", "", true}, // unpadded
+	}
+	for _, tt := range tests {
+		got, err := decodeValue(tt.enc, tt.v)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("decodeValue(%q, %q) = %q, %v; want %q, error %v", tt.enc, tt.v, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRawReply(t *testing.T) {
+	tests := []struct {
+		v       interface{}
+		body    string
+		lengths []string
+		ok      bool
+	}{
+		{"a\x00b", "a\x00b", []string{"3"}, true},
+		{"", "", []string{"0"}, true},
+		{nil, "", []string{"-1"}, true},
+		{int64(-42), "-42", []string{"3"}, true},
+		{[]interface{}{"ab", nil, int64(7), ""}, "ab7", []string{"2", "-1", "1", "0"}, true},
+		{[]interface{}{}, "", []string{}, true},
+		{[]interface{}{"a", []interface{}{"b"}}, "", nil, false},
+		{map[interface{}]interface{}{"a": "b"}, "", nil, false},
+		{1.5, "", nil, false},
+	}
+	for _, tt := range tests {
+		body, lengths, ok := rawReply(tt.v)
+		if ok != tt.ok {
+			t.Errorf("rawReply(%#v): ok = %v, want %v", tt.v, ok, tt.ok)
+			continue
+		}
+		if ok && (string(body) != tt.body || !slices.Equal(lengths, tt.lengths)) {
+			t.Errorf("rawReply(%#v) = %q, %v; want %q, %v", tt.v, body, lengths, tt.body, tt.lengths)
+		}
+	}
+}
+
+func TestEncodeReply(t *testing.T) {
+	got := encodeReply([]interface{}{"\x00\xff", int64(1), nil, []interface{}{"a"}})
+	want := []interface{}{"AP8=", int64(1), nil, []interface{}{"YQ=="}}
+	if !slices.EqualFunc(got.([]interface{}), want, func(a, b interface{}) bool {
+		if a, ok := a.([]interface{}); ok {
+			return slices.Equal(a, b.([]interface{}))
+		}
+		return a == b
+	}) {
+		t.Errorf("encodeReply = %v, want %v", got, want)
+	}
+}
+
+func TestSplitQuery(t *testing.T) {
+	tests := []struct {
+		in, path string
+		keys     []string
+		hasQuery bool
+		wantErr  bool
+	}{
+		{in: "/get", path: "/get"},
+		{in: "/get?", path: "/get", hasQuery: true},
+		{in: "/mget?key=a&key=b", path: "/mget", keys: []string{"a", "b"}, hasQuery: true},
+		{in: "/get?key=a%2Fb", path: "/get", keys: []string{"a/b"}, hasQuery: true},
+		{in: "/get?key=%zz", path: "/get", wantErr: true, hasQuery: true},
+	}
+	for _, tt := range tests {
+		path, q, err := splitQuery(tt.in)
+		if (err != nil) != tt.wantErr || path != tt.path || (q != nil) != tt.hasQuery {
+			t.Errorf("splitQuery(%q) = %q, %v, %v", tt.in, path, q, err)
+			continue
+		}
+		if !tt.wantErr && q != nil && !slices.Equal(q["key"], tt.keys) {
+			t.Errorf("splitQuery(%q) keys = %v, want %v", tt.in, q["key"], tt.keys)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package kv
+
+import (
+	"bufio"
+	"context"
+	"strings"
+)
+
+// info returns Valkey INFO output grouped by section, e.g.
+// {"server": {"redis_version": "7.2.4", ...}, "keyspace": {"db0": "keys=1,expires=0"}}.
+func (p *Proxy) info(ctx context.Context) (map[string]map[string]string, error) {
+	raw, err := p.client.Load().Info(ctx, "all").Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(raw), nil
+}
+
+func parseInfo(raw string) map[string]map[string]string {
+	sections := make(map[string]map[string]string)
+	current := "default"
+	sc := bufio.NewScanner(strings.NewReader(raw))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			current = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(line, "#")))
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if sections[current] == nil {
+			sections[current] = make(map[string]string)
+		}
+		sections[current][k] = v
+	}
+	return sections
+}
@@ -0,0 +1,192 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/luxfi/zap"
+
+	kv "github.com/hanzoai/kv-go/v9"
+)
+
+// maxPipelineCommands bounds the commands in one /pipeline or /tx request.
+const maxPipelineCommands = 1000
+
+type pipelineReq struct {
+	Commands []kvCmd `json:"commands"`
+	// Encoding applies to every argument, expected value and reply; raw
+	// is not supported.
+	Encoding string `json:"encoding,omitempty"`
+	// Watch and Expect make a /tx optimistic: the keys are watched, the
+	// expected values (null for a missing key) are checked, and EXEC
+	// fails if any watched key changes before it. Keys in Expect are
+	// watched too.
+	Watch  []string           `json:"watch,omitempty"`
+	Expect map[string]*string `json:"expect,omitempty"`
+}
+
+var errExpect = errors.New("expected values differ")
+
+// pipeline runs a list of commands in one round trip and returns one
+// result or error per command. With tx the commands run atomically
+// between MULTI and EXEC; without it they are independent, and one
+// failing does not stop the others.
+func (p *Proxy) pipeline(ctx context.Context, body []byte, tx bool) *zap.Message {
+	var req pipelineReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(req.Commands) == 0 || len(req.Commands) > maxPipelineCommands {
+		return respond(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("pipeline needs 1 to %d commands", maxPipelineCommands)})
+	}
+	if !validEncoding(req.Encoding) || req.Encoding == encRaw {
+		return respond(http.StatusBadRequest, map[string]string{"error": "unsupported encoding: " + req.Encoding})
+	}
+	if !tx && (len(req.Watch) > 0 || len(req.Expect) > 0) {
+		return respond(http.StatusBadRequest, map[string]string{"error": "watch and expect need /tx"})
+	}
+
+	args := make([][]interface{}, len(req.Commands))
+	for i, c := range req.Commands {
+		name := strings.ToUpper(c.Cmd)
+		switch {
+		case name == "":
+			return respond(http.StatusBadRequest, map[string]interface{}{"error": "empty command", "index": i})
+		case connCommands[name]:
+			return respond(http.StatusBadRequest, map[string]interface{}{"error": name + " cannot be pipelined", "index": i})
+		case p.readOnly.Load() && !isRead(name):
+			return respond(http.StatusForbidden, map[string]interface{}{"error": "read-only mode: " + name + " is disabled", "index": i})
+		}
+		args[i] = make([]interface{}, len(c.Args)+1)
+		args[i][0] = c.Cmd
+		for j, a := range c.Args {
+			v, err := decodeValue(req.Encoding, a)
+			if err != nil {
+				return respond(http.StatusBadRequest, map[string]interface{}{"error": fmt.Sprintf("arg %d: %v", j, err), "index": i})
+			}
+			args[i][j+1] = v
+		}
+	}
+	expect := make(map[string]*string, len(req.Expect))
+	for key, want := range req.Expect {
+		if want != nil {
+			v, err := decodeValue(req.Encoding, *want)
+			if err != nil {
+				return respond(http.StatusBadRequest, map[string]string{"error": "expect " + key + ": " + err.Error()})
+			}
+			want = &v
+		}
+		expect[key] = want
+	}
+
+	cmds := make([]*kv.Cmd, len(args))
+	queue := func(pipe kv.Pipeliner) error {
+		for i, a := range args {
+			cmds[i] = pipe.Do(ctx, a...)
+		}
+		return nil
+	}
+	client := p.client.Load()
+	var err error
+	var differ map[string]interface{}
+	switch {
+	case !tx:
+		_, err = client.Pipelined(ctx, queue)
+	case len(req.Watch) == 0 && len(expect) == 0:
+		_, err = client.TxPipelined(ctx, queue)
+	default:
+		keys := append([]string(nil), req.Watch...)
+		for key := range expect {
+			keys = append(keys, key)
+		}
+		err = client.Watch(ctx, func(t *kv.Tx) error {
+			d, err := checkExpect(ctx, t, expect)
+			if err != nil {
+				differ = d
+				return err
+			}
+			_, err = t.TxPipelined(ctx, queue)
+			return err
+		}, keys...)
+	}
+
+	switch {
+	case errors.Is(err, errExpect):
+		return respond(http.StatusConflict, map[string]interface{}{"error": err.Error(), "values": encodeValues(req.Encoding, differ)})
+	case errors.Is(err, kv.TxFailedErr):
+		return respond(http.StatusConflict, map[string]string{"error": "transaction aborted: a watched key changed"})
+	}
+	// Pipelined returns the first command's error; only a failure of the
+	// connection, or of the WATCH before any command was queued, fails
+	// the whole request.
+	var replyErr kv.Error
+	if err != nil && err != kv.Nil && (!errors.As(err, &replyErr) || cmds[0] == nil) {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	results := make([]map[string]interface{}, len(cmds))
+	for i, cmd := range cmds {
+		v, err := cmd.Result()
+		switch {
+		case err == kv.Nil:
+			results[i] = map[string]interface{}{"result": nil}
+		case err != nil:
+			results[i] = map[string]interface{}{"error": err.Error()}
+		case req.Encoding == encBase64:
+			results[i] = map[string]interface{}{"result": encodeReply(v)}
+		default:
+			results[i] = map[string]interface{}{"result": v}
+		}
+	}
+	return respond(http.StatusOK, map[string]interface{}{"results": results, "count": len(results)})
+}
+
+// checkExpect compares the watched keys with their expected values,
+// returning the current values of those that differ along with errExpect.
+func checkExpect(ctx context.Context, t *kv.Tx, expect map[string]*string) (map[string]interface{}, error) {
+	return compareExpect(expect, func(key string) (string, error) {
+		return t.Get(ctx, key).Result()
+	})
+}
+
+// compareExpect does the work of checkExpect with get, which returns a
+// key's value or kv.Nil.
+func compareExpect(expect map[string]*string, get func(key string) (string, error)) (map[string]interface{}, error) {
+	var differ map[string]interface{}
+	for key, want := range expect {
+		got, err := get(key)
+		if err != nil && err != kv.Nil {
+			return nil, err
+		}
+		found := err == nil
+		if found == (want != nil) && (!found || got == *want) {
+			continue
+		}
+		if differ == nil {
+			differ = make(map[string]interface{})
+		}
+		differ[key] = nil
+		if found {
+			differ[key] = got
+		}
+	}
+	if differ != nil {
+		return differ, errExpect
+	}
+	return nil, nil
+}
+
+func encodeValues(enc string, vals map[string]interface{}) map[string]interface{} {
+	if enc != encBase64 {
+		return vals
+	}
+	out := make(map[string]interface{}, len(vals))
+	for k, v := range vals {
+		out[k] = encodeReply(v)
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package kv
+
+import (
+	"errors"
+	"maps"
+	"testing"
+
+	kv "github.com/hanzoai/kv-go/v9"
+)
+
+func TestCompareExpect(t *testing.T) {
+	store := map[string]string{"a": "1", "empty": ""}
+	get := func(key string) (string, error) {
+		if key == "broken" {
+			return "", errors.New("connection reset")
+		}
+		if v, ok := store[key]; ok {
+			return v, nil
+		}
+		return "", kv.Nil
+	}
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		expect  map[string]*string
+		differ  map[string]interface{}
+		wantErr error
+	}{
+		{map[string]*string{"a": str("1")}, nil, nil},
+		{map[string]*string{"gone": nil}, nil, nil},
+		{map[string]*string{"empty": str("")}, nil, nil}, // empty is not missing
+		{map[string]*string{"a": str("2")}, map[string]interface{}{"a": "1"}, errExpect},
+		{map[string]*string{"a": nil}, map[string]interface{}{"a": "1"}, errExpect},
+		{map[string]*string{"gone": str("1")}, map[string]interface{}{"gone": nil}, errExpect},
+		// Only the keys that differ are reported.
+		{map[string]*string{"a": str("1"), "empty": str("x")}, map[string]interface{}{"empty": ""}, errExpect},
+	}
+	for i, tt := range tests {
+		differ, err := compareExpect(tt.expect, get)
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("case %d: err = %v, want %v", i, err, tt.wantErr)
+		}
+		if !maps.Equal(differ, tt.differ) {
+			t.Errorf("case %d: differ = %v, want %v", i, differ, tt.differ)
+		}
+	}
+
+	if _, err := compareExpect(map[string]*string{"broken": nil}, get); err == nil || errors.Is(err, errExpect) {
+		t.Errorf("read failure: err = %v, want the read error", err)
+	}
+}
+
+func TestConnCommands(t *testing.T) {
+	for _, cmd := range []string{"MULTI", "EXEC", "WATCH", "SUBSCRIBE", "SELECT", "AUTH", "HELLO", "RESET"} {
+		if !connCommands[cmd] {
+			t.Errorf("%s may be pipelined", cmd)
+		}
+	}
+	for cmd := range connCommands {
+		if isRead(cmd) {
+			t.Errorf("%s changes its connection but is classed as a read", cmd)
+		}
+	}
+	for _, cmd := range []string{"GET", "SET", "INCR", "EXPIRE"} {
+		if connCommands[cmd] {
+			t.Errorf("%s refused in pipelines", cmd)
+		}
+	}
+}
@@ -1,55 +1,99 @@
 // Package kv implements a ZAP-to-Valkey/Redis sidecar.
 //
 // Accepts ZAP connections and translates to Redis RESP protocol.
-// Optimized for zero-copy GET/SET/MGET bulk operations.
-// Exposes MCP-compatible tools: kv_get, kv_set, kv_mget, kv_cmd.
+// Optimized for zero-copy GET/SET/MGET bulk operations: with the raw
+// encoding, values travel as bytes in the ZAP message body instead of
+// JSON strings (see encoding.go).
+// Exposes MCP-compatible tools: kv_get, kv_set, kv_mset, kv_mget, kv_cmd,
+// kv_pipeline, kv_tx, kv_publish and the kv_stream_* tools, served over
+// /tools/list and /tools/call alongside /resources/list and
+// /resources/read. /pipeline sends a list of commands in one round trip;
+// /tx runs them atomically with MULTI/EXEC, optionally guarded by WATCH.
+// /subscribe pushes Pub/Sub messages and keyspace notifications back to
+// the caller as MsgTypeKVMessage messages. The /stream paths run stream
+// work queues: add entries, read them through consumer groups, ack and
+// claim them (see stream.go).
 package kv
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/luxfi/zap"
 
 	kv "github.com/hanzoai/kv-go/v9"
+
+	"github.com/hanzoai/zap-sidecar/internal"
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+	"github.com/hanzoai/zap-sidecar/internal/drain"
+	"github.com/hanzoai/zap-sidecar/internal/metrics"
+	"github.com/hanzoai/zap-sidecar/internal/push"
 )
 
 const MsgTypeKV uint16 = 301
 
+// writePaths modify data and are rejected in read-only mode.
+var writePaths = map[string]bool{
+	"/set": true, "/mset": true, "/publish": true,
+	"/stream/add": true, "/stream/read-group": true, "/stream/ack": true,
+	"/stream/claim": true, "/stream/group": true,
+}
+
 const (
-	fieldPath = 4
-	fieldBody = 12
-	respStatus  = 0
-	respBody    = 4
-	respHeaders = 8
+	fieldPath    = 4
+	fieldHeaders = 8
+	fieldBody    = 12
+	respStatus   = 0
+	respBody     = 4
+	respHeaders  = 8
 )
 
 type Config struct {
-	NodeID      string
-	Port        int
-	ServiceType string
-	Addr        string
-	Password    string
-	DB          int
+	Addr     string
+	Username string
+	Password string
+	DB       int
+
+	// Pool and timeout overrides; zero keeps the kv-go default.
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	TLS *tls.Config
+
+	// ReadOnly rejects every path that modifies data.
+	ReadOnly bool
+
+	// MaxStreamBlock caps the block_ms of /stream/read-group; default 5s.
+	MaxStreamBlock time.Duration
 }
 
+// drainTimeout is how long a replaced client keeps serving in-flight
+// commands before Reload closes it.
+const drainTimeout = 30 * time.Second
+
 type Proxy struct {
-	node   *zap.Node
-	client *kv.Client
-	logger *slog.Logger
+	node     *zap.Node
+	client   atomic.Pointer[kv.Client] // swapped by Reload
+	db       atomic.Int64
+	readOnly atomic.Bool
+	maxBlock atomic.Int64 // time.Duration
+	subs     *hub
+	logger   *slog.Logger
 }
 
 func New(ctx context.Context, logger *slog.Logger, cfg Config) (*Proxy, error) {
-	client := kv.NewClient(&kv.Options{
-		Addr:     cfg.Addr,
-		Password: cfg.Password,
-		DB:       cfg.DB,
-	})
+	client := newClient(cfg)
 	// Retry ping — Redis may still be loading data (AOF/RDB replay)
 	for i := 0; i < 30; i++ {
 		if err := client.Ping(ctx).Err(); err == nil {
@@ -62,57 +106,174 @@ func New(ctx context.Context, logger *slog.Logger, cfg Config) (*Proxy, error) {
 		time.Sleep(2 * time.Second)
 	}
 
-	p := &Proxy{client: client, logger: logger}
-
-	node := zap.NewNode(zap.NodeConfig{
-		NodeID:      cfg.NodeID,
-		ServiceType: cfg.ServiceType,
-		Port:        cfg.Port,
-		Logger:      logger,
-	})
+	p := &Proxy{logger: logger}
+	p.subs = newHub(p)
+	p.client.Store(client)
+	p.db.Store(int64(cfg.DB))
+	p.readOnly.Store(cfg.ReadOnly)
+	p.setMaxStreamBlock(cfg.MaxStreamBlock)
+	metrics.OnScrape(p.poolStats)
 
-	node.Handle(MsgTypeKV, func(_ context.Context, _ string, msg *zap.Message) (*zap.Message, error) {
-		return p.handle(ctx, msg), nil
-	})
+	logger.Info("kv sidecar ready", "addr", cfg.Addr)
+	return p, nil
+}
 
-	if err := node.Start(); err != nil {
+// Reload connects a new client from cfg and swaps it in. The old client
+// is closed after drainTimeout.
+func (p *Proxy) Reload(ctx context.Context, cfg Config) error {
+	client := newClient(cfg)
+	if err := client.Ping(ctx).Err(); err != nil {
 		client.Close()
-		return nil, fmt.Errorf("kv: node start failed: %w", err)
+		return fmt.Errorf("kv: reload: %w", err)
 	}
+	old := p.client.Swap(client)
+	p.db.Store(int64(cfg.DB))
+	p.readOnly.Store(cfg.ReadOnly)
+	p.setMaxStreamBlock(cfg.MaxStreamBlock)
+	p.subs.reconnect()
+	time.AfterFunc(drainTimeout, func() { old.Close() })
+	return nil
+}
 
+func newClient(cfg Config) *kv.Client {
+	return kv.NewClient(&kv.Options{
+		Addr:         cfg.Addr,
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		PoolSize:     cfg.PoolSize,
+		MinIdleConns: cfg.MinIdleConns,
+		DialTimeout:  cfg.DialTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		TLSConfig:    cfg.TLS,
+	})
+}
+
+// Register installs the proxy's handler for MsgTypeKV on node.
+func (p *Proxy) Register(ctx context.Context, node *zap.Node) {
 	p.node = node
-	logger.Info("kv sidecar ready", "addr", cfg.Addr)
-	return p, nil
+	node.Handle(MsgTypeKV, func(_ context.Context, peer string, msg *zap.Message) (*zap.Message, error) {
+		return p.handle(ctx, peer, msg), nil
+	})
 }
 
+// Stop ends subscriptions and closes the backend connection. The node is
+// owned by the caller.
 func (p *Proxy) Stop() {
-	if p.node != nil {
-		p.node.Stop()
+	p.subs.close()
+	if client := p.client.Load(); client != nil {
+		client.Close()
 	}
-	if p.client != nil {
-		p.client.Close()
+}
+
+// Ready reports whether the backend answers the /health check.
+func (p *Proxy) Ready(ctx context.Context) error {
+	if status, body := unpack(p.health(ctx)); status != http.StatusOK {
+		return fmt.Errorf("kv: %s", body)
 	}
+	return nil
 }
 
-func (p *Proxy) handle(ctx context.Context, msg *zap.Message) *zap.Message {
+func (p *Proxy) poolStats() {
+	st := p.client.Load().PoolStats()
+	metrics.PoolConns.Set(float64(st.TotalConns), "kv", "total")
+	metrics.PoolConns.Set(float64(st.IdleConns), "kv", "idle")
+	metrics.PoolEvents.Mirror(float64(st.Hits), "kv", "hit")
+	metrics.PoolEvents.Mirror(float64(st.Misses), "kv", "miss")
+	metrics.PoolEvents.Mirror(float64(st.Timeouts), "kv", "timeout")
+	metrics.PoolEvents.Mirror(float64(st.StaleConns), "kv", "stale")
+}
+
+func (p *Proxy) handle(ctx context.Context, peer string, msg *zap.Message) *zap.Message {
 	root := msg.Root()
 	path := root.Text(fieldPath)
-	body := root.Bytes(fieldBody)
+	name, _, _ := strings.Cut(path, "?") // query parameters stay out of the metric labels
+	done := metrics.Start("kv", name)
+	if !drain.Enter() {
+		done(http.StatusServiceUnavailable)
+		return respond(http.StatusServiceUnavailable, map[string]string{"error": drain.ErrDraining.Error()})
+	}
+	defer drain.Leave()
+
+	id, err := auth.Authenticate(root.Bytes(fieldHeaders))
+	if err != nil {
+		done(http.StatusUnauthorized)
+		return respond(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	ctx = push.WithPeer(auth.WithIdentity(ctx, id), peer)
 
+	resp := p.route(ctx, path, root.Bytes(fieldBody))
+	if ctx.Err() != nil {
+		resp = respond(http.StatusServiceUnavailable, map[string]string{"error": drain.ErrAborted.Error()})
+	}
+	status, _ := unpack(resp)
+	done(status)
+	return resp
+}
+
+func (p *Proxy) route(ctx context.Context, path string, body []byte) *zap.Message {
+	path, q, err := splitQuery(path)
+	if err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	// /tools/call is authorized on the path of the tool it dispatches to.
+	if path != "/tools/call" {
+		if err := auth.Authorize(ctx, "kv", path); err != nil {
+			return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+	if writePaths[path] && p.readOnly.Load() {
+		return respond(http.StatusForbidden, map[string]string{"error": "read-only mode: " + path + " is disabled"})
+	}
 	switch path {
 	case "/health":
 		return p.health(ctx)
 	case "/get":
-		return p.get(ctx, body)
+		return p.get(ctx, body, q)
 	case "/set":
-		return p.set(ctx, body)
+		return p.set(ctx, body, q)
 	case "/mget":
-		return p.mget(ctx, body)
+		return p.mget(ctx, body, q)
+	case "/mset":
+		return p.mset(ctx, body)
 	case "/cmd":
-		return p.cmd(ctx, body)
+		return p.cmd(ctx, body, q)
+	case "/pipeline":
+		return p.pipeline(ctx, body, false)
+	case "/tx":
+		return p.pipeline(ctx, body, true)
+	case "/publish":
+		return p.publish(ctx, body, q)
+	case "/subscribe":
+		return p.subscribe(ctx, body)
+	case "/unsubscribe":
+		return p.unsubscribe(ctx, body)
+	case "/subscriptions":
+		return p.subscriptions(ctx)
+	case "/stream/add":
+		return p.streamAdd(ctx, body)
+	case "/stream/read-group":
+		return p.streamReadGroup(ctx, body)
+	case "/stream/ack":
+		return p.streamAck(ctx, body)
+	case "/stream/claim":
+		return p.streamClaim(ctx, body)
+	case "/stream/info":
+		return p.streamInfo(ctx, body)
+	case "/stream/group":
+		return p.streamGroup(ctx, body)
+	case "/tools/list":
+		return respond(http.StatusOK, map[string]interface{}{"tools": internal.KVTools})
+	case "/tools/call":
+		return p.callTool(ctx, body)
+	case "/resources/list":
+		return respond(http.StatusOK, map[string]interface{}{"resources": internal.KVResources})
+	case "/resources/read":
+		return p.readResource(ctx, body)
 	default:
 		if len(body) > 0 {
-			return p.cmd(ctx, body)
+			return p.cmd(ctx, body, nil)
 		}
 		return respond(http.StatusNotFound, map[string]string{"error": "unknown: " + path})
 	}
@@ -121,11 +282,19 @@ func (p *Proxy) handle(ctx context.Context, msg *zap.Message) *zap.Message {
 type kvCmd struct {
 	Cmd  string   `json:"cmd"`
 	Args []string `json:"args"`
+	// Encoding applies to every argument and to the reply.
+	Encoding string `json:"encoding,omitempty"`
 }
 
-func (p *Proxy) cmd(ctx context.Context, body []byte) *zap.Message {
+func (p *Proxy) cmd(ctx context.Context, body []byte, q url.Values) *zap.Message {
 	var req kvCmd
-	if err := json.Unmarshal(body, &req); err != nil {
+	switch {
+	case q != nil:
+		req.Cmd, req.Args, req.Encoding = q.Get("cmd"), q["arg"], rawEncoding(q)
+		if len(body) > 0 {
+			req.Args = append(req.Args, string(body))
+		}
+	case json.Unmarshal(body, &req) != nil:
 		parts := strings.Fields(string(body))
 		if len(parts) == 0 {
 			return respond(http.StatusBadRequest, map[string]string{"error": "empty command"})
@@ -133,79 +302,130 @@ func (p *Proxy) cmd(ctx context.Context, body []byte) *zap.Message {
 		req.Cmd = parts[0]
 		req.Args = parts[1:]
 	}
+	if req.Cmd == "" {
+		return respond(http.StatusBadRequest, map[string]string{"error": "empty command"})
+	}
+	if !validEncoding(req.Encoding) {
+		return respond(http.StatusBadRequest, map[string]string{"error": "unknown encoding: " + req.Encoding})
+	}
+
+	if p.readOnly.Load() && !isRead(req.Cmd) {
+		return respond(http.StatusForbidden, map[string]string{"error": "read-only mode: " + strings.ToUpper(req.Cmd) + " is disabled"})
+	}
 
 	args := make([]interface{}, len(req.Args)+1)
 	args[0] = req.Cmd
 	for i, a := range req.Args {
-		args[i+1] = a
+		v, err := decodeValue(req.Encoding, a)
+		if err != nil {
+			return respond(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("arg %d: %v", i, err)})
+		}
+		args[i+1] = v
 	}
 
-	result, err := p.client.Do(ctx, args...).Result()
+	result, err := p.client.Load().Do(ctx, args...).Result()
+	if err == kv.Nil {
+		return respondValue(req.Encoding, "result", nil)
+	}
 	if err != nil {
 		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
-	return respond(http.StatusOK, map[string]interface{}{"result": result})
+	return respondValue(req.Encoding, "result", result)
 }
 
-func (p *Proxy) get(ctx context.Context, body []byte) *zap.Message {
-	var req struct{ Key string `json:"key"` }
-	if err := json.Unmarshal(body, &req); err != nil {
+type getReq struct {
+	Key      string   `json:"key"`
+	Keys     []string `json:"keys"` // /mget
+	Encoding string   `json:"encoding,omitempty"`
+}
+
+func (p *Proxy) get(ctx context.Context, body []byte, q url.Values) *zap.Message {
+	var req getReq
+	if q != nil {
+		req.Key, req.Encoding = q.Get("key"), rawEncoding(q)
+	} else if err := json.Unmarshal(body, &req); err != nil {
 		req.Key = string(body)
 	}
+	if !validEncoding(req.Encoding) {
+		return respond(http.StatusBadRequest, map[string]string{"error": "unknown encoding: " + req.Encoding})
+	}
 
-	val, err := p.client.Get(ctx, req.Key).Result()
+	val, err := p.client.Load().Get(ctx, req.Key).Result()
 	if err == kv.Nil {
-		return respond(http.StatusOK, map[string]interface{}{"value": nil})
+		return respondValue(req.Encoding, "value", nil)
 	}
 	if err != nil {
 		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
-	return respond(http.StatusOK, map[string]interface{}{"value": val})
+	return respondValue(req.Encoding, "value", val)
 }
 
-func (p *Proxy) set(ctx context.Context, body []byte) *zap.Message {
-	var req struct {
-		Key   string `json:"key"`
-		Value string `json:"value"`
-	}
-	if err := json.Unmarshal(body, &req); err != nil {
+func (p *Proxy) mget(ctx context.Context, body []byte, q url.Values) *zap.Message {
+	var req getReq
+	if q != nil {
+		req.Keys, req.Encoding = q["key"], rawEncoding(q)
+	} else if err := json.Unmarshal(body, &req); err != nil {
 		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
+	if !validEncoding(req.Encoding) {
+		return respond(http.StatusBadRequest, map[string]string{"error": "unknown encoding: " + req.Encoding})
+	}
 
-	if err := p.client.Set(ctx, req.Key, req.Value, 0).Err(); err != nil {
+	vals, err := p.client.Load().MGet(ctx, req.Keys...).Result()
+	if err != nil {
 		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
-	return respond(http.StatusOK, map[string]string{"status": "OK"})
+	return respondValue(req.Encoding, "values", vals)
+}
+
+func (p *Proxy) health(ctx context.Context) *zap.Message {
+	if err := p.client.Load().Ping(ctx).Err(); err != nil {
+		return respond(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, map[string]string{"status": "ok", "service": "hanzo-kv"})
+}
+
+func (p *Proxy) callTool(ctx context.Context, body []byte) *zap.Message {
+	var call internal.ToolCall
+	if err := json.Unmarshal(body, &call); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	tool, ok := internal.FindTool(internal.KVTools, call.Name)
+	if !ok {
+		return respond(http.StatusNotFound, map[string]string{"error": "unknown tool: " + call.Name})
+	}
+	status, out := unpack(p.route(ctx, tool.Path, call.Arguments))
+	return respond(http.StatusOK, internal.NewToolResult(status, out))
 }
 
-func (p *Proxy) mget(ctx context.Context, body []byte) *zap.Message {
-	var req struct{ Keys []string `json:"keys"` }
+func (p *Proxy) readResource(ctx context.Context, body []byte) *zap.Message {
+	var req internal.ResourceRead
 	if err := json.Unmarshal(body, &req); err != nil {
 		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
+	res, ok := internal.FindResource(internal.KVResources, req.URI)
+	if !ok {
+		return respond(http.StatusNotFound, map[string]string{"error": "unknown resource: " + req.URI})
+	}
 
-	vals, err := p.client.MGet(ctx, req.Keys...).Result()
+	data, err := p.info(ctx)
 	if err != nil {
 		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
-	return respond(http.StatusOK, map[string]interface{}{"values": vals})
-}
-
-func (p *Proxy) health(ctx context.Context) *zap.Message {
-	if err := p.client.Ping(ctx).Err(); err != nil {
-		return respond(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	contents, err := internal.JSONContents(res, data)
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
-	return respond(http.StatusOK, map[string]string{"status": "ok", "service": "hanzo-kv"})
+	return respond(http.StatusOK, map[string]interface{}{"contents": contents})
 }
 
 func respond(status int, data interface{}) *zap.Message {
-	b := zap.NewBuilder(4096)
-	ob := b.StartObject(12)
-	ob.SetUint32(respStatus, uint32(status))
 	body, _ := json.Marshal(data)
-	ob.SetBytes(respBody, body)
-	ob.SetBytes(respHeaders, []byte(`{"Content-Type":["application/json"]}`))
-	ob.FinishAsRoot()
-	msg, _ := zap.Parse(b.Finish())
-	return msg
+	return respondBytes(status, body, map[string][]string{"Content-Type": {"application/json"}})
+}
+
+// unpack returns the status and body of a response built by respond.
+func unpack(msg *zap.Message) (int, []byte) {
+	root := msg.Root()
+	return int(root.Uint32(respStatus)), root.Bytes(respBody)
 }
@@ -0,0 +1,397 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luxfi/zap"
+
+	kv "github.com/hanzoai/kv-go/v9"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+	"github.com/hanzoai/zap-sidecar/internal/push"
+)
+
+// MsgTypeKVMessage is the type of the messages pushed to subscribers; see
+// package push for their layout.
+const MsgTypeKVMessage uint16 = 211
+
+const subscribeTimeout = 10 * time.Second
+
+// subscription pushes the messages of some channels and patterns to one
+// ZAP peer.
+type subscription struct {
+	push.Subscription
+	Channels []string `json:"channels,omitempty"`
+	Patterns []string `json:"patterns,omitempty"`
+	Encoding string   `json:"encoding,omitempty"` // of payloads and keys
+}
+
+// hub keeps one Valkey connection subscribed to all the channels and
+// patterns of the subscriptions in push, which exists while any
+// subscription does. kv-go reconnects it and resubscribes after a network
+// error.
+type hub struct {
+	p    *Proxy
+	push *push.Hub
+
+	mu       sync.Mutex
+	ps       *kv.PubSub
+	channels map[string]int // subscriptions per channel
+	patterns map[string]int // subscriptions per pattern
+}
+
+func newHub(p *Proxy) *hub {
+	h := &hub{
+		p:        p,
+		channels: make(map[string]int),
+		patterns: make(map[string]int),
+	}
+	h.push = push.NewHub(push.Config{
+		Mode:    "kv",
+		MsgType: MsgTypeKVMessage,
+		Logger:  p.logger,
+		Send: func(ctx context.Context, peer string, msg *zap.Message) error {
+			return p.node.Send(ctx, peer, msg)
+		},
+		Detach: h.detach,
+	})
+	return h
+}
+
+// add starts delivering to s, subscribing to the channels and patterns
+// not yet subscribed.
+func (h *hub) add(ctx context.Context, s *subscription, peer, owner string) error {
+	return h.push.Add(s, peer, owner, true, func() error {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		fresh := h.ps == nil
+		if fresh {
+			h.ps = h.p.client.Load().Subscribe(ctx)
+		}
+		if err := h.subscribe(ctx, newNames(h.channels, s.Channels), newNames(h.patterns, s.Patterns)); err != nil {
+			h.release(s.Channels, s.Patterns)
+			return err
+		}
+		if fresh {
+			h.start()
+		}
+		count(h.channels, s.Channels, 1)
+		count(h.patterns, s.Patterns, 1)
+		return nil
+	})
+}
+
+// detach unsubscribes from what a removed subscription alone needed.
+func (h *hub) detach(sub push.Subscriber) {
+	s := sub.(*subscription)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	count(h.channels, s.Channels, -1)
+	count(h.patterns, s.Patterns, -1)
+	h.release(s.Channels, s.Patterns)
+}
+
+// newNames returns the names in list with no subscriber yet.
+func newNames(counts map[string]int, list []string) []string {
+	var out []string
+	for _, name := range list {
+		if counts[name] == 0 {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func count(counts map[string]int, list []string, d int) {
+	for _, name := range list {
+		if counts[name] += d; counts[name] <= 0 {
+			delete(counts, name)
+		}
+	}
+}
+
+func (h *hub) subscribe(ctx context.Context, chans, pats []string) error {
+	if len(chans) > 0 {
+		if err := h.ps.Subscribe(ctx, chans...); err != nil {
+			return err
+		}
+	}
+	if len(pats) > 0 {
+		return h.ps.PSubscribe(ctx, pats...)
+	}
+	return nil
+}
+
+// start routes the messages of the hub's connection.
+func (h *hub) start() {
+	ps := h.ps
+	h.push.Go(func() {
+		for msg := range ps.Channel() {
+			h.route(msg)
+		}
+	})
+}
+
+// release unsubscribes from the names no subscription needs any more,
+// closing the connection when none is left. h.mu must be held.
+func (h *hub) release(chans, pats []string) {
+	var drop, pdrop []string
+	for _, name := range chans {
+		if h.channels[name] == 0 {
+			drop = append(drop, name)
+		}
+	}
+	for _, name := range pats {
+		if h.patterns[name] == 0 {
+			pdrop = append(pdrop, name)
+		}
+	}
+	if h.ps == nil {
+		return
+	}
+	if len(h.channels) == 0 && len(h.patterns) == 0 {
+		h.ps.Close()
+		h.ps = nil
+		return
+	}
+	ctx, cancel := context.WithTimeout(h.push.Context(), subscribeTimeout)
+	defer cancel()
+	if len(drop) > 0 {
+		h.ps.Unsubscribe(ctx, drop...)
+	}
+	if len(pdrop) > 0 {
+		h.ps.PUnsubscribe(ctx, pdrop...)
+	}
+}
+
+// reconnect moves the subscriptions to the current client, e.g. after
+// Reload. Subscribers get a "reconnected" event, since messages published
+// meanwhile are lost.
+func (h *hub) reconnect() {
+	if !h.resubscribe() {
+		return
+	}
+	h.push.Broadcast(map[string]interface{}{"event": "reconnected"})
+}
+
+// resubscribe swaps the hub's connection for one on the current client,
+// reporting whether there was one.
+func (h *hub) resubscribe() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ps == nil {
+		return false
+	}
+	old := h.ps
+	ctx, cancel := context.WithTimeout(h.push.Context(), subscribeTimeout)
+	defer cancel()
+	h.ps = h.p.client.Load().Subscribe(ctx)
+	if err := h.subscribe(ctx, keys(h.channels), keys(h.patterns)); err != nil {
+		// kv-go subscribes again when the connection is next used.
+		h.p.logger.Warn("kv: resubscribe failed", "error", err)
+	}
+	h.start()
+	old.Close()
+	return true
+}
+
+func keys(counts map[string]int) []string {
+	out := make([]string, 0, len(counts))
+	for name := range counts {
+		out = append(out, name)
+	}
+	return out
+}
+
+// close stops every subscription.
+func (h *hub) close() {
+	h.mu.Lock()
+	if h.ps != nil {
+		h.ps.Close()
+		h.ps = nil
+	}
+	h.mu.Unlock()
+	h.push.Close()
+}
+
+// route queues msg for every subscriber of its channel or pattern.
+func (h *hub) route(msg *kv.Message) {
+	event := map[string]interface{}{"channel": msg.Channel, "payload": msg.Payload}
+	if msg.Pattern != "" {
+		event["pattern"] = msg.Pattern
+	}
+	// Keyspace notifications name the key and the event in the channel
+	// and payload; both are reported on their own.
+	if rest, ok := strings.CutPrefix(msg.Channel, "__keyspace@"); ok {
+		if _, key, ok := strings.Cut(rest, "__:"); ok {
+			event["key"], event["event"] = key, msg.Payload
+		}
+	} else if rest, ok := strings.CutPrefix(msg.Channel, "__keyevent@"); ok {
+		if _, name, ok := strings.Cut(rest, "__:"); ok {
+			event["key"], event["event"] = msg.Payload, name
+		}
+	}
+
+	h.push.Each(func(sub push.Subscriber) {
+		s := sub.(*subscription)
+		names, name := s.Channels, msg.Channel
+		if msg.Pattern != "" {
+			names, name = s.Patterns, msg.Pattern
+		}
+		if slices.Contains(names, name) {
+			s.Enqueue(s.encode(maps.Clone(event)))
+		}
+	})
+}
+
+// encode base64-encodes the payload and key of event for s.
+func (s *subscription) encode(event map[string]interface{}) map[string]interface{} {
+	if s.Encoding == encBase64 {
+		for _, f := range []string{"payload", "key"} {
+			if v, ok := event[f]; ok {
+				event[f] = encodeReply(v)
+			}
+		}
+	}
+	return event
+}
+
+// ================================================================
+// Handlers
+// ================================================================
+
+type subscribeReq struct {
+	Channels []string `json:"channels,omitempty"`
+	Patterns []string `json:"patterns,omitempty"` // glob-style, as PSUBSCRIBE
+	// Keyspace and Keyevents subscribe to keyspace notifications of the
+	// configured database: the events on keys matching a pattern, and the
+	// keys hit by an event ("expired", "del", "set", ...). The server must
+	// have them enabled with notify-keyspace-events.
+	Keyspace  []string `json:"keyspace,omitempty"`
+	Keyevents []string `json:"keyevents,omitempty"`
+	Encoding  string   `json:"encoding,omitempty"` // utf8 (default) or base64
+}
+
+type unsubscribeReq struct {
+	Subscription string `json:"subscription"`
+}
+
+// subscribe subscribes the requesting peer to channels and patterns.
+// Messages are pushed as MsgTypeKVMessage events carrying "channel",
+// "payload", "pattern" for a pattern match and, for keyspace
+// notifications, "key" and "event".
+func (p *Proxy) subscribe(ctx context.Context, body []byte) *zap.Message {
+	var req subscribeReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Encoding != "" && req.Encoding != encUTF8 && req.Encoding != encBase64 {
+		return respond(http.StatusBadRequest, map[string]string{"error": "unsupported encoding: " + req.Encoding})
+	}
+	db := p.db.Load()
+	for _, k := range req.Keyspace {
+		req.Patterns = append(req.Patterns, fmt.Sprintf("__keyspace@%d__:%s", db, k))
+	}
+	for _, e := range req.Keyevents {
+		req.Patterns = append(req.Patterns, fmt.Sprintf("__keyevent@%d__:%s", db, e))
+	}
+	if len(req.Channels) == 0 && len(req.Patterns) == 0 {
+		return respond(http.StatusBadRequest, map[string]string{"error": "channels, patterns, keyspace or keyevents required"})
+	}
+	for _, name := range append(req.Channels, req.Patterns...) {
+		if name == "" {
+			return respond(http.StatusBadRequest, map[string]string{"error": "empty channel name"})
+		}
+	}
+
+	s := &subscription{
+		Channels: dedupe(req.Channels),
+		Patterns: dedupe(req.Patterns),
+		Encoding: req.Encoding,
+	}
+	peer := push.PeerFrom(ctx)
+	if peer == "" {
+		return respond(http.StatusBadRequest, map[string]string{"error": "subscriptions need a ZAP peer"})
+	}
+	ctx, cancel := context.WithTimeout(ctx, subscribeTimeout)
+	defer cancel()
+	if err := p.subs.add(ctx, s, peer, auth.FromContext(ctx).Caller); err != nil {
+		return respond(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, s)
+}
+
+// dedupe sorts list and drops repeated names, which would otherwise be
+// counted twice against one subscription.
+func dedupe(list []string) []string {
+	sort.Strings(list)
+	out := list[:0]
+	for i, name := range list {
+		if i == 0 || name != list[i-1] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func (p *Proxy) unsubscribe(ctx context.Context, body []byte) *zap.Message {
+	var req unsubscribeReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if !p.subs.push.Remove(req.Subscription, auth.FromContext(ctx).Caller) {
+		return respond(http.StatusNotFound, map[string]string{"error": "subscription not found"})
+	}
+	return respond(http.StatusOK, map[string]string{"status": "unsubscribed"})
+}
+
+func (p *Proxy) subscriptions(ctx context.Context) *zap.Message {
+	list := p.subs.push.List(auth.FromContext(ctx).Caller)
+	return respond(http.StatusOK, map[string]interface{}{"subscriptions": list, "count": len(list)})
+}
+
+type publishReq struct {
+	Channel  string `json:"channel"`
+	Message  string `json:"message"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// publish sends a message to a channel and returns the number of
+// subscribers that received it. A raw message is the body of a request
+// with the channel in the query string: /publish?channel=invalidate.
+func (p *Proxy) publish(ctx context.Context, body []byte, q url.Values) *zap.Message {
+	var req publishReq
+	if q != nil {
+		req = publishReq{Channel: q.Get("channel"), Message: string(body), Encoding: rawEncoding(q)}
+	} else if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	} else if req.Encoding == encRaw {
+		return respond(http.StatusBadRequest, map[string]string{"error": "raw values go in the body, with the parameters in the query string"})
+	}
+	if !validEncoding(req.Encoding) {
+		return respond(http.StatusBadRequest, map[string]string{"error": "unknown encoding: " + req.Encoding})
+	}
+	if req.Channel == "" {
+		return respond(http.StatusBadRequest, map[string]string{"error": "channel required"})
+	}
+	msg, err := decodeValue(req.Encoding, req.Message)
+	if err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	n, err := p.client.Load().Publish(ctx, req.Channel, msg).Result()
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, map[string]interface{}{"receivers": n})
+}
@@ -0,0 +1,103 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/luxfi/zap"
+
+	kv "github.com/hanzoai/kv-go/v9"
+
+	"github.com/hanzoai/zap-sidecar/internal/push"
+)
+
+func TestHubRoute(t *testing.T) {
+	sent := make(chan map[string]interface{}, 8)
+	h := &hub{push: push.NewHub(push.Config{
+		Mode: "kv",
+		Send: func(ctx context.Context, peer string, msg *zap.Message) error {
+			var event map[string]interface{}
+			_, body := unpack(msg)
+			if err := json.NewDecoder(bytes.NewReader(body)).Decode(&event); err != nil {
+				return err
+			}
+			sent <- event
+			return nil
+		},
+	})}
+	defer h.push.Close()
+	orders := &subscription{Channels: []string{"orders"}}
+	keys := &subscription{Patterns: []string{"__keyspace@0__:user:*", "__keyevent@0__:expired"}}
+	binary := &subscription{Channels: []string{"orders"}, Encoding: encBase64}
+	byID := make(map[string]*subscription)
+	for _, s := range []*subscription{orders, keys, binary} {
+		if err := h.push.Add(s, "peer", "", true, nil); err != nil {
+			t.Fatal(err)
+		}
+		byID[s.ID] = s
+	}
+
+	tests := []struct {
+		msg  kv.Message
+		want map[*subscription]map[string]interface{}
+	}{
+		{
+			kv.Message{Channel: "orders", Payload: "\x00new"},
+			map[*subscription]map[string]interface{}{
+				orders: {"channel": "orders", "payload": "\x00new"},
+				binary: {"channel": "orders", "payload": "AG5ldw=="},
+			},
+		},
+		{kv.Message{Channel: "other", Payload: "x"}, nil},
+		{
+			kv.Message{Channel: "__keyspace@0__:user:1", Pattern: "__keyspace@0__:user:*", Payload: "set"},
+			map[*subscription]map[string]interface{}{
+				keys: {"channel": "__keyspace@0__:user:1", "pattern": "__keyspace@0__:user:*", "payload": "set", "key": "user:1", "event": "set"},
+			},
+		},
+		{
+			kv.Message{Channel: "__keyevent@0__:expired", Pattern: "__keyevent@0__:expired", Payload: "session:9"},
+			map[*subscription]map[string]interface{}{
+				keys: {"channel": "__keyevent@0__:expired", "pattern": "__keyevent@0__:expired", "payload": "session:9", "key": "session:9", "event": "expired"},
+			},
+		},
+		// A channel named like a pattern is not a pattern match.
+		{kv.Message{Channel: "__keyevent@0__:expired", Payload: "x"}, nil},
+	}
+	for _, tt := range tests {
+		h.route(&tt.msg)
+		// Unwanted pushes of a case show up as mismatches in the next.
+		got := make(map[*subscription]map[string]interface{})
+		for range tt.want {
+			select {
+			case event := <-sent:
+				got[byID[event["subscription"].(string)]] = event
+				delete(event, "subscription")
+			case <-time.After(time.Second):
+				t.Fatalf("%s: got %v, want %v", tt.msg.Channel, got, tt.want)
+			}
+		}
+		for s, want := range tt.want {
+			if !maps.Equal(got[s], want) {
+				t.Errorf("%s: subscription %v got %v, want %v", tt.msg.Channel, slices.Concat(s.Channels, s.Patterns), got[s], want)
+			}
+		}
+	}
+	select {
+	case event := <-sent:
+		t.Errorf("unexpected push %v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	got := dedupe([]string{"b", "a", "b", "c", "a"})
+	if want := []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("dedupe = %v, want %v", got, want)
+	}
+}
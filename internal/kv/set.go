@@ -0,0 +1,201 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/luxfi/zap"
+
+	kv "github.com/hanzoai/kv-go/v9"
+)
+
+// maxSetItems bounds the keys in one /mset request.
+const maxSetItems = 1000
+
+// setReq is one SET with its options. TTL and TTLMS are mutually
+// exclusive, as are NX and XX, and KeepTTL with either TTL.
+type setReq struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	TTL     int64  `json:"ttl,omitempty"`     // seconds
+	TTLMS   int64  `json:"ttl_ms,omitempty"`  // milliseconds
+	NX      bool   `json:"nx,omitempty"`      // only set a key that does not exist
+	XX      bool   `json:"xx,omitempty"`      // only set a key that exists
+	Get     bool   `json:"get,omitempty"`     // return the old value as "old"
+	KeepTTL bool   `json:"keepttl,omitempty"` // keep the key's current expiry
+	// Encoding is that of Value and of the old value; a raw value is the
+	// body of a request with its parameters in the query string.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// parseSetReq reads the parameters of a raw /set from its query string.
+func parseSetReq(q url.Values, body []byte) (setReq, error) {
+	r := setReq{Key: q.Get("key"), Value: string(body), Encoding: rawEncoding(q)}
+	var err error
+	for _, f := range []struct {
+		name string
+		v    *bool
+	}{{"nx", &r.NX}, {"xx", &r.XX}, {"get", &r.Get}, {"keepttl", &r.KeepTTL}} {
+		if *f.v, err = boolParam(q, f.name); err != nil {
+			return r, fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	if r.TTL, err = intParam(q, "ttl"); err != nil {
+		return r, fmt.Errorf("ttl: %w", err)
+	}
+	if r.TTLMS, err = intParam(q, "ttl_ms"); err != nil {
+		return r, fmt.Errorf("ttl_ms: %w", err)
+	}
+	return r, nil
+}
+
+// args checks r, decoding its value, and returns its SET options.
+func (r *setReq) args(raw bool) (kv.SetArgs, error) {
+	var a kv.SetArgs
+	switch {
+	case r.Key == "":
+		return a, errors.New("key required")
+	case !validEncoding(r.Encoding):
+		return a, errors.New("unknown encoding: " + r.Encoding)
+	case r.Encoding == encRaw && !raw:
+		return a, errors.New("raw values go in the body, with the parameters in the query string")
+	case r.TTL < 0 || r.TTLMS < 0:
+		return a, errors.New("ttl must not be negative")
+	case r.TTL > math.MaxInt64/int64(time.Second) || r.TTLMS > math.MaxInt64/int64(time.Millisecond):
+		return a, errors.New("ttl too large")
+	case r.TTL > 0 && r.TTLMS > 0:
+		return a, errors.New("ttl and ttl_ms are exclusive")
+	case r.KeepTTL && (r.TTL > 0 || r.TTLMS > 0):
+		return a, errors.New("keepttl excludes ttl")
+	case r.NX && r.XX:
+		return a, errors.New("nx and xx are exclusive")
+	}
+	var err error
+	if r.Value, err = decodeValue(r.Encoding, r.Value); err != nil {
+		return a, err
+	}
+	// kv-go sends EX for whole seconds and PX otherwise.
+	a.TTL = time.Duration(r.TTL)*time.Second + time.Duration(r.TTLMS)*time.Millisecond
+	a.KeepTTL, a.Get = r.KeepTTL, r.Get
+	if r.NX {
+		a.Mode = "NX"
+	} else if r.XX {
+		a.Mode = "XX"
+	}
+	return a, nil
+}
+
+// result reports whether the SET wrote the key, and the old value if
+// requested. Without GET a nil reply means the NX or XX condition failed;
+// with GET the reply is the old value, which decides the condition.
+func (r *setReq) result(cmd *kv.StatusCmd) (map[string]interface{}, error) {
+	old, err := cmd.Result()
+	if err != nil && err != kv.Nil {
+		return nil, err
+	}
+	found := err == nil
+	out := map[string]interface{}{"status": "OK"}
+	if !r.Get {
+		out["written"] = found
+		return out, nil
+	}
+	switch {
+	case r.NX:
+		out["written"] = !found
+	case r.XX:
+		out["written"] = found
+	default:
+		out["written"] = true
+	}
+	out["old"] = nil
+	if found {
+		out["old"] = old
+		if r.Encoding == encBase64 || r.Encoding == encRaw {
+			out["old"] = encodeReply(old)
+		}
+	}
+	return out, nil
+}
+
+func (p *Proxy) set(ctx context.Context, body []byte, q url.Values) *zap.Message {
+	var req setReq
+	var err error
+	if q != nil {
+		req, err = parseSetReq(q, body)
+	} else {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	a, err := req.args(q != nil)
+	if err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	out, err := req.result(p.client.Load().SetArgs(ctx, req.Key, req.Value, a))
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, out)
+}
+
+// mset runs several SETs, each with its own options, pipelined in one
+// round trip. The SETs are independent: one failing does not undo the
+// others, and its result carries the error.
+func (p *Proxy) mset(ctx context.Context, body []byte) *zap.Message {
+	var req struct {
+		Items    []setReq `json:"items"`
+		Encoding string   `json:"encoding,omitempty"` // default for the items
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(req.Items) == 0 || len(req.Items) > maxSetItems {
+		return respond(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("mset needs 1 to %d items", maxSetItems)})
+	}
+	args := make([]kv.SetArgs, len(req.Items))
+	for i := range req.Items {
+		if req.Items[i].Encoding == "" {
+			req.Items[i].Encoding = req.Encoding
+		}
+		a, err := req.Items[i].args(false)
+		if err != nil {
+			return respond(http.StatusBadRequest, map[string]interface{}{"error": err.Error(), "index": i})
+		}
+		args[i] = a
+	}
+
+	cmds := make([]*kv.StatusCmd, len(req.Items))
+	_, err := p.client.Load().Pipelined(ctx, func(pipe kv.Pipeliner) error {
+		for i, item := range req.Items {
+			cmds[i] = pipe.SetArgs(ctx, item.Key, item.Value, args[i])
+		}
+		return nil
+	})
+	// Pipelined returns the first command's error; only a failure of the
+	// connection fails the whole request.
+	var replyErr kv.Error
+	if err != nil && err != kv.Nil && !errors.As(err, &replyErr) {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	results := make([]map[string]interface{}, len(cmds))
+	written := 0
+	for i, cmd := range cmds {
+		out, err := req.Items[i].result(cmd)
+		if err != nil {
+			out = map[string]interface{}{"error": err.Error()}
+		} else if out["written"] == true {
+			written++
+		}
+		results[i] = out
+	}
+	return respond(http.StatusOK, map[string]interface{}{"results": results, "count": len(results), "written": written})
+}
@@ -0,0 +1,109 @@
+package kv
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	kv "github.com/hanzoai/kv-go/v9"
+)
+
+func TestSetReqArgs(t *testing.T) {
+	tests := []struct {
+		req     setReq
+		raw     bool
+		want    kv.SetArgs
+		value   string
+		wantErr string
+	}{
+		{req: setReq{Key: "k", Value: "v"}, value: "v"},
+		{req: setReq{Key: "k", TTL: 60}, want: kv.SetArgs{TTL: time.Minute}},
+		{req: setReq{Key: "k", TTLMS: 1500}, want: kv.SetArgs{TTL: 1500 * time.Millisecond}},
+		{req: setReq{Key: "k", NX: true, Get: true}, want: kv.SetArgs{Mode: "NX", Get: true}},
+		{req: setReq{Key: "k", XX: true, KeepTTL: true}, want: kv.SetArgs{Mode: "XX", KeepTTL: true}},
+		{req: setReq{Key: "k", Value: "AP8=", Encoding: encBase64}, value: "\x00\xff"},
+		{req: setReq{Key: "k", Value: "\x00", Encoding: encRaw}, raw: true, value: "\x00"},
+		{req: setReq{Value: "v"}, wantErr: "key required"},
+		{req: setReq{Key: "k", Encoding: "hex"}, wantErr: "unknown encoding"},
+		{req: setReq{Key: "k", Encoding: encRaw}, wantErr: "raw values go in the body"},
+		{req: setReq{Key: "k", TTL: -1}, wantErr: "must not be negative"},
+		{req: setReq{Key: "k", TTL: math.MaxInt64 / int64(time.Second)}, want: kv.SetArgs{TTL: math.MaxInt64 / time.Second * time.Second}},
+		{req: setReq{Key: "k", TTL: math.MaxInt64/int64(time.Second) + 1}, wantErr: "ttl too large"},
+		{req: setReq{Key: "k", TTLMS: math.MaxInt64/int64(time.Millisecond) + 1}, wantErr: "ttl too large"},
+		{req: setReq{Key: "k", TTL: 1, TTLMS: 1}, wantErr: "exclusive"},
+		{req: setReq{Key: "k", KeepTTL: true, TTL: 1}, wantErr: "keepttl excludes ttl"},
+		{req: setReq{Key: "k", NX: true, XX: true}, wantErr: "nx and xx"},
+		{req: setReq{Key: "k", Value: "!", Encoding: encBase64}, wantErr: "not base64"},
+	}
+	for _, tt := range tests {
+		r := tt.req
+		got, err := r.args(tt.raw)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%+v: err = %v, want it to contain %q", tt.req, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want || r.Value != tt.value {
+			t.Errorf("%+v: args = %+v, %v, value %q; want %+v, %q", tt.req, got, err, r.Value, tt.want, tt.value)
+		}
+	}
+}
+
+func TestSetReqResult(t *testing.T) {
+	tests := []struct {
+		req  setReq
+		val  string
+		err  error
+		want map[string]interface{}
+	}{
+		{setReq{}, "OK", nil, map[string]interface{}{"status": "OK", "written": true}},
+		{setReq{NX: true}, "", kv.Nil, map[string]interface{}{"status": "OK", "written": false}},
+		{setReq{Get: true}, "", kv.Nil, map[string]interface{}{"status": "OK", "written": true, "old": nil}},
+		{setReq{Get: true}, "old", nil, map[string]interface{}{"status": "OK", "written": true, "old": "old"}},
+		// With GET the old value decides whether NX or XX held.
+		{setReq{NX: true, Get: true}, "old", nil, map[string]interface{}{"status": "OK", "written": false, "old": "old"}},
+		{setReq{NX: true, Get: true}, "", kv.Nil, map[string]interface{}{"status": "OK", "written": true, "old": nil}},
+		{setReq{XX: true, Get: true}, "", kv.Nil, map[string]interface{}{"status": "OK", "written": false, "old": nil}},
+		{setReq{XX: true, Get: true}, "old", nil, map[string]interface{}{"status": "OK", "written": true, "old": "old"}},
+		{setReq{Get: true, Encoding: encBase64}, "\x00\xff", nil, map[string]interface{}{"status": "OK", "written": true, "old": "AP8="}},
+	}
+	for _, tt := range tests {
+		got, err := tt.req.result(kv.NewStatusResult(tt.val, tt.err))
+		if err != nil {
+			t.Errorf("%+v with %q, %v: %v", tt.req, tt.val, tt.err, err)
+			continue
+		}
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("%+v with %q, %v: result = %v, want %v", tt.req, tt.val, tt.err, got, tt.want)
+		}
+	}
+
+	failure := errors.New("connection reset")
+	if _, err := (&setReq{}).result(kv.NewStatusResult("", failure)); !errors.Is(err, failure) {
+		t.Errorf("failed SET: err = %v, want %v", err, failure)
+	}
+}
+
+func TestParseSetReq(t *testing.T) {
+	q, _ := url.ParseQuery("key=k&ttl=5&nx&get=false")
+	r, err := parseSetReq(q, []byte("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := setReq{Key: "k", Value: "body", TTL: 5, NX: true, Encoding: encRaw}
+	if r != want {
+		t.Errorf("parseSetReq = %+v, want %+v", r, want)
+	}
+
+	for _, query := range []string{"key=k&nx=maybe", "key=k&ttl=soon"} {
+		q, _ := url.ParseQuery(query)
+		if _, err := parseSetReq(q, nil); err == nil {
+			t.Errorf("parseSetReq(%q) succeeded", query)
+		}
+	}
+}
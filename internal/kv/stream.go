@@ -0,0 +1,402 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/luxfi/zap"
+
+	kv "github.com/hanzoai/kv-go/v9"
+)
+
+const (
+	defaultStreamCount = 100
+	maxStreamCount     = 1000
+
+	// defaultMaxStreamBlock caps block_ms when Config.MaxStreamBlock is 0.
+	// ZAP runs a connection's requests one at a time, so a blocked read
+	// holds up every other request of the peer, and shutdown drains wait
+	// for it; consumers that block longer belong on their own connection.
+	defaultMaxStreamBlock = 5 * time.Second
+)
+
+func (p *Proxy) setMaxStreamBlock(d time.Duration) {
+	if d <= 0 {
+		d = defaultMaxStreamBlock
+	}
+	p.maxBlock.Store(int64(d))
+}
+
+// streamReq is the request of every /stream path; each uses the fields
+// it needs. Encoding (utf8 or base64) applies to field values.
+type streamReq struct {
+	Stream   string   `json:"stream"`
+	Streams  []string `json:"streams,omitempty"` // /stream/read-group: several streams
+	Group    string   `json:"group,omitempty"`
+	Consumer string   `json:"consumer,omitempty"`
+	Encoding string   `json:"encoding,omitempty"`
+
+	// /stream/add
+	ID     string            `json:"id,omitempty"` // default "*"
+	Values map[string]string `json:"values,omitempty"`
+	MaxLen int64             `json:"maxlen,omitempty"` // trim to about this many entries
+	MinID  string            `json:"minid,omitempty"`  // trim entries below this ID; exclusive with MaxLen
+	NoMk   bool              `json:"nomkstream,omitempty"`
+
+	// /stream/read-group: Start is ">" (default) for new entries, "0"
+	// for the consumer's pending ones. /stream/claim and /stream/group
+	// use Start and Count too.
+	Start   string `json:"start,omitempty"`
+	Count   int64  `json:"count,omitempty"`
+	BlockMS int64  `json:"block_ms,omitempty"` // wait up to this long for entries
+	NoAck   bool   `json:"noack,omitempty"`
+
+	// /stream/ack and /stream/claim
+	IDs       []string `json:"ids,omitempty"`
+	MinIdleMS int64    `json:"min_idle_ms,omitempty"`
+
+	// /stream/group
+	Action string `json:"action,omitempty"` // create, destroy, setid or delconsumer
+}
+
+// streamEntry is one stream entry in a response.
+type streamEntry struct {
+	Stream string                 `json:"stream,omitempty"`
+	ID     string                 `json:"id"`
+	Values map[string]interface{} `json:"values"`
+}
+
+func entries(stream string, msgs []kv.XMessage, enc string) []streamEntry {
+	out := make([]streamEntry, len(msgs))
+	for i, m := range msgs {
+		out[i] = streamEntry{Stream: stream, ID: m.ID, Values: m.Values}
+		if enc == encBase64 {
+			out[i].Values = make(map[string]interface{}, len(m.Values))
+			for k, v := range m.Values {
+				out[i].Values[k] = encodeReply(v)
+			}
+		}
+	}
+	return out
+}
+
+// parseStreamReq decodes a /stream request and checks the fields every
+// path needs. Only /stream/read-group, with multi set, takes streams.
+func parseStreamReq(body []byte, needGroup, multi bool) (*streamReq, *zap.Message) {
+	var req streamReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	switch {
+	case multi && req.Stream == "" && len(req.Streams) == 0:
+		return nil, respond(http.StatusBadRequest, map[string]string{"error": "stream or streams required"})
+	case !multi && req.Stream == "":
+		return nil, respond(http.StatusBadRequest, map[string]string{"error": "stream required"})
+	case !multi && len(req.Streams) > 0:
+		return nil, respond(http.StatusBadRequest, map[string]string{"error": "streams is only accepted by /stream/read-group"})
+	}
+	if needGroup && req.Group == "" {
+		return nil, respond(http.StatusBadRequest, map[string]string{"error": "group required"})
+	}
+	if !validEncoding(req.Encoding) || req.Encoding == encRaw {
+		return nil, respond(http.StatusBadRequest, map[string]string{"error": "unsupported encoding: " + req.Encoding})
+	}
+	if req.Count < 0 || req.Count > maxStreamCount {
+		return nil, respond(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("count must be 0 to %d", maxStreamCount)})
+	}
+	if req.Count == 0 {
+		req.Count = defaultStreamCount
+	}
+	return &req, nil
+}
+
+// streamError maps the errors of missing and existing groups to 404 and
+// 409.
+func streamError(err error) *zap.Message {
+	status := http.StatusInternalServerError
+	switch {
+	case strings.HasPrefix(err.Error(), "NOGROUP"):
+		status = http.StatusNotFound
+	case strings.HasPrefix(err.Error(), "BUSYGROUP"):
+		status = http.StatusConflict
+	}
+	return respond(status, map[string]string{"error": err.Error()})
+}
+
+// streamAdd appends an entry with XADD, optionally trimming the stream.
+func (p *Proxy) streamAdd(ctx context.Context, body []byte) *zap.Message {
+	req, msg := parseStreamReq(body, false, false)
+	if msg != nil {
+		return msg
+	}
+	if len(req.Values) == 0 {
+		return respond(http.StatusBadRequest, map[string]string{"error": "values required"})
+	}
+	if req.MaxLen > 0 && req.MinID != "" {
+		return respond(http.StatusBadRequest, map[string]string{"error": "maxlen and minid are exclusive"})
+	}
+	values := make([]interface{}, 0, 2*len(req.Values))
+	for k, v := range req.Values {
+		v, err := decodeValue(req.Encoding, v)
+		if err != nil {
+			return respond(http.StatusBadRequest, map[string]string{"error": k + ": " + err.Error()})
+		}
+		values = append(values, k, v)
+	}
+
+	id, err := p.client.Load().XAdd(ctx, &kv.XAddArgs{
+		Stream:     req.Stream,
+		NoMkStream: req.NoMk,
+		MaxLen:     req.MaxLen,
+		MinID:      req.MinID,
+		Approx:     req.MaxLen > 0 || req.MinID != "", // trim whole macro nodes, which is far cheaper
+		ID:         req.ID,
+		Values:     values,
+	}).Result()
+	if err == kv.Nil {
+		return respond(http.StatusNotFound, map[string]string{"error": "no stream: " + req.Stream})
+	}
+	if err != nil {
+		return streamError(err)
+	}
+	return respond(http.StatusOK, map[string]string{"id": id})
+}
+
+// streamReadGroup reads entries for a consumer of a group with
+// XREADGROUP, waiting up to block_ms, capped by MaxStreamBlock, for new
+// ones. Entries read stay pending until acknowledged with /stream/ack.
+func (p *Proxy) streamReadGroup(ctx context.Context, body []byte) *zap.Message {
+	req, msg := parseStreamReq(body, true, true)
+	if msg != nil {
+		return msg
+	}
+	if req.Consumer == "" {
+		return respond(http.StatusBadRequest, map[string]string{"error": "consumer required"})
+	}
+	if req.BlockMS < 0 {
+		return respond(http.StatusBadRequest, map[string]string{"error": "block_ms must not be negative"})
+	}
+	streams := req.Streams
+	if req.Stream != "" {
+		streams = append([]string{req.Stream}, streams...)
+	}
+	if req.Start == "" {
+		req.Start = ">"
+	}
+	args := make([]string, 0, 2*len(streams))
+	args = append(args, streams...)
+	for range streams {
+		args = append(args, req.Start)
+	}
+	block := time.Duration(-1) // kv-go sends no BLOCK for a negative duration
+	if req.BlockMS > 0 {
+		block = min(time.Duration(req.BlockMS)*time.Millisecond, time.Duration(p.maxBlock.Load()))
+	}
+
+	res, err := p.client.Load().XReadGroup(ctx, &kv.XReadGroupArgs{
+		Group:    req.Group,
+		Consumer: req.Consumer,
+		Streams:  args,
+		Count:    req.Count,
+		Block:    block,
+		NoAck:    req.NoAck,
+	}).Result()
+	if err != nil && err != kv.Nil { // nil: the wait timed out
+		return streamError(err)
+	}
+	out := []streamEntry{}
+	for _, s := range res {
+		out = append(out, entries(s.Stream, s.Messages, req.Encoding)...)
+	}
+	return respond(http.StatusOK, map[string]interface{}{"entries": out, "count": len(out)})
+}
+
+// streamAck acknowledges entries with XACK, removing them from the
+// group's pending list.
+func (p *Proxy) streamAck(ctx context.Context, body []byte) *zap.Message {
+	req, msg := parseStreamReq(body, true, false)
+	if msg != nil {
+		return msg
+	}
+	if len(req.IDs) == 0 {
+		return respond(http.StatusBadRequest, map[string]string{"error": "ids required"})
+	}
+	n, err := p.client.Load().XAck(ctx, req.Stream, req.Group, req.IDs...).Result()
+	if err != nil {
+		return streamError(err)
+	}
+	return respond(http.StatusOK, map[string]interface{}{"acked": n})
+}
+
+// streamClaim moves pending entries idle for at least min_idle_ms to
+// another consumer, typically to retry the jobs of a consumer that died:
+// the given ids with XCLAIM or, without ids, the next count entries from
+// start with XAUTOCLAIM, returning the start of the following scan as
+// "next" ("0-0" when the scan is complete).
+func (p *Proxy) streamClaim(ctx context.Context, body []byte) *zap.Message {
+	req, msg := parseStreamReq(body, true, false)
+	if msg != nil {
+		return msg
+	}
+	if req.Consumer == "" {
+		return respond(http.StatusBadRequest, map[string]string{"error": "consumer required"})
+	}
+	if req.MinIdleMS < 0 {
+		return respond(http.StatusBadRequest, map[string]string{"error": "min_idle_ms must not be negative"})
+	}
+	idle := time.Duration(req.MinIdleMS) * time.Millisecond
+	client := p.client.Load()
+
+	if len(req.IDs) > 0 {
+		msgs, err := client.XClaim(ctx, &kv.XClaimArgs{
+			Stream:   req.Stream,
+			Group:    req.Group,
+			Consumer: req.Consumer,
+			MinIdle:  idle,
+			Messages: req.IDs,
+		}).Result()
+		if err != nil && err != kv.Nil {
+			return streamError(err)
+		}
+		out := entries("", msgs, req.Encoding)
+		return respond(http.StatusOK, map[string]interface{}{"entries": out, "count": len(out)})
+	}
+
+	if req.Start == "" {
+		req.Start = "0-0"
+	}
+	msgs, next, err := client.XAutoClaim(ctx, &kv.XAutoClaimArgs{
+		Stream:   req.Stream,
+		Group:    req.Group,
+		Consumer: req.Consumer,
+		MinIdle:  idle,
+		Start:    req.Start,
+		Count:    req.Count,
+	}).Result()
+	if err != nil && err != kv.Nil {
+		return streamError(err)
+	}
+	out := entries("", msgs, req.Encoding)
+	return respond(http.StatusOK, map[string]interface{}{"entries": out, "count": len(out), "next": next})
+}
+
+// streamInfo describes a stream and its groups or, with a group, the
+// group's consumers and pending entries.
+func (p *Proxy) streamInfo(ctx context.Context, body []byte) *zap.Message {
+	req, msg := parseStreamReq(body, false, false)
+	if msg != nil {
+		return msg
+	}
+	client := p.client.Load()
+
+	if req.Group != "" {
+		consumers, err := client.XInfoConsumers(ctx, req.Stream, req.Group).Result()
+		if err != nil {
+			return streamError(err)
+		}
+		pending, err := client.XPending(ctx, req.Stream, req.Group).Result()
+		if err != nil {
+			return streamError(err)
+		}
+		list := make([]map[string]interface{}, len(consumers))
+		for i, c := range consumers {
+			list[i] = map[string]interface{}{
+				"name":        c.Name,
+				"pending":     c.Pending,
+				"idle_ms":     c.Idle.Milliseconds(),
+				"inactive_ms": c.Inactive.Milliseconds(),
+			}
+		}
+		return respond(http.StatusOK, map[string]interface{}{
+			"stream":    req.Stream,
+			"group":     req.Group,
+			"consumers": list,
+			"pending": map[string]interface{}{
+				"count":     pending.Count,
+				"lowest":    pending.Lower,
+				"highest":   pending.Higher,
+				"consumers": pending.Consumers,
+			},
+		})
+	}
+
+	info, err := client.XInfoStream(ctx, req.Stream).Result()
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "ERR no such key") {
+			return respond(http.StatusNotFound, map[string]string{"error": "no stream: " + req.Stream})
+		}
+		return streamError(err)
+	}
+	groups, err := client.XInfoGroups(ctx, req.Stream).Result()
+	if err != nil {
+		return streamError(err)
+	}
+	list := make([]map[string]interface{}, len(groups))
+	for i, g := range groups {
+		list[i] = map[string]interface{}{
+			"name":              g.Name,
+			"consumers":         g.Consumers,
+			"pending":           g.Pending,
+			"last_delivered_id": g.LastDeliveredID,
+			"entries_read":      g.EntriesRead,
+			"lag":               g.Lag,
+		}
+	}
+	out := map[string]interface{}{
+		"stream":            req.Stream,
+		"length":            info.Length,
+		"last_generated_id": info.LastGeneratedID,
+		"entries_added":     info.EntriesAdded,
+		"groups":            list,
+	}
+	if info.Length > 0 {
+		out["first_entry"] = entries("", []kv.XMessage{info.FirstEntry}, req.Encoding)[0]
+		out["last_entry"] = entries("", []kv.XMessage{info.LastEntry}, req.Encoding)[0]
+	}
+	return respond(http.StatusOK, out)
+}
+
+// streamGroup manages consumer groups: create (from start, default "$",
+// creating the stream if missing), destroy, setid (move the group's last
+// delivered ID to start) and delconsumer (whose pending entries are
+// dropped).
+func (p *Proxy) streamGroup(ctx context.Context, body []byte) *zap.Message {
+	req, msg := parseStreamReq(body, true, false)
+	if msg != nil {
+		return msg
+	}
+	if req.Start == "" {
+		req.Start = "$"
+	}
+	client := p.client.Load()
+
+	var err error
+	out := map[string]interface{}{"status": "OK"}
+	switch req.Action {
+	case "create":
+		err = client.XGroupCreateMkStream(ctx, req.Stream, req.Group, req.Start).Err()
+	case "setid":
+		err = client.XGroupSetID(ctx, req.Stream, req.Group, req.Start).Err()
+	case "destroy":
+		var n int64
+		n, err = client.XGroupDestroy(ctx, req.Stream, req.Group).Result()
+		out["destroyed"] = n
+	case "delconsumer":
+		if req.Consumer == "" {
+			return respond(http.StatusBadRequest, map[string]string{"error": "consumer required"})
+		}
+		var n int64
+		n, err = client.XGroupDelConsumer(ctx, req.Stream, req.Group, req.Consumer).Result()
+		out["pending"] = n
+	default:
+		return respond(http.StatusBadRequest, map[string]string{"error": "action must be create, destroy, setid or delconsumer"})
+	}
+	if err != nil {
+		return streamError(err)
+	}
+	return respond(http.StatusOK, out)
+}
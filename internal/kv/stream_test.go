@@ -0,0 +1,53 @@
+package kv
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseStreamReq(t *testing.T) {
+	tests := []struct {
+		body      string
+		needGroup bool
+		multi     bool
+		wantErr   string
+	}{
+		{`{"stream": "s"}`, false, false, ""},
+		{`{"stream": "s", "group": "g"}`, true, true, ""},
+		{`{"streams": ["a", "b"], "group": "g"}`, true, true, ""},
+		{`{}`, false, false, "stream required"},
+		{`{"streams": ["a"]}`, false, false, "stream required"},
+		{`{"stream": "s", "streams": ["a"]}`, true, false, "only accepted by /stream/read-group"},
+		{`{"group": "g"}`, true, true, "stream or streams required"},
+		{`{"stream": "s"}`, true, false, "group required"},
+		{`{"stream": "s", "encoding": "raw"}`, false, false, "unsupported encoding"},
+		{`{"stream": "s", "count": -1}`, false, false, "count must be"},
+	}
+	for _, tt := range tests {
+		_, msg := parseStreamReq([]byte(tt.body), tt.needGroup, tt.multi)
+		if tt.wantErr == "" {
+			if msg != nil {
+				_, body := unpack(msg)
+				t.Errorf("%s: %s", tt.body, body)
+			}
+			continue
+		}
+		if msg == nil {
+			t.Errorf("%s: accepted, want %q", tt.body, tt.wantErr)
+			continue
+		}
+		if status, body := unpack(msg); status != http.StatusBadRequest || !strings.Contains(string(body), tt.wantErr) {
+			t.Errorf("%s: %d %s, want 400 containing %q", tt.body, status, body, tt.wantErr)
+		}
+	}
+}
+
+func TestStreamAddTrim(t *testing.T) {
+	p := &Proxy{}
+	body := `{"stream": "s", "values": {"a": "1"}, "maxlen": 100, "minid": "0-1"}`
+	if status, out := unpack(p.streamAdd(context.Background(), []byte(body))); status != http.StatusBadRequest {
+		t.Errorf("maxlen with minid: %d %s, want 400", status, out)
+	}
+}
@@ -13,9 +13,13 @@
 // Schema reference: zap/schema/zap.capnp
 package internal
 
+import "encoding/json"
+
 // ToolDef defines a ZAP/MCP tool exposed by a proxy backend.
+// Path is the ZAP path the tool call is dispatched to.
 type ToolDef struct {
 	Name        string                 `json:"name"`
+	Path        string                 `json:"-"`
 	Description string                 `json:"description"`
 	Schema      map[string]interface{} `json:"inputSchema"`
 }
@@ -28,34 +32,186 @@ type ResourceDef struct {
 	MimeType    string `json:"mimeType"`
 }
 
+// ToolCall is the body of a /tools/call request.
+type ToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// Content is an MCP content block.
+type Content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// ToolResult is the MCP result of a tool call.
+type ToolResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// ResourceRead is the body of a /resources/read request.
+type ResourceRead struct {
+	URI string `json:"uri"`
+}
+
+// ResourceContents is one entry of an MCP readResource result.
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// FindTool returns the tool with the given name.
+func FindTool(tools []ToolDef, name string) (ToolDef, bool) {
+	for _, t := range tools {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return ToolDef{}, false
+}
+
+// FindResource returns the resource with the given URI.
+func FindResource(resources []ResourceDef, uri string) (ResourceDef, bool) {
+	for _, r := range resources {
+		if r.URI == uri {
+			return r, true
+		}
+	}
+	return ResourceDef{}, false
+}
+
+// JSONContents encodes v as the contents of resource res.
+func JSONContents(res ResourceDef, v interface{}) ([]ResourceContents, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return []ResourceContents{{URI: res.URI, MimeType: res.MimeType, Text: string(b)}}, nil
+}
+
+// NewToolResult wraps a proxy response as an MCP tool result.
+// Responses with an HTTP error status are reported as tool errors.
+func NewToolResult(status int, body []byte) ToolResult {
+	return ToolResult{
+		Content: []Content{{Type: "text", Text: string(body)}},
+		IsError: status >= 400,
+	}
+}
+
 // SQLTools defines the MCP tools exposed by the SQL proxy.
 var SQLTools = []ToolDef{
 	{
 		Name:        "sql_query",
-		Description: "Execute a read-only SQL query against PostgreSQL and return results as JSON rows",
+		Path:        "/query",
+		Description: "Execute a read-only SQL query against PostgreSQL and return typed JSON rows with column metadata",
 		Schema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
-				"sql":  map[string]string{"type": "string", "description": "SQL SELECT query"},
-				"args": map[string]string{"type": "array", "description": "Query parameters"},
+				"sql":        map[string]string{"type": "string", "description": "SQL SELECT query"},
+				"args":       map[string]string{"type": "array", "description": "Query parameters"},
+				"format":     map[string]string{"type": "string", "description": "Row layout: objects (default; needs unique column names), arrays or columnar"},
+				"max_rows":   map[string]string{"type": "integer", "description": "Rows per page; more rows are fetched with sql_query_next"},
+				"timeout_ms": map[string]string{"type": "integer", "description": "Statement timeout in milliseconds"},
+				"settings":   map[string]string{"type": "object", "description": "SQL settings such as app.tenant_id, as allowed by the caller's policy"},
 			},
 			"required": []string{"sql"},
 		},
 	},
+	{
+		Name:        "sql_query_next",
+		Path:        "/query/next",
+		Description: "Fetch the next page of a paged sql_query result",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"cursor":   map[string]string{"type": "string", "description": "Cursor returned by the previous page"},
+				"max_rows": map[string]string{"type": "integer", "description": "Rows per page (default: the query's max_rows)"},
+				"format":   map[string]string{"type": "string", "description": "Row layout: objects (default; needs unique column names), arrays or columnar"},
+			},
+			"required": []string{"cursor"},
+		},
+	},
 	{
 		Name:        "sql_exec",
+		Path:        "/exec",
 		Description: "Execute a write SQL statement (INSERT, UPDATE, DELETE) and return affected row count",
 		Schema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
-				"sql":  map[string]string{"type": "string", "description": "SQL statement"},
-				"args": map[string]string{"type": "array", "description": "Statement parameters"},
+				"sql":        map[string]string{"type": "string", "description": "SQL statement"},
+				"args":       map[string]string{"type": "array", "description": "Statement parameters"},
+				"timeout_ms": map[string]string{"type": "integer", "description": "Statement timeout in milliseconds"},
+				"settings":   map[string]string{"type": "object", "description": "SQL settings such as app.tenant_id, as allowed by the caller's policy"},
 			},
 			"required": []string{"sql"},
 		},
 	},
+	{
+		Name:        "sql_batch",
+		Path:        "/batch",
+		Description: "Execute several SQL statements in one round trip, optionally in one transaction, and return per-statement results or the index of the first failure",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":        "array",
+					"description": "Statements to run in order",
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"sql":  map[string]string{"type": "string", "description": "SQL statement"},
+							"args": map[string]string{"type": "array", "description": "Statement parameters"},
+						},
+						"required": []string{"sql"},
+					},
+				},
+				"transaction": map[string]string{"type": "boolean", "description": "Wrap the batch in one explicit transaction"},
+				"isolation":   map[string]string{"type": "string", "description": "Isolation level when transaction is set"},
+				"tx":          map[string]string{"type": "string", "description": "ID of a transaction opened with /tx/begin to run the batch in"},
+				"format":      map[string]string{"type": "string", "description": "Row layout of every statement: objects (default; needs unique column names), arrays or columnar"},
+			},
+			"required": []string{"items"},
+		},
+	},
+	{
+		Name:        "sql_vector_search",
+		Path:        "/vector/search",
+		Description: "Find the k rows nearest to a query vector in a pgvector column, with their distance",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"table":   map[string]string{"type": "string", "description": "Table, optionally schema-qualified"},
+				"column":  map[string]string{"type": "string", "description": "vector or halfvec column"},
+				"vector":  map[string]string{"type": "array", "description": "Query vector"},
+				"metric":  map[string]string{"type": "string", "description": "l2 (default), cosine or inner_product"},
+				"k":       map[string]string{"type": "integer", "description": "Number of results (default 10, max 1000)"},
+				"columns": map[string]string{"type": "array", "description": "Columns to return (default all)"},
+				"filter":  map[string]string{"type": "object", "description": "Column values the rows must equal"},
+				"format":  map[string]string{"type": "string", "description": "Row layout: objects (default; needs unique column names), arrays or columnar"},
+			},
+			"required": []string{"table", "column", "vector"},
+		},
+	},
+	{
+		Name:        "sql_vector_upsert",
+		Path:        "/vector/upsert",
+		Description: "Bulk insert or update rows with embeddings using COPY",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"table":   map[string]string{"type": "string", "description": "Table, optionally schema-qualified"},
+				"columns": map[string]string{"type": "array", "description": "Columns in row order"},
+				"rows":    map[string]string{"type": "array", "description": "Rows as arrays of values; vectors as arrays of numbers"},
+				"key":     map[string]string{"type": "array", "description": "Conflict columns; existing rows are updated"},
+			},
+			"required": []string{"table", "columns", "rows"},
+		},
+	},
 	{
 		Name:        "sql_health",
+		Path:        "/health",
 		Description: "Check PostgreSQL connection health",
 		Schema: map[string]interface{}{
 			"type": "object", "properties": map[string]interface{}{},
@@ -67,30 +223,57 @@ var SQLTools = []ToolDef{
 var KVTools = []ToolDef{
 	{
 		Name:        "kv_get",
+		Path:        "/get",
 		Description: "Get a value by key from Hanzo KV (Valkey/Redis)",
 		Schema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
-				"key": map[string]string{"type": "string", "description": "Key to retrieve"},
+				"key":      map[string]string{"type": "string", "description": "Key to retrieve"},
+				"encoding": map[string]string{"type": "string", "description": "Value encoding: utf8 (default) or base64"},
 			},
 			"required": []string{"key"},
 		},
 	},
 	{
 		Name:        "kv_set",
+		Path:        "/set",
 		Description: "Set a key-value pair in Hanzo KV",
 		Schema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
-				"key":   map[string]string{"type": "string", "description": "Key to set"},
-				"value": map[string]string{"type": "string", "description": "Value to set"},
-				"ttl":   map[string]string{"type": "integer", "description": "TTL in seconds (0 = no expiry)"},
+				"key":      map[string]string{"type": "string", "description": "Key to set"},
+				"value":    map[string]string{"type": "string", "description": "Value to set"},
+				"ttl":      map[string]string{"type": "integer", "description": "TTL in seconds (0 = no expiry)"},
+				"ttl_ms":   map[string]string{"type": "integer", "description": "TTL in milliseconds, instead of ttl"},
+				"nx":       map[string]string{"type": "boolean", "description": "Only set the key if it does not exist"},
+				"xx":       map[string]string{"type": "boolean", "description": "Only set the key if it exists"},
+				"get":      map[string]string{"type": "boolean", "description": "Return the old value"},
+				"keepttl":  map[string]string{"type": "boolean", "description": "Keep the key's current TTL"},
+				"encoding": map[string]string{"type": "string", "description": "Value encoding: utf8 (default) or base64"},
 			},
 			"required": []string{"key", "value"},
 		},
 	},
+	{
+		Name:        "kv_mset",
+		Path:        "/mset",
+		Description: "Set several keys in one round trip, each with its own TTL and conditions",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items": map[string]interface{}{
+					"type":        "array",
+					"description": "kv_set arguments, one object per key",
+					"items":       map[string]string{"type": "object"},
+				},
+				"encoding": map[string]string{"type": "string", "description": "Value encoding: utf8 (default) or base64"},
+			},
+			"required": []string{"items"},
+		},
+	},
 	{
 		Name:        "kv_mget",
+		Path:        "/mget",
 		Description: "Get multiple values by keys from Hanzo KV",
 		Schema: map[string]interface{}{
 			"type": "object",
@@ -99,28 +282,191 @@ var KVTools = []ToolDef{
 					"type":  "array",
 					"items": map[string]string{"type": "string"},
 				},
+				"encoding": map[string]string{"type": "string", "description": "Value encoding: utf8 (default) or base64"},
 			},
 			"required": []string{"keys"},
 		},
 	},
 	{
 		Name:        "kv_cmd",
+		Path:        "/cmd",
 		Description: "Execute an arbitrary Valkey/Redis command",
 		Schema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
-				"cmd":  map[string]string{"type": "string", "description": "Command name (e.g. HGET, LPUSH)"},
-				"args": map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}},
+				"cmd":      map[string]string{"type": "string", "description": "Command name (e.g. HGET, LPUSH)"},
+				"args":     map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}},
+				"encoding": map[string]string{"type": "string", "description": "Value encoding: utf8 (default) or base64"},
 			},
 			"required": []string{"cmd"},
 		},
 	},
+	{
+		Name:        "kv_pipeline",
+		Path:        "/pipeline",
+		Description: "Execute several Valkey/Redis commands in one round trip, returning a result or error per command",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"commands": map[string]interface{}{
+					"type":        "array",
+					"description": "Commands in order, each {cmd, args}",
+					"items":       map[string]string{"type": "object"},
+				},
+				"encoding": map[string]string{"type": "string", "description": "Value encoding: utf8 (default) or base64"},
+			},
+			"required": []string{"commands"},
+		},
+	},
+	{
+		Name:        "kv_tx",
+		Path:        "/tx",
+		Description: "Execute several Valkey/Redis commands atomically with MULTI/EXEC, optionally watching keys for optimistic locking",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"commands": map[string]interface{}{
+					"type":        "array",
+					"description": "Commands in order, each {cmd, args}",
+					"items":       map[string]string{"type": "object"},
+				},
+				"encoding": map[string]string{"type": "string", "description": "Value encoding: utf8 (default) or base64"},
+				"watch": map[string]interface{}{
+					"type":        "array",
+					"description": "Keys whose change aborts the transaction",
+					"items":       map[string]string{"type": "string"},
+				},
+				"expect": map[string]string{"type": "object", "description": "Expected values of keys, null for missing; the transaction is refused if any differs"},
+			},
+			"required": []string{"commands"},
+		},
+	},
+	{
+		Name:        "kv_publish",
+		Path:        "/publish",
+		Description: "Publish a message to a Valkey/Redis Pub/Sub channel and return the number of receivers",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"channel":  map[string]string{"type": "string", "description": "Channel name"},
+				"message":  map[string]string{"type": "string", "description": "Message to publish"},
+				"encoding": map[string]string{"type": "string", "description": "Message encoding: utf8 (default) or base64"},
+			},
+			"required": []string{"channel", "message"},
+		},
+	},
+	{
+		Name:        "kv_stream_add",
+		Path:        "/stream/add",
+		Description: "Append an entry to a Valkey/Redis stream (XADD) and return its ID",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"stream":     map[string]string{"type": "string", "description": "Stream key"},
+				"values":     map[string]string{"type": "object", "description": "Entry fields and their values"},
+				"id":         map[string]string{"type": "string", "description": "Entry ID (default * for an auto-generated one)"},
+				"maxlen":     map[string]string{"type": "integer", "description": "Trim the stream to about this many entries"},
+				"minid":      map[string]string{"type": "string", "description": "Trim entries with IDs below this one; exclusive with maxlen"},
+				"nomkstream": map[string]string{"type": "boolean", "description": "Do not create a missing stream"},
+				"encoding":   map[string]string{"type": "string", "description": "Value encoding: utf8 (default) or base64"},
+			},
+			"required": []string{"stream", "values"},
+		},
+	},
+	{
+		Name:        "kv_stream_read_group",
+		Path:        "/stream/read-group",
+		Description: "Read stream entries as a consumer of a group (XREADGROUP), optionally waiting for new ones; entries stay pending until acknowledged",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"stream": map[string]string{"type": "string", "description": "Stream key"},
+				"streams": map[string]interface{}{
+					"type":        "array",
+					"description": "Further stream keys to read",
+					"items":       map[string]string{"type": "string"},
+				},
+				"group":    map[string]string{"type": "string", "description": "Consumer group"},
+				"consumer": map[string]string{"type": "string", "description": "Consumer name"},
+				"start":    map[string]string{"type": "string", "description": "> (default) for new entries, 0 for the consumer's pending ones"},
+				"count":    map[string]string{"type": "integer", "description": "Maximum entries to return (default 100, max 1000)"},
+				"block_ms": map[string]string{"type": "integer", "description": "Wait up to this many milliseconds for entries (capped at 5000 unless configured otherwise); the wait holds up the caller's other requests on the connection"},
+				"noack":    map[string]string{"type": "boolean", "description": "Do not keep the entries pending"},
+				"encoding": map[string]string{"type": "string", "description": "Value encoding: utf8 (default) or base64"},
+			},
+			"required": []string{"group", "consumer"},
+		},
+	},
+	{
+		Name:        "kv_stream_ack",
+		Path:        "/stream/ack",
+		Description: "Acknowledge stream entries processed by a consumer group (XACK)",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"stream": map[string]string{"type": "string", "description": "Stream key"},
+				"group":  map[string]string{"type": "string", "description": "Consumer group"},
+				"ids":    map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}},
+			},
+			"required": []string{"stream", "group", "ids"},
+		},
+	},
+	{
+		Name:        "kv_stream_claim",
+		Path:        "/stream/claim",
+		Description: "Move pending stream entries idle for a while to another consumer (XCLAIM, or XAUTOCLAIM without ids)",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"stream":      map[string]string{"type": "string", "description": "Stream key"},
+				"group":       map[string]string{"type": "string", "description": "Consumer group"},
+				"consumer":    map[string]string{"type": "string", "description": "Consumer taking the entries"},
+				"min_idle_ms": map[string]string{"type": "integer", "description": "Only claim entries idle for at least this many milliseconds"},
+				"ids":         map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}},
+				"start":       map[string]string{"type": "string", "description": "Without ids, scan pending entries from this ID (default 0-0)"},
+				"count":       map[string]string{"type": "integer", "description": "Without ids, maximum entries to claim (default 100)"},
+				"encoding":    map[string]string{"type": "string", "description": "Value encoding: utf8 (default) or base64"},
+			},
+			"required": []string{"stream", "group", "consumer"},
+		},
+	},
+	{
+		Name:        "kv_stream_info",
+		Path:        "/stream/info",
+		Description: "Describe a stream and its consumer groups, or a group's consumers and pending entries",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"stream":   map[string]string{"type": "string", "description": "Stream key"},
+				"group":    map[string]string{"type": "string", "description": "Consumer group to describe"},
+				"encoding": map[string]string{"type": "string", "description": "Value encoding: utf8 (default) or base64"},
+			},
+			"required": []string{"stream"},
+		},
+	},
+	{
+		Name:        "kv_stream_group",
+		Path:        "/stream/group",
+		Description: "Create, destroy or reposition a stream consumer group, or delete one of its consumers",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"action":   map[string]string{"type": "string", "description": "create, destroy, setid or delconsumer"},
+				"stream":   map[string]string{"type": "string", "description": "Stream key, created by create if missing"},
+				"group":    map[string]string{"type": "string", "description": "Consumer group"},
+				"consumer": map[string]string{"type": "string", "description": "Consumer to delete"},
+				"start":    map[string]string{"type": "string", "description": "For create and setid, the last delivered ID (default $ for new entries only)"},
+			},
+			"required": []string{"action", "stream", "group"},
+		},
+	},
 }
 
 // DatastoreTools defines the MCP tools exposed by the Datastore proxy (native TCP).
 var DatastoreTools = []ToolDef{
 	{
 		Name:        "datastore_query",
+		Path:        "/query",
 		Description: "Execute a ClickHouse SQL query via native protocol and return results as JSON rows",
 		Schema: map[string]interface{}{
 			"type": "object",
@@ -132,6 +478,7 @@ var DatastoreTools = []ToolDef{
 	},
 	{
 		Name:        "datastore_exec",
+		Path:        "/exec",
 		Description: "Execute a DDL or non-SELECT ClickHouse statement (CREATE, ALTER, DROP, etc.)",
 		Schema: map[string]interface{}{
 			"type": "object",
@@ -144,6 +491,7 @@ var DatastoreTools = []ToolDef{
 	},
 	{
 		Name:        "datastore_insert",
+		Path:        "/insert",
 		Description: "Bulk insert rows into a ClickHouse table via native batch protocol",
 		Schema: map[string]interface{}{
 			"type": "object",
@@ -161,6 +509,7 @@ var DatastoreTools = []ToolDef{
 	},
 	{
 		Name:        "datastore_tables",
+		Path:        "/tables",
 		Description: "List tables and their metadata in a ClickHouse database",
 		Schema: map[string]interface{}{
 			"type": "object",
@@ -171,6 +520,7 @@ var DatastoreTools = []ToolDef{
 	},
 	{
 		Name:        "datastore_health",
+		Path:        "/health",
 		Description: "Check ClickHouse native TCP connection health and server version",
 		Schema: map[string]interface{}{
 			"type": "object", "properties": map[string]interface{}{},
@@ -183,7 +533,7 @@ var SQLResources = []ResourceDef{
 	{
 		URI:         "hanzo://sql/schema",
 		Name:        "Database Schema",
-		Description: "PostgreSQL database schema (tables, columns, indexes)",
+		Description: "PostgreSQL database schema (tables, columns, indexes, foreign keys)",
 		MimeType:    "application/json",
 	},
 }
@@ -193,8 +543,8 @@ var KVResources = []ResourceDef{
 	{
 		URI:         "hanzo://kv/info",
 		Name:        "KV Server Info",
-		Description: "Valkey/Redis server info and statistics",
-		MimeType:    "text/plain",
+		Description: "Valkey/Redis server info and statistics, grouped by INFO section",
+		MimeType:    "application/json",
 	},
 }
 
@@ -203,7 +553,7 @@ var DatastoreResources = []ResourceDef{
 	{
 		URI:         "hanzo://datastore/tables",
 		Name:        "Datastore Tables",
-		Description: "ClickHouse table definitions and schemas",
+		Description: "ClickHouse table definitions, columns and CREATE statements",
 		MimeType:    "application/json",
 	},
 }
@@ -212,6 +562,7 @@ var DatastoreResources = []ResourceDef{
 var DocumentDBTools = []ToolDef{
 	{
 		Name:        "documentdb_find",
+		Path:        "/find",
 		Description: "Find documents in a collection matching a filter",
 		Schema: map[string]interface{}{
 			"type": "object",
@@ -226,6 +577,7 @@ var DocumentDBTools = []ToolDef{
 	},
 	{
 		Name:        "documentdb_insert",
+		Path:        "/insert",
 		Description: "Insert documents into a collection",
 		Schema: map[string]interface{}{
 			"type": "object",
@@ -242,6 +594,7 @@ var DocumentDBTools = []ToolDef{
 	},
 	{
 		Name:        "documentdb_update",
+		Path:        "/update",
 		Description: "Update documents matching a filter",
 		Schema: map[string]interface{}{
 			"type": "object",
@@ -256,6 +609,7 @@ var DocumentDBTools = []ToolDef{
 	},
 	{
 		Name:        "documentdb_delete",
+		Path:        "/delete",
 		Description: "Delete documents matching a filter",
 		Schema: map[string]interface{}{
 			"type": "object",
@@ -269,6 +623,7 @@ var DocumentDBTools = []ToolDef{
 	},
 	{
 		Name:        "documentdb_health",
+		Path:        "/health",
 		Description: "Check DocumentDB/FerretDB connection health",
 		Schema: map[string]interface{}{
 			"type": "object", "properties": map[string]interface{}{},
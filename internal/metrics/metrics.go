@@ -0,0 +1,359 @@
+// Package metrics records sidecar request and backend pool metrics and
+// writes them in the Prometheus text exposition format.
+//
+// The registry is process-wide: families are declared as package variables,
+// proxies record into them, and the admin listener serves them on /metrics.
+// Backend pool statistics are sampled at scrape time by functions
+// registered with OnScrape.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPaths bounds the distinct path label values per mode so arbitrary
+// client paths cannot blow up series cardinality.
+const maxPaths = 64
+
+var (
+	Requests = NewCounter("zap_sidecar_requests_total",
+		"ZAP requests handled, by mode, path and response status.", "mode", "path", "status")
+	RequestErrors = NewCounter("zap_sidecar_request_errors_total",
+		"ZAP requests answered with an HTTP error status.", "mode", "path", "status")
+	RequestDuration = NewHistogram("zap_sidecar_request_duration_seconds",
+		"ZAP request latency in seconds.", DefaultBuckets, "mode", "path")
+	InFlight = NewGauge("zap_sidecar_requests_in_flight",
+		"ZAP requests currently being handled.", "mode")
+
+	PoolConns = NewGauge("zap_sidecar_pool_connections",
+		"Backend connection pool size by state.", "mode", "state")
+	PoolAcquires = NewCounter("zap_sidecar_pool_acquires_total",
+		"Backend connection acquisitions (acquired, canceled, waited).", "mode", "kind")
+	PoolAcquireSeconds = NewCounter("zap_sidecar_pool_acquire_seconds_total",
+		"Total time spent waiting to acquire backend connections.", "mode")
+	PoolEvents = NewCounter("zap_sidecar_pool_events_total",
+		"Backend connection pool events.", "mode", "event")
+
+	Subscriptions = NewGauge("zap_sidecar_subscriptions",
+		"Active push subscriptions.", "mode")
+	Pushes = NewCounter("zap_sidecar_pushes_total",
+		"Messages pushed to subscribers by result (sent, dropped, failed).", "mode", "result")
+
+	Reloads = NewCounter("zap_sidecar_config_reloads_total",
+		"Configuration reloads by backend (\"config\" when the file itself is rejected) and result (ok, error).", "mode", "result")
+)
+
+// DefaultBuckets are latency buckets in seconds from 0.5ms to 30s.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+var registry struct {
+	mu       sync.Mutex
+	families []family
+	scrapers []func()
+	paths    map[string]map[string]bool
+}
+
+type family interface {
+	write(w io.Writer)
+}
+
+// OnScrape registers fn to run before every scrape, typically to copy
+// driver pool statistics into gauges.
+func OnScrape(fn func()) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.scrapers = append(registry.scrapers, fn)
+}
+
+// Start marks a request on mode/path as in flight. The returned func
+// records its response status and latency.
+func Start(mode, path string) func(status int) {
+	path = pathLabel(mode, path)
+	start := time.Now()
+	InFlight.Add(1, mode)
+	return func(status int) {
+		InFlight.Add(-1, mode)
+		code := strconv.Itoa(status)
+		Requests.Inc(mode, path, code)
+		if status >= 400 {
+			RequestErrors.Inc(mode, path, code)
+		}
+		RequestDuration.Observe(time.Since(start).Seconds(), mode, path)
+	}
+}
+
+func pathLabel(mode, path string) string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if registry.paths == nil {
+		registry.paths = make(map[string]map[string]bool)
+	}
+	seen := registry.paths[mode]
+	if seen == nil {
+		seen = make(map[string]bool)
+		registry.paths[mode] = seen
+	}
+	if seen[path] {
+		return path
+	}
+	if len(seen) >= maxPaths {
+		return "other"
+	}
+	seen[path] = true
+	return path
+}
+
+// Write runs the scrape hooks and writes every family in text format.
+func Write(w io.Writer) {
+	registry.mu.Lock()
+	scrapers := append([]func(){}, registry.scrapers...)
+	families := append([]family{}, registry.families...)
+	registry.mu.Unlock()
+
+	for _, fn := range scrapers {
+		fn()
+	}
+	for _, f := range families {
+		f.write(w)
+	}
+}
+
+func register(f family) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.families = append(registry.families, f)
+}
+
+// ================================================================
+// Counter and Gauge
+// ================================================================
+
+type series struct {
+	labels []string
+	value  float64
+	source float64 // last value passed to Counter.Mirror
+}
+
+type scalar struct {
+	name, help, kind string
+	labelNames       []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newScalar(name, help, kind string, labelNames []string) *scalar {
+	return &scalar{name: name, help: help, kind: kind, labelNames: labelNames, series: make(map[string]*series)}
+}
+
+func (s *scalar) get(labels []string) *series {
+	key := strings.Join(labels, "\xff")
+	sr := s.series[key]
+	if sr == nil {
+		sr = &series{labels: append([]string(nil), labels...)}
+		s.series[key] = sr
+	}
+	return sr
+}
+
+func (s *scalar) add(v float64, labels []string) {
+	s.mu.Lock()
+	s.get(labels).value += v
+	s.mu.Unlock()
+}
+
+func (s *scalar) set(v float64, labels []string) {
+	s.mu.Lock()
+	s.get(labels).value = v
+	s.mu.Unlock()
+}
+
+// mirror adds the increase of a count kept elsewhere since the previous
+// call. A count lower than before means its source was replaced, and its
+// whole value is new.
+func (s *scalar) mirror(v float64, labels []string) {
+	s.mu.Lock()
+	sr := s.get(labels)
+	d := v - sr.source
+	if d < 0 {
+		d = v
+	}
+	sr.value += d
+	sr.source = v
+	s.mu.Unlock()
+}
+
+func (s *scalar) write(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.series) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+	for _, sr := range sortedSeries(s.series) {
+		fmt.Fprintf(w, "%s%s %s\n", s.name, formatLabels(s.labelNames, sr.labels, "", ""), formatValue(sr.value))
+	}
+}
+
+// Counter is a monotonically increasing metric family.
+type Counter struct{ s *scalar }
+
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{s: newScalar(name, help, "counter", labelNames)}
+	register(c.s)
+	return c
+}
+
+func (c *Counter) Inc(labels ...string)            { c.s.add(1, labels) }
+func (c *Counter) Add(v float64, labels ...string) { c.s.add(v, labels) }
+
+// Mirror tracks a cumulative count kept elsewhere, such as a driver's
+// pool statistics, adding its increase since the previous call. When a
+// reload replaces the pool its count restarts from zero, and the counter
+// keeps growing from its total instead of going down.
+func (c *Counter) Mirror(v float64, labels ...string) { c.s.mirror(v, labels) }
+
+// Gauge is a metric family whose values can go up and down.
+type Gauge struct{ s *scalar }
+
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{s: newScalar(name, help, "gauge", labelNames)}
+	register(g.s)
+	return g
+}
+
+func (g *Gauge) Set(v float64, labels ...string) { g.s.set(v, labels) }
+func (g *Gauge) Add(v float64, labels ...string) { g.s.add(v, labels) }
+
+// ================================================================
+// Histogram
+// ================================================================
+
+type histSeries struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Histogram is a metric family of bucketed observations.
+type Histogram struct {
+	name, help string
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histSeries
+}
+
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labelNames: labelNames, buckets: buckets, series: make(map[string]*histSeries)}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labels ...string) {
+	key := strings.Join(labels, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sr := h.series[key]
+	if sr == nil {
+		sr = &histSeries{labels: append([]string(nil), labels...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = sr
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			sr.counts[i]++
+		}
+	}
+	sr.sum += v
+	sr.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.series) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, k := range keys {
+		sr := h.series[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labelNames, sr.labels, "le", formatValue(b)), sr.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labelNames, sr.labels, "le", "+Inf"), sr.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labelNames, sr.labels, "", ""), formatValue(sr.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labelNames, sr.labels, "", ""), sr.count)
+	}
+}
+
+// ================================================================
+// Text format helpers
+// ================================================================
+
+func sortedSeries(m map[string]*series) []*series {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*series, len(keys))
+	for i, k := range keys {
+		out[i] = m[k]
+	}
+	return out
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		fmt.Fprintf(&b, `%s="%s"`, n, labelEscaper.Replace(v))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	done := Start("test_start", "/query")
+	if got := InFlight.s.get([]string{"test_start"}).value; got != 1 {
+		t.Errorf("in flight = %v, want 1", got)
+	}
+	done(500)
+	Start("test_start", "/query")(200)
+
+	tests := []struct {
+		c      *Counter
+		labels []string
+		want   float64
+	}{
+		{Requests, []string{"test_start", "/query", "500"}, 1},
+		{Requests, []string{"test_start", "/query", "200"}, 1},
+		{RequestErrors, []string{"test_start", "/query", "500"}, 1},
+		{RequestErrors, []string{"test_start", "/query", "200"}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.c.s.get(tt.labels).value; got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.c.s.name, tt.labels, got, tt.want)
+		}
+	}
+	if got := InFlight.s.get([]string{"test_start"}).value; got != 0 {
+		t.Errorf("in flight after both finished = %v, want 0", got)
+	}
+}
+
+func TestCounterMirror(t *testing.T) {
+	c := NewCounter("test_mirror_total", "Mirrored count.", "mode")
+	tests := []struct {
+		source float64
+		want   float64
+	}{
+		{5, 5},
+		{8, 8},
+		{8, 8},
+		{2, 10}, // the source was replaced and restarted
+		{6, 14},
+	}
+	for _, tt := range tests {
+		c.Mirror(tt.source, "sql")
+		if got := c.s.get([]string{"sql"}).value; got != tt.want {
+			t.Errorf("Mirror(%v) = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestScalarWrite(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests.", "mode", "path")
+	c.Inc("sql", "/query")
+	c.Add(2, "sql", "/query")
+	c.Inc("kv", `/a"b\c`)
+	g := NewGauge("test_in_flight", "In flight.")
+	g.Set(3)
+	g.Add(-1)
+
+	var b strings.Builder
+	c.s.write(&b)
+	g.s.write(&b)
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{mode="kv",path="/a\"b\\c"} 1
+test_requests_total{mode="sql",path="/query"} 3
+# HELP test_in_flight In flight.
+# TYPE test_in_flight gauge
+test_in_flight 2
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestScalarWriteEmpty(t *testing.T) {
+	var b strings.Builder
+	NewCounter("test_unused_total", "Unused.").s.write(&b)
+	if b.Len() != 0 {
+		t.Errorf("family without series wrote %q", b.String())
+	}
+}
+
+func TestHistogramWrite(t *testing.T) {
+	h := NewHistogram("test_seconds", "Latency.", []float64{.1, 1}, "mode")
+	h.Observe(.05, "sql")
+	h.Observe(.5, "sql")
+	h.Observe(2, "sql")
+
+	var b strings.Builder
+	h.write(&b)
+	want := `# HELP test_seconds Latency.
+# TYPE test_seconds histogram
+test_seconds_bucket{mode="sql",le="0.1"} 1
+test_seconds_bucket{mode="sql",le="1"} 2
+test_seconds_bucket{mode="sql",le="+Inf"} 3
+test_seconds_sum{mode="sql"} 2.55
+test_seconds_count{mode="sql"} 3
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestPathLabel(t *testing.T) {
+	for i := 0; i < maxPaths; i++ {
+		pathLabel("test", "/p"+strings.Repeat("x", i))
+	}
+	tests := []struct {
+		path, want string
+	}{
+		{"/p", "/p"},
+		{"/new", "other"},
+	}
+	for _, tt := range tests {
+		if got := pathLabel("test", tt.path); got != tt.want {
+			t.Errorf("pathLabel(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if got := pathLabel("test2", "/new"); got != "/new" {
+		t.Errorf("pathLabel in another mode = %q, want %q", got, "/new")
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{1.5, "1.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatValue(tt.v); got != tt.want {
+			t.Errorf("formatValue(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
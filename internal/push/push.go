@@ -0,0 +1,279 @@
+// Package push delivers events to the ZAP peers subscribed to a backend's
+// notifications: SQL LISTEN channels and replication slots, and KV
+// Pub/Sub. An event is a JSON object, pushed as a message with the
+// response layout: a 200 status and the event as its body. ZAP carries a
+// message's type in the high byte of its flags, so push types fit in
+// 0..255; subscribers receive them with node.Handle.
+package push
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal/metrics"
+)
+
+const (
+	queueSize       = 1024            // undelivered events per subscription
+	maxSubsPerPeer  = 64              // subscriptions one peer may hold
+	pushTimeout     = 5 * time.Second // per push
+	subscriberGrace = time.Minute     // a subscriber failing this long is dropped
+)
+
+// The proxies' response layout.
+const (
+	fieldStatus  = 0
+	fieldBody    = 4
+	fieldHeaders = 8
+)
+
+// Subscription is the delivery state of one subscriber. Backends embed it
+// in their subscription types, which a Hub holds as Subscribers.
+type Subscription struct {
+	ID string `json:"subscription"`
+
+	hub   *Hub
+	peer  string
+	owner string
+	queue chan map[string]interface{} // nil when pushed to directly
+	// dropped counts events discarded because the queue was full; the
+	// next push reports and resets it.
+	dropped      atomic.Int64
+	failingSince atomic.Int64 // unix nanos of the first failed push; 0 when healthy
+	stop         chan struct{}
+}
+
+// Subscriber is a backend's subscription, embedding a Subscription.
+type Subscriber interface {
+	subscription() *Subscription
+}
+
+func (s *Subscription) subscription() *Subscription { return s }
+
+// Done is closed when the subscription is removed.
+func (s *Subscription) Done() <-chan struct{} { return s.stop }
+
+// Config configures a Hub.
+type Config struct {
+	Mode    string // metrics label and log prefix
+	MsgType uint16 // type of the pushed messages
+	Logger  *slog.Logger
+	// Send delivers a message to a peer; normally the proxy node's Send.
+	Send func(ctx context.Context, peer string, msg *zap.Message) error
+	// Detach, when set, runs with the hub locked as a subscription is
+	// removed, releasing what the backend holds for it.
+	Detach func(s Subscriber)
+}
+
+// Hub owns the subscriptions of one backend and delivers their events.
+type Hub struct {
+	cfg    Config
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	subs map[string]Subscriber
+}
+
+func NewHub(cfg Config) *Hub {
+	h := &Hub{cfg: cfg, subs: make(map[string]Subscriber)}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	return h
+}
+
+// Context is canceled when the hub closes.
+func (h *Hub) Context() context.Context { return h.ctx }
+
+// Go runs f, a goroutine of the backend that ends with the hub's
+// context; Close waits for it.
+func (h *Hub) Go(f func()) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		f()
+	}()
+}
+
+// Add registers sub for peer and owner. A queued subscription receives
+// what Enqueue and Broadcast queue for it; others are sent events with
+// Push. attach, when set, runs with the hub locked once the peer's limit
+// is checked, and its error refuses the subscription.
+func (h *Hub) Add(sub Subscriber, peer, owner string, queued bool, attach func() error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, other := range h.subs {
+		if other.subscription().peer == peer {
+			n++
+		}
+	}
+	if n >= maxSubsPerPeer {
+		return errors.New("too many subscriptions")
+	}
+	if attach != nil {
+		if err := attach(); err != nil {
+			return err
+		}
+	}
+
+	s := sub.subscription()
+	var b [16]byte
+	rand.Read(b[:])
+	s.ID = hex.EncodeToString(b[:])
+	s.hub, s.peer, s.owner = h, peer, owner
+	s.stop = make(chan struct{})
+	h.subs[s.ID] = sub
+	metrics.Subscriptions.Add(1, h.cfg.Mode)
+	if queued {
+		s.queue = make(chan map[string]interface{}, queueSize)
+		h.Go(func() { h.deliver(s) })
+	}
+	return nil
+}
+
+// Remove stops the subscription id if owner holds it.
+func (h *Hub) Remove(id, owner string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub, ok := h.subs[id]
+	if !ok || sub.subscription().owner != owner {
+		return false
+	}
+	delete(h.subs, id)
+	close(sub.subscription().stop)
+	metrics.Subscriptions.Add(-1, h.cfg.Mode)
+	if h.cfg.Detach != nil {
+		h.cfg.Detach(sub)
+	}
+	return true
+}
+
+// List returns owner's subscriptions ordered by ID.
+func (h *Hub) List(owner string) []Subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []Subscriber
+	for _, sub := range h.subs {
+		if sub.subscription().owner == owner {
+			out = append(out, sub)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].subscription().ID < out[j].subscription().ID })
+	return out
+}
+
+// Each calls f for every subscription with the hub locked.
+func (h *Hub) Each(f func(Subscriber)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, sub := range h.subs {
+		f(sub)
+	}
+}
+
+// Broadcast queues event for every queued subscription.
+func (h *Hub) Broadcast(event map[string]interface{}) {
+	h.Each(func(sub Subscriber) {
+		if s := sub.subscription(); s.queue != nil {
+			s.Enqueue(maps.Clone(event))
+		}
+	})
+}
+
+// Close stops every subscription and waits for the hub's goroutines.
+func (h *Hub) Close() {
+	h.cancel()
+	h.wg.Wait()
+}
+
+// Enqueue queues event without blocking the caller; a full queue drops
+// it and counts the drop.
+func (s *Subscription) Enqueue(event map[string]interface{}) {
+	select {
+	case s.queue <- event:
+	default:
+		s.dropped.Add(1)
+		metrics.Pushes.Inc(s.hub.cfg.Mode, "dropped")
+	}
+}
+
+// deliver pushes s's queued events.
+func (h *Hub) deliver(s *Subscription) {
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case event := <-s.queue:
+			if n := s.dropped.Swap(0); n > 0 {
+				event["dropped"] = n
+			}
+			s.Push(event)
+		}
+	}
+}
+
+// Push sends one event to s's peer. A subscriber that keeps failing for
+// subscriberGrace is unsubscribed.
+func (s *Subscription) Push(event map[string]interface{}) error {
+	h := s.hub
+	event["subscription"] = s.ID
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(h.ctx, pushTimeout)
+	defer cancel()
+	if err = h.cfg.Send(ctx, s.peer, h.message(body)); err == nil {
+		s.failingSince.Store(0)
+		metrics.Pushes.Inc(h.cfg.Mode, "sent")
+		return nil
+	}
+	metrics.Pushes.Inc(h.cfg.Mode, "failed")
+	now := time.Now().UnixNano()
+	if !s.failingSince.CompareAndSwap(0, now) && now-s.failingSince.Load() > int64(subscriberGrace) {
+		h.cfg.Logger.Warn(h.cfg.Mode+": dropping unreachable subscriber", "subscription", s.ID, "peer", s.peer, "error", err)
+		h.Remove(s.ID, s.owner)
+	}
+	return err
+}
+
+// message builds a push message carrying body.
+func (h *Hub) message(body []byte) *zap.Message {
+	b := zap.NewBuilder(len(body) + 256)
+	ob := b.StartObject(12)
+	ob.SetUint32(fieldStatus, http.StatusOK)
+	ob.SetBytes(fieldBody, body)
+	ob.SetBytes(fieldHeaders, []byte(`{"Content-Type":["application/json"]}`))
+	ob.FinishAsRoot()
+	msg, _ := zap.Parse(b.FinishWithFlags(h.cfg.MsgType << 8))
+	return msg
+}
+
+type peerKey struct{}
+
+// WithPeer returns ctx carrying the ZAP peer a request came from, which
+// subscriptions are pushed to.
+func WithPeer(ctx context.Context, peer string) context.Context {
+	return context.WithValue(ctx, peerKey{}, peer)
+}
+
+// PeerFrom returns the peer in ctx, or "" when there is none.
+func PeerFrom(ctx context.Context) string {
+	peer, _ := ctx.Value(peerKey{}).(string)
+	return peer
+}
@@ -0,0 +1,127 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/luxfi/zap"
+)
+
+type sub struct {
+	Subscription
+}
+
+func TestEnqueueDropsWhenFull(t *testing.T) {
+	s := &Subscription{hub: NewHub(Config{Mode: "test"}), queue: make(chan map[string]interface{}, 1)}
+	s.Enqueue(map[string]interface{}{"n": 1})
+	s.Enqueue(map[string]interface{}{"n": 2})
+	s.Enqueue(map[string]interface{}{"n": 3})
+	if got := s.dropped.Load(); got != 2 {
+		t.Errorf("dropped = %d, want 2", got)
+	}
+	if got := <-s.queue; got["n"] != 1 {
+		t.Errorf("queued %v, want the first event", got)
+	}
+}
+
+func TestHub(t *testing.T) {
+	type push struct {
+		peer  string
+		flags uint16
+		event map[string]interface{}
+	}
+	sent := make(chan push, 8)
+	var detached []Subscriber
+	h := NewHub(Config{
+		Mode:    "test",
+		MsgType: 200,
+		Send: func(ctx context.Context, peer string, msg *zap.Message) error {
+			var event map[string]interface{}
+			if err := json.NewDecoder(bytes.NewReader(msg.Root().Bytes(fieldBody))).Decode(&event); err != nil {
+				return err
+			}
+			sent <- push{peer, msg.Flags(), event}
+			return nil
+		},
+		Detach: func(s Subscriber) { detached = append(detached, s) },
+	})
+	defer h.Close()
+
+	a, b, direct := &sub{}, &sub{}, &sub{}
+	for _, s := range []*sub{a, b} {
+		if err := h.Add(s, "peer", "alice", true, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Add(direct, "other", "bob", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	refused := errors.New("refused")
+	if err := h.Add(&sub{}, "peer", "alice", true, func() error { return refused }); err != refused {
+		t.Errorf("Add with a failing attach = %v, want %v", err, refused)
+	}
+
+	list := h.List("alice")
+	if len(list) != 2 || list[0].subscription().ID > list[1].subscription().ID {
+		t.Errorf("List(alice) = %v, want a and b ordered by ID", list)
+	}
+
+	// Broadcast skips the subscription pushed to directly.
+	h.Broadcast(map[string]interface{}{"event": "reconnected"})
+	got := make(map[string]bool)
+	for range 2 {
+		select {
+		case p := <-sent:
+			if p.peer != "peer" || p.flags != 200<<8 || p.event["event"] != "reconnected" {
+				t.Errorf("pushed %+v", p)
+			}
+			got[p.event["subscription"].(string)] = true
+		case <-time.After(time.Second):
+			t.Fatal("broadcast not delivered")
+		}
+	}
+	if !got[a.ID] || !got[b.ID] {
+		t.Errorf("broadcast reached %v, want %s and %s", got, a.ID, b.ID)
+	}
+	if err := direct.Push(map[string]interface{}{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-sent; p.peer != "other" || p.event["subscription"] != direct.ID {
+		t.Errorf("pushed %+v, want it to reach %s on other", p, direct.ID)
+	}
+
+	if h.Remove(a.ID, "bob") {
+		t.Error("Remove by another owner succeeded")
+	}
+	if !h.Remove(a.ID, "alice") || h.Remove(a.ID, "alice") {
+		t.Error("Remove(a) should succeed once")
+	}
+	if len(detached) != 1 || detached[0] != a {
+		t.Errorf("detached %v, want a", detached)
+	}
+	select {
+	case <-a.Done():
+	default:
+		t.Error("a not stopped")
+	}
+}
+
+func TestAddLimitsPeer(t *testing.T) {
+	h := NewHub(Config{Mode: "test"})
+	defer h.Close()
+	for range maxSubsPerPeer {
+		if err := h.Add(&sub{}, "peer", "", false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Add(&sub{}, "peer", "", false, nil); err == nil {
+		t.Error("Add beyond the peer's limit succeeded")
+	}
+	if err := h.Add(&sub{}, "other", "", false, nil); err != nil {
+		t.Errorf("Add for another peer: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// maxBatchItems bounds the statements in one /batch request.
+const maxBatchItems = 1000
+
+type batchReq struct {
+	Items []sqlReq `json:"items"`
+	// Transaction wraps the batch in one explicit transaction with the
+	// given isolation level. Without it Postgres still runs the pipeline
+	// as a single implicit transaction, so a failure rolls back the
+	// statements before it. A request with a session always gets one.
+	Transaction bool   `json:"transaction,omitempty"`
+	Isolation   string `json:"isolation,omitempty"`
+	// Tx runs the batch inside a transaction opened with /tx/begin.
+	Tx string `json:"tx,omitempty"`
+	// Format lays out every item's rows, as in /query.
+	Format string `json:"format,omitempty"`
+}
+
+// batchResult is one statement's result within a batch.
+type batchResult struct {
+	rs  *resultSet
+	tag pgconn.CommandTag
+}
+
+// renderBatch lays out results like /query results plus the command tag.
+func renderBatch(results []batchResult, format string) []map[string]interface{} {
+	out := make([]map[string]interface{}, len(results))
+	for i, r := range results {
+		out[i] = r.rs.render(format)
+		out[i]["rows_affected"] = r.tag.RowsAffected()
+		out[i]["command"] = r.tag.String()
+	}
+	return out
+}
+
+// batcher is a querier that can also pipeline a pgx.Batch.
+type batcher interface {
+	querier
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// batch runs several statements in one round trip with pgx.Batch and
+// returns one result per statement, or the index of the first failure.
+func (p *Proxy) batch(ctx context.Context, body []byte) *zap.Message {
+	var req batchReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(req.Items) == 0 || len(req.Items) > maxBatchItems {
+		return respond(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("batch needs 1 to %d items", maxBatchItems)})
+	}
+	if !validFormat(req.Format) {
+		return respond(http.StatusBadRequest, map[string]string{"error": "unknown format: " + req.Format})
+	}
+
+	var q batcher
+	var tx pgx.Tx
+	var t *txn
+	switch {
+	case req.Tx != "":
+		var err error
+		if t, err = p.txs.acquire(req.Tx, auth.FromContext(ctx).Caller); err != nil {
+			return respond(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		defer p.txs.release(t)
+		ctx = context.WithValue(ctx, sessionKey{}, t.session)
+		q = t.tx
+	case req.Transaction || p.readOnly.Load() || sessionFrom(ctx) != nil:
+		iso, ok := isolationLevels[strings.ReplaceAll(strings.ToLower(req.Isolation), "_", " ")]
+		if !ok {
+			return respond(http.StatusBadRequest, map[string]string{"error": "unknown isolation level: " + req.Isolation})
+		}
+		opts := pgx.TxOptions{IsoLevel: iso}
+		if p.readOnly.Load() {
+			opts.AccessMode = pgx.ReadOnly
+		}
+		var err error
+		if tx, err = p.beginTx(ctx, opts); err != nil {
+			return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		defer tx.Rollback(ctx)
+		q = tx
+	default:
+		conn, release, err := p.acquireConn(ctx)
+		if err != nil {
+			return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		defer release()
+		q = conn
+	}
+
+	if schemas := auth.FromContext(ctx).Schemas; len(schemas) > 0 {
+		for i, item := range req.Items {
+			if err := checkSchemas(ctx, q, item.SQL, item.Args, schemas); err != nil {
+				return respond(http.StatusForbidden, map[string]interface{}{"error": err.Error(), "index": i})
+			}
+		}
+	}
+	for i, item := range req.Items {
+		if err := checkSessionStatement(ctx, q, item.SQL, item.Args); err != nil {
+			return respond(http.StatusForbidden, map[string]interface{}{"error": err.Error(), "index": i})
+		}
+	}
+
+	b := &pgx.Batch{}
+	for _, item := range req.Items {
+		b.Queue(item.SQL, item.Args...)
+	}
+	results, failed, err := runBatch(ctx, q, b, len(req.Items), p.maxResult.Load(), req.Format)
+	if err != nil {
+		out := map[string]interface{}{"error": err.Error(), "results": renderBatch(results, req.Format)}
+		if failed >= 0 {
+			out["index"] = failed
+		}
+		return respond(queryStatus(err), out)
+	}
+	sets := make([]*resultSet, len(results))
+	for i, r := range results {
+		sets[i] = r.rs
+	}
+	if err := p.resolveTypes(ctx, q, sets...); err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if err := checkSession(ctx, q); err != nil {
+		if t != nil {
+			// Nothing done under another role or settings may be committed.
+			p.txs.finish(req.Tx, t)
+			t.tx.Rollback(ctx)
+		}
+		return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if tx != nil {
+		if err := tx.Commit(ctx); err != nil {
+			return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+	return respond(http.StatusOK, map[string]interface{}{"results": renderBatch(results, req.Format), "count": len(results)})
+}
+
+// runBatch sends b and reads its n results, at most maxBytes in all, each
+// checked against format. On failure it returns the results so far and
+// the index of the failing statement, or -1 when the batch failed as a
+// whole.
+func runBatch(ctx context.Context, q batcher, b *pgx.Batch, n int, maxBytes int64, format string) ([]batchResult, int, error) {
+	br := q.SendBatch(ctx, b)
+	defer br.Close()
+
+	results := make([]batchResult, 0, n)
+	for i := 0; i < n; i++ {
+		rows, err := br.Query()
+		if err != nil {
+			return results, i, err
+		}
+		rs, err := readRows(rows, limits{bytes: max(maxBytes, 1)})
+		if err == nil {
+			err = rs.checkFormat(format)
+		}
+		if err != nil {
+			return results, i, err
+		}
+		maxBytes -= rs.Size
+		results = append(results, batchResult{rs: rs, tag: rows.CommandTag()})
+	}
+	if err := br.Close(); err != nil {
+		return results, -1, err
+	}
+	return results, -1, nil
+}
@@ -0,0 +1,255 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal"
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// defaultMaxQueryTimeout caps timeout_ms when Config.MaxQueryTimeout is 0.
+const defaultMaxQueryTimeout = 5 * time.Minute
+
+// requestOpts are the fields any JSON request body may carry to bound
+// and identify the request and to set its session.
+type requestOpts struct {
+	TimeoutMS int64                      `json:"timeout_ms,omitempty"`
+	RequestID string                     `json:"request_id,omitempty"`
+	Settings  map[string]json.RawMessage `json:"settings,omitempty"`
+}
+
+// inflight is a running request that /cancel can stop.
+type inflight struct {
+	owner  string
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	pid uint32 // backend running the request's current statement; 0 between statements
+}
+
+type inflightKey struct{}
+
+// requestContext derives the context a request runs under: its
+// timeout_ms, capped by MaxQueryTimeout, or else the configured
+// QueryTimeout, and its session. A request with a request_id is
+// registered for /cancel until release is called.
+func (p *Proxy) requestContext(ctx context.Context, path string, body []byte) (context.Context, func(), *zap.Message) {
+	// A tool call carries the options in its arguments.
+	if path == "/tools/call" {
+		var call internal.ToolCall
+		if json.Unmarshal(body, &call) == nil {
+			body = call.Arguments
+		}
+	}
+	// Syntax errors are left to the handler; a mistyped option is not.
+	var opts requestOpts
+	if len(body) > 0 && body[0] == '{' {
+		var typeErr *json.UnmarshalTypeError
+		if err := json.Unmarshal(body, &opts); errors.As(err, &typeErr) {
+			return nil, nil, respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+	if opts.TimeoutMS < 0 {
+		return nil, nil, respond(http.StatusBadRequest, map[string]string{"error": "timeout_ms must not be negative"})
+	}
+	s, msg := newSession(auth.FromContext(ctx), opts.Settings)
+	if msg != nil {
+		return nil, nil, msg
+	}
+	if s != nil {
+		ctx = context.WithValue(ctx, sessionKey{}, s)
+	}
+
+	timeout := time.Duration(p.queryTimeout.Load())
+	if opts.TimeoutMS > 0 {
+		maxMS := time.Duration(p.maxQueryTimeout.Load()).Milliseconds()
+		timeout = time.Duration(min(opts.TimeoutMS, maxMS)) * time.Millisecond
+	}
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	if opts.RequestID == "" || path == "/cancel" {
+		return ctx, cancel, nil
+	}
+
+	r := &inflight{owner: auth.FromContext(ctx).Caller, cancel: cancel}
+	if _, dup := p.running.LoadOrStore(opts.RequestID, r); dup {
+		cancel()
+		return nil, nil, respond(http.StatusConflict, map[string]string{"error": "request_id in use: " + opts.RequestID})
+	}
+	release := func() {
+		p.running.Delete(opts.RequestID)
+		cancel()
+	}
+	return context.WithValue(ctx, inflightKey{}, r), release, nil
+}
+
+func (p *Proxy) setMaxQueryTimeout(d time.Duration) {
+	if d <= 0 {
+		d = defaultMaxQueryTimeout
+	}
+	p.maxQueryTimeout.Store(int64(d))
+}
+
+type cancelReq struct {
+	RequestID string `json:"request_id"`
+}
+
+// cancelRequest stops a running request of the caller: the statement it
+// is running is canceled with pg_cancel_backend and its context is
+// canceled, which ends the request with an error.
+//
+// ZAP reads a connection's requests one at a time, so a /cancel sent on
+// the connection running the request is only read once it has finished.
+// Callers send it on a second connection, under a node ID of its own,
+// authenticated as the same caller: requests are matched by caller, not
+// by connection.
+func (p *Proxy) cancelRequest(ctx context.Context, body []byte) *zap.Message {
+	var req cancelReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	v, ok := p.running.Load(req.RequestID)
+	if !ok || v.(*inflight).owner != auth.FromContext(ctx).Caller {
+		return respond(http.StatusNotFound, map[string]string{"error": "no running request: " + req.RequestID})
+	}
+	r := v.(*inflight)
+
+	// The statement's connection is not returned to the pool while the
+	// lock is held (traceEnd waits for it), so the PID cannot belong to
+	// another request yet.
+	r.mu.Lock()
+	canceled := false
+	var err error
+	if r.pid != 0 {
+		err = p.pool.Load().QueryRow(ctx, "SELECT pg_cancel_backend($1)", int32(r.pid)).Scan(&canceled)
+	}
+	r.mu.Unlock()
+	r.cancel()
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, map[string]interface{}{"status": "canceled", "backend_canceled": canceled})
+}
+
+// setStatementTimeout bounds the statements of the transaction q on the
+// server by ctx's deadline, so a runaway statement stops even if the
+// cancellation from the client side is lost.
+func setStatementTimeout(ctx context.Context, q querier) error {
+	dl, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	ms := max(time.Until(dl).Milliseconds(), 1)
+	_, err := q.Exec(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", ms))
+	return err
+}
+
+// acquireConn acquires a pool connection for statements run outside a
+// transaction, bounding them on the server by ctx's deadline like
+// setStatementTimeout. The setting outlives a statement, so release
+// resets it before returning the connection, and closes the connection
+// if that fails.
+func (p *Proxy) acquireConn(ctx context.Context) (*pgxpool.Conn, func(), error) {
+	conn, err := p.pool.Load().Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	dl, ok := ctx.Deadline()
+	if !ok {
+		return conn, conn.Release, nil
+	}
+	ms := max(time.Until(dl).Milliseconds(), 1)
+	if _, err := conn.Exec(ctx, fmt.Sprintf("SET statement_timeout = %d", ms)); err != nil {
+		conn.Release()
+		return nil, nil, err
+	}
+	release := func() {
+		rctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := conn.Exec(rctx, "RESET statement_timeout"); err != nil {
+			conn.Conn().Close(rctx) // the pool drops closed connections
+		}
+		conn.Release()
+	}
+	return conn, release, nil
+}
+
+// beginTx begins a transaction on the pool whose statements are bounded
+// by ctx's deadline and run with the request's session.
+func (p *Proxy) beginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	tx, err := p.pool.Load().BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = setStatementTimeout(ctx, tx)
+	if err == nil {
+		err = applySession(ctx, tx)
+	}
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	return tx, nil
+}
+
+// cancelOnDone makes a canceled context send a cancel request for the
+// running statement, instead of only dropping the connection.
+func cancelOnDone(c *pgconn.PgConn) ctxwatch.Handler {
+	return &pgconn.CancelRequestContextWatcherHandler{Conn: c, DeadlineDelay: 5 * time.Second}
+}
+
+// tracer records which backend runs each statement of a request with a
+// request_id, for /cancel.
+type tracer struct{}
+
+func (tracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	traceStart(ctx, conn)
+	return ctx
+}
+
+func (tracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryEndData) {
+	traceEnd(ctx)
+}
+
+func (tracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, _ pgx.TraceBatchStartData) context.Context {
+	traceStart(ctx, conn)
+	return ctx
+}
+
+func (tracer) TraceBatchQuery(context.Context, *pgx.Conn, pgx.TraceBatchQueryData) {}
+
+func (tracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceBatchEndData) {
+	traceEnd(ctx)
+}
+
+func traceStart(ctx context.Context, conn *pgx.Conn) {
+	if r, ok := ctx.Value(inflightKey{}).(*inflight); ok {
+		r.mu.Lock()
+		r.pid = conn.PgConn().PID()
+		r.mu.Unlock()
+	}
+}
+
+func traceEnd(ctx context.Context) {
+	if r, ok := ctx.Value(inflightKey{}).(*inflight); ok {
+		r.mu.Lock()
+		r.pid = 0
+		r.mu.Unlock()
+	}
+}
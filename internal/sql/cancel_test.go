@@ -0,0 +1,52 @@
+package sql
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRequestContext(t *testing.T) {
+	p := &Proxy{}
+	p.queryTimeout.Store(int64(30 * time.Second))
+	p.setMaxQueryTimeout(time.Minute)
+	tests := []struct {
+		body string
+		want time.Duration // 0 for a refused request
+	}{
+		{`SELECT 1`, 30 * time.Second},
+		{`{"sql": "SELECT 1"}`, 30 * time.Second},
+		{`{"sql": "SELECT 1", "timeout_ms": 1500}`, 1500 * time.Millisecond},
+		{`{"sql": "SELECT 1", "timeout_ms": 3600000}`, time.Minute},
+		{`{"sql": "SELECT 1", "timeout_ms": ` + strconv.FormatInt(math.MaxInt64, 10) + `}`, time.Minute},
+		{`{"sql": "SELECT 1", "timeout_ms": -1}`, 0},
+		{`{"sql": "SELECT 1", "timeout_ms": "5000"}`, 0},
+		{`{"sql": "SELECT 1", "request_id": 7}`, 0},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		ctx, release, resp := p.requestContext(context.Background(), "/query", []byte(tt.body))
+		if tt.want == 0 {
+			if resp == nil {
+				release()
+				t.Errorf("%s: accepted", tt.body)
+			} else if status, _ := unpack(resp); status != http.StatusBadRequest {
+				t.Errorf("%s: status %d, want %d", tt.body, status, http.StatusBadRequest)
+			}
+			continue
+		}
+		if resp != nil {
+			status, body := unpack(resp)
+			t.Errorf("%s: status %d: %s", tt.body, status, body)
+			continue
+		}
+		deadline, ok := ctx.Deadline()
+		release()
+		if got := deadline.Sub(start); !ok || got < tt.want || got > tt.want+time.Second {
+			t.Errorf("%s: timeout %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package sql
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// ================================================================
+// /copy_in — bulk load with COPY FROM
+// ================================================================
+
+type copyInReq struct {
+	Table   string   `json:"table"`
+	Columns []string `json:"columns,omitempty"`
+	// Rows are arrays in column order or objects keyed by column; without
+	// Columns, the keys of the first object are used.
+	Rows []interface{} `json:"rows,omitempty"`
+	// CSV is an alternative payload, loaded as is.
+	CSV    string `json:"csv,omitempty"`
+	Header bool   `json:"header,omitempty"` // CSV starts with a header line
+}
+
+// copyIn loads rows into a table with one COPY. Postgres parses every
+// value with the column's input function, so values take their usual
+// text or JSON form. COPY is all or nothing: on failure nothing is loaded
+// and the response names the failing row when Postgres reports it.
+func (p *Proxy) copyIn(ctx context.Context, body []byte) *zap.Message {
+	var req copyInReq
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber() // keep numbers exact
+	if err := dec.Decode(&req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Table == "" || (len(req.Rows) == 0) == (req.CSV == "") {
+		return respond(http.StatusBadRequest, map[string]string{"error": "table and one of rows or csv required"})
+	}
+
+	var data []byte
+	opts := ""
+	skip := 0
+	if req.CSV != "" {
+		data = []byte(req.CSV)
+		opts = " WITH (FORMAT csv)"
+		if req.Header {
+			opts, skip = " WITH (FORMAT csv, HEADER true)", 1
+		}
+	} else {
+		rows, err := rowValues(&req.Columns, req.Rows)
+		if err != nil {
+			return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if data, err = copyText(req.Columns, rows); err != nil {
+			return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	table, cols := qualified(req.Table), "*"
+	target := table
+	if len(req.Columns) > 0 {
+		cols = identList(req.Columns)
+		target += " (" + cols + ")"
+	}
+
+	tx, err := p.beginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	defer tx.Rollback(ctx)
+	if schemas := auth.FromContext(ctx).Schemas; len(schemas) > 0 {
+		if err := checkTable(ctx, tx, table, cols, schemas); err != nil {
+			return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+
+	tag, err := tx.Conn().PgConn().CopyFrom(ctx, bytes.NewReader(data), "COPY "+target+" FROM STDIN"+opts)
+	if err != nil {
+		return copyError(err, skip)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, map[string]interface{}{
+		"rows_affected": tag.RowsAffected(),
+		"command":       tag.String(),
+	})
+}
+
+// rowValues turns rows given as arrays or objects into arrays in the
+// order of columns, filling columns from the first object if empty.
+func rowValues(columns *[]string, rows []interface{}) ([][]interface{}, error) {
+	if obj, ok := rows[0].(map[string]interface{}); ok && len(*columns) == 0 {
+		for k := range obj {
+			*columns = append(*columns, k)
+		}
+		sort.Strings(*columns)
+	}
+	if len(*columns) == 0 {
+		return nil, errors.New("columns required for array rows")
+	}
+	out := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		switch row := row.(type) {
+		case []interface{}:
+			out[i] = row
+		case map[string]interface{}:
+			vals := make([]interface{}, len(*columns))
+			for j, c := range *columns {
+				vals[j] = row[c]
+			}
+			out[i] = vals
+		default:
+			return nil, fmt.Errorf("row %d: not an array or object", i)
+		}
+	}
+	return out, nil
+}
+
+// copyLine finds the line number in the context of a COPY error.
+var copyLine = regexp.MustCompile(`^COPY .*?, line (\d+)`)
+
+// copyError responds to a failed COPY, adding the index of the failing
+// row when Postgres reports its line. skip counts the lines before the
+// first row.
+func copyError(err error, skip int) *zap.Message {
+	out := map[string]interface{}{"error": err.Error()}
+	if row, ok := copyRow(err, skip); ok {
+		out["row"] = row
+	}
+	return respond(http.StatusInternalServerError, out)
+}
+
+// copyRow returns the index of the row a COPY error reports.
+func copyRow(err error, skip int) (int, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return 0, false
+	}
+	m := copyLine.FindStringSubmatch(pgErr.Where)
+	if m == nil {
+		return 0, false
+	}
+	line, _ := strconv.Atoi(m[1])
+	return line - 1 - skip, true
+}
+
+// checkTable confines a COPY to the caller's schemas. EXPLAIN cannot plan
+// COPY; a SELECT of the same columns resolves the same table.
+func checkTable(ctx context.Context, q querier, table, cols string, schemas []string) error {
+	return checkSchemas(ctx, q, "SELECT "+cols+" FROM "+table, nil, schemas)
+}
+
+// copyText encodes rows in COPY text format.
+func copyText(columns []string, rows [][]interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return nil, fmt.Errorf("row %d: %d values for %d columns", i, len(row), len(columns))
+		}
+		for j, v := range row {
+			if j > 0 {
+				b.WriteByte('\t')
+			}
+			if v == nil {
+				b.WriteString(`\N`)
+				continue
+			}
+			s, err := copyValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("row %d, column %s: %w", i, columns[j], err)
+			}
+			copyEscaper.WriteString(&b, s)
+		}
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func copyValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	// Arrays (vectors) and objects (json/jsonb) in their JSON form.
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// ================================================================
+// /copy_out — export a query as CSV or NDJSON
+// ================================================================
+
+type copyOutReq struct {
+	SQL    string        `json:"sql"`
+	Args   []interface{} `json:"args,omitempty"`
+	Format string        `json:"format,omitempty"` // csv (default) or ndjson
+	Header bool          `json:"header,omitempty"` // csv starts with the column names
+}
+
+// copyOut runs a read-only query and returns its rows as CSV or
+// newline-delimited JSON, encoded as in /query. Rows are encoded as they
+// arrive, so only the output is held; it is capped at MaxResultBytes.
+func (p *Proxy) copyOut(ctx context.Context, body []byte) *zap.Message {
+	var req copyOutReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	contentType := "text/csv"
+	switch req.Format {
+	case "", "csv":
+	case "ndjson":
+		contentType = "application/x-ndjson"
+	default:
+		return respond(http.StatusBadRequest, map[string]string{"error": "unknown format: " + req.Format})
+	}
+
+	tx, err := p.beginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	defer tx.Rollback(ctx)
+	if schemas := auth.FromContext(ctx).Schemas; len(schemas) > 0 {
+		if err := checkSchemas(ctx, tx, req.SQL, req.Args, schemas); err != nil {
+			return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+	if err := checkSessionStatement(ctx, tx, req.SQL, req.Args); err != nil {
+		return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	rows, err := tx.Query(ctx, req.SQL, req.Args...)
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	data, err := writeRows(rows, req.Format == "ndjson", req.Header, p.maxResult.Load())
+	if err != nil {
+		return queryError(err)
+	}
+	if err := checkSession(ctx, tx); err != nil {
+		return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	return respondRaw(http.StatusOK, contentType, data)
+}
+
+// writeRows encodes rows as CSV or NDJSON and closes them, failing with
+// errResultTooLarge once the output exceeds maxBytes.
+func writeRows(rows pgx.Rows, ndjson, header bool, maxBytes int64) ([]byte, error) {
+	defer rows.Close()
+	m := rows.Conn().TypeMap()
+	descs := rows.FieldDescriptions()
+
+	if ndjson {
+		names := make([]string, len(descs))
+		for i, d := range descs {
+			names[i] = d.Name
+		}
+		if err := checkObjects(names); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	record := make([]string, len(descs))
+	if header && !ndjson {
+		for i, d := range descs {
+			record[i] = d.Name
+		}
+		w.Write(record)
+	}
+	for rows.Next() {
+		vals, err := rows.Values()
+		if err != nil {
+			return nil, err
+		}
+		raw := rows.RawValues()
+		obj := make(map[string]interface{}, len(descs))
+		for i, d := range descs {
+			v, err := encodeColumn(m, d.DataTypeOID, d.Format, raw[i], vals[i])
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", d.Name, err)
+			}
+			if ndjson {
+				obj[d.Name] = v
+			} else if record[i], err = csvField(v); err != nil {
+				return nil, fmt.Errorf("column %s: %w", d.Name, err)
+			}
+		}
+		if ndjson {
+			line, err := json.Marshal(obj)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+		} else {
+			w.Write(record)
+			w.Flush()
+		}
+		if int64(buf.Len()) > maxBytes {
+			return nil, errResultTooLarge
+		}
+	}
+	w.Flush()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), w.Error()
+}
+
+// csvField renders an encoded value as a CSV field; NULL is empty.
+func csvField(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case json.RawMessage:
+		return string(v), nil
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
@@ -0,0 +1,117 @@
+package sql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestRowValues(t *testing.T) {
+	tests := []struct {
+		columns []string
+		rows    []interface{}
+		wantCol []string
+		want    [][]interface{}
+		wantErr bool
+	}{
+		{
+			columns: []string{"id", "name"},
+			rows:    []interface{}{[]interface{}{json.Number("1"), "a"}},
+			wantCol: []string{"id", "name"},
+			want:    [][]interface{}{{json.Number("1"), "a"}},
+		},
+		// Objects take their columns from the first row.
+		{
+			rows:    []interface{}{map[string]interface{}{"name": "a", "id": json.Number("1")}, map[string]interface{}{"id": json.Number("2")}},
+			wantCol: []string{"id", "name"},
+			want:    [][]interface{}{{json.Number("1"), "a"}, {json.Number("2"), nil}},
+		},
+		{
+			columns: []string{"name"},
+			rows:    []interface{}{map[string]interface{}{"name": "a", "id": json.Number("1")}},
+			wantCol: []string{"name"},
+			want:    [][]interface{}{{"a"}},
+		},
+		{rows: []interface{}{[]interface{}{"a"}}, wantErr: true},
+		{columns: []string{"id"}, rows: []interface{}{"a"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		columns := tt.columns
+		got, err := rowValues(&columns, tt.rows)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("rowValues(%v, %v): err = %v", tt.columns, tt.rows, err)
+			continue
+		}
+		if !tt.wantErr && (!slices.Equal(columns, tt.wantCol) || !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("rowValues(%v, %v) = %v, %v; want %v, %v", tt.columns, tt.rows, columns, got, tt.wantCol, tt.want)
+		}
+	}
+}
+
+func TestCopyText(t *testing.T) {
+	tests := []struct {
+		rows    [][]interface{}
+		want    string
+		wantErr bool
+	}{
+		{[][]interface{}{{json.Number("1"), "a", true}}, "1\ta\ttrue\n", false},
+		{[][]interface{}{{nil, `\N`, "x"}}, "\\N\t\\\\N\tx\n", false},
+		{[][]interface{}{{"a\tb", "c\nd", "e\\f\r"}}, "a\\tb\tc\\nd\te\\\\f\\r\n", false},
+		{
+			[][]interface{}{{[]interface{}{json.Number("0.5"), json.Number("1")}, map[string]interface{}{"k": "v"}, "z"}},
+			"[0.5,1]\t{\"k\":\"v\"}\tz\n", false,
+		},
+		{[][]interface{}{{"a"}}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := copyText([]string{"a", "b", "c"}, tt.rows)
+		if (err != nil) != tt.wantErr || string(got) != tt.want {
+			t.Errorf("copyText(%q) = %q, %v; want %q", tt.rows, got, err, tt.want)
+		}
+	}
+}
+
+func TestCopyRow(t *testing.T) {
+	tests := []struct {
+		err    error
+		skip   int
+		want   int
+		wantOK bool
+	}{
+		{&pgconn.PgError{Where: "COPY items, line 1, column id: \"x\""}, 0, 0, true},
+		{&pgconn.PgError{Where: "COPY items, line 3"}, 1, 1, true},
+		{fmt.Errorf("copy: %w", &pgconn.PgError{Where: "COPY items, line 5"}), 0, 4, true},
+		{&pgconn.PgError{Where: "SQL function \"f\""}, 0, 0, false},
+		{errors.New("connection reset"), 0, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := copyRow(tt.err, tt.skip)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("copyRow(%v, %d) = %d, %v; want %d, %v", tt.err, tt.skip, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestCSVField(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, ""},
+		{"a,b", "a,b"},
+		{json.Number("1.50"), "1.50"},
+		{json.RawMessage(`{"k":1}`), `{"k":1}`},
+		{true, "true"},
+		{[]interface{}{"a", nil}, `["a",null]`},
+	}
+	for _, tt := range tests {
+		if got, err := csvField(tt.v); err != nil || got != tt.want {
+			t.Errorf("csvField(%v) = %q, %v; want %q", tt.v, got, err, tt.want)
+		}
+	}
+}
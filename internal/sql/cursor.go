@@ -0,0 +1,158 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// cursorName is the one cursor declared in each cursor transaction.
+const cursorName = "zap_cursor"
+
+type cursorReq struct {
+	Cursor  string `json:"cursor"`
+	MaxRows int    `json:"max_rows,omitempty"` // default: the opening query's
+	Format  string `json:"format,omitempty"`
+}
+
+// openCursor runs a /query with max_rows through a server-side cursor.
+// The first page is returned directly; if more rows may follow, the
+// read-only transaction holding the cursor stays open and its token is
+// returned as "cursor". Pages are fetched with /query/next until one
+// comes back without a cursor, or the cursor is dropped with
+// /query/close or by the idle timeout.
+func (p *Proxy) openCursor(ctx context.Context, req sqlReq) *zap.Message {
+	if msg := checkReq(req); msg != nil {
+		return msg
+	}
+	tx, err := p.beginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if schemas := auth.FromContext(ctx).Schemas; len(schemas) > 0 {
+		if err := checkSchemas(ctx, tx, req.SQL, req.Args, schemas); err != nil {
+			return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+	if err := checkSessionStatement(ctx, tx, req.SQL, req.Args); err != nil {
+		return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	// One statement only: the query must not end the read-only transaction.
+	if _, err := execOne(ctx, tx, "DECLARE "+cursorName+" NO SCROLL CURSOR FOR "+req.SQL, req.Args); err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	out, more, err := p.fetch(ctx, tx, req.MaxRows, req.Format)
+	if err != nil {
+		return queryError(err)
+	}
+	if err := checkSession(ctx, tx); err != nil {
+		return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if more {
+		keep = true
+		out["cursor"] = p.cursors.add(tx, auth.FromContext(ctx).Caller, req.MaxRows, sessionFrom(ctx))
+	}
+	return respond(http.StatusOK, out)
+}
+
+// fetch reads the next n rows of the cursor. more reports a full page,
+// so the last page of a result may be empty.
+func (p *Proxy) fetch(ctx context.Context, tx pgx.Tx, n int, format string) (map[string]interface{}, bool, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf("FETCH FORWARD %d FROM %s", n, cursorName))
+	if err != nil {
+		return nil, false, err
+	}
+	rs, err := readRows(rows, p.limits(0))
+	if err == nil {
+		err = rs.checkFormat(format)
+	}
+	if err == nil {
+		err = p.resolveTypes(ctx, tx, rs)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	out := rs.render(format)
+	more := len(rs.Rows) == n
+	if more {
+		out["more"] = true
+	}
+	return out, more, nil
+}
+
+// cursorNext returns the next page of a cursor, closing it after the last.
+func (p *Proxy) cursorNext(ctx context.Context, body []byte) *zap.Message {
+	var req cursorReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if msg := checkReq(sqlReq{Format: req.Format, MaxRows: req.MaxRows}); msg != nil {
+		return msg
+	}
+	t, err := p.cursors.acquire(req.Cursor, auth.FromContext(ctx).Caller)
+	if err != nil {
+		return respond(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	defer p.cursors.release(t)
+
+	n := req.MaxRows
+	if n == 0 {
+		n = t.page
+	}
+	ctx = context.WithValue(ctx, sessionKey{}, t.session)
+	err = setStatementTimeout(ctx, t.tx)
+	var out map[string]interface{}
+	var more bool
+	if err == nil {
+		out, more, err = p.fetch(ctx, t.tx, n, req.Format)
+	}
+	var changed error
+	if err == nil {
+		changed = checkSession(ctx, t.tx)
+	}
+	if err != nil || changed != nil || !more {
+		// A failed FETCH aborts the transaction, so the cursor is gone.
+		p.cursors.finish(req.Cursor, t)
+		t.tx.Rollback(ctx)
+		if err != nil {
+			return queryError(err)
+		}
+		if changed != nil {
+			return respond(http.StatusForbidden, map[string]string{"error": changed.Error()})
+		}
+		return respond(http.StatusOK, out)
+	}
+	out["cursor"] = req.Cursor
+	return respond(http.StatusOK, out)
+}
+
+// cursorClose drops a cursor before its last page.
+func (p *Proxy) cursorClose(ctx context.Context, body []byte) *zap.Message {
+	var req cursorReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	t, err := p.cursors.acquire(req.Cursor, auth.FromContext(ctx).Caller)
+	if err != nil {
+		return respond(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	p.cursors.finish(req.Cursor, t)
+	defer p.cursors.release(t)
+	if err := t.tx.Rollback(ctx); err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, map[string]string{"status": "closed"})
+}
@@ -0,0 +1,42 @@
+package sql
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCursorRunsOneStatement(t *testing.T) {
+	for _, readOnly := range []bool{false, true} {
+		p := testProxy(t, Config{ReadOnly: readOnly})
+		mustExec(t, p, "DROP TABLE IF EXISTS zap_cursor_escape")
+
+		status, out := call(t, p, nil, "/query",
+			`{"sql": "SELECT 1; COMMIT; CREATE TABLE zap_cursor_escape (x int)", "max_rows": 1}`)
+		if status == http.StatusOK {
+			t.Errorf("read_only=%v: multi-statement cursor query succeeded: %v", readOnly, out)
+		}
+		if relationExists(t, p, "zap_cursor_escape") {
+			mustExec(t, p, "DROP TABLE zap_cursor_escape")
+			t.Errorf("read_only=%v: statement after COMMIT ran outside the read-only transaction", readOnly)
+		}
+	}
+}
+
+func TestCursorPages(t *testing.T) {
+	p := testProxy(t, Config{})
+	status, out := call(t, p, nil, "/query", `{"sql": "SELECT g FROM generate_series(1, 3) g", "max_rows": 2}`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %v", status, out)
+	}
+	cursor, _ := out["cursor"].(string)
+	if cursor == "" {
+		t.Fatalf("no cursor after a full page: %v", out)
+	}
+	status, out = call(t, p, nil, "/query/next", `{"cursor": "`+cursor+`"}`)
+	if status != http.StatusOK {
+		t.Fatalf("next: status %d: %v", status, out)
+	}
+	if _, more := out["cursor"]; more {
+		t.Errorf("cursor kept after the last page: %v", out)
+	}
+}
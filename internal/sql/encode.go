@@ -0,0 +1,456 @@
+package sql
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/luxfi/zap"
+)
+
+// Result formats. Every format carries the column metadata; they differ
+// in how rows are laid out.
+const (
+	formatObjects  = "objects"  // rows: [{column: value}]; column names must be unique
+	formatArrays   = "arrays"   // rows: [[value, ...]] in column order
+	formatColumnar = "columnar" // values: [[column 0 values], [column 1 values], ...]
+)
+
+func validFormat(f string) bool {
+	switch f {
+	case "", formatObjects, formatArrays, formatColumnar:
+		return true
+	}
+	return false
+}
+
+// column describes one result column.
+type column struct {
+	Name     string `json:"name"`
+	OID      uint32 `json:"oid"`
+	Type     string `json:"type"`
+	Encoding string `json:"encoding,omitempty"` // "base64" for bytea
+}
+
+// resultSet is a fully read, JSON-ready query result.
+//
+// Values are encoded losslessly: numeric as a decimal string, uuid in
+// canonical form, timestamptz as RFC 3339 in UTC, timestamp and date
+// without a zone, interval as an ISO 8601 duration, inet/cidr in
+// Postgres notation, bytea as base64, json/jsonb embedded verbatim,
+// non-finite floats as "NaN"/"Infinity"/"-Infinity", arrays as nested
+// JSON arrays, and pgvector vectors as JSON arrays of numbers.
+type resultSet struct {
+	Columns []column
+	Rows    [][]interface{}
+	More    bool  // rows were left unread
+	Size    int64 // approximate encoded size
+}
+
+// defaultMaxResultBytes caps one response when Config.MaxResultBytes is 0.
+const defaultMaxResultBytes = 32 << 20
+
+var errResultTooLarge = errors.New("result too large; use max_rows to page through it")
+
+var errDuplicateColumn = errors.New("duplicate column name")
+
+// checkObjects fails with errDuplicateColumn if rows laid out as objects,
+// keyed by column name, would lose the value of a repeated name.
+func checkObjects(names []string) error {
+	for i, name := range names {
+		if slices.Contains(names[:i], name) {
+			return fmt.Errorf("%w %q; alias the columns or use the arrays or columnar format", errDuplicateColumn, name)
+		}
+	}
+	return nil
+}
+
+// checkFormat reports whether the result can be rendered in format.
+func (rs *resultSet) checkFormat(format string) error {
+	if format != "" && format != formatObjects {
+		return nil
+	}
+	names := make([]string, len(rs.Columns))
+	for i, c := range rs.Columns {
+		names[i] = c.Name
+	}
+	return checkObjects(names)
+}
+
+// limits bound what readRows reads.
+type limits struct {
+	rows  int   // 0 for no limit
+	bytes int64 // approximate encoded size
+}
+
+func (p *Proxy) limits(maxRows int) limits {
+	return limits{rows: maxRows, bytes: p.maxResult.Load()}
+}
+
+// readRows encodes rows and closes them. It stops after lim.rows rows,
+// setting More if another row follows, and fails with errResultTooLarge
+// once the result outgrows lim.bytes. Column types unknown to pgx are
+// left unnamed until resolveTypes.
+func readRows(rows pgx.Rows, lim limits) (*resultSet, error) {
+	defer rows.Close()
+	m := rows.Conn().TypeMap()
+	descs := rows.FieldDescriptions()
+
+	rs := &resultSet{Columns: make([]column, len(descs))}
+	for i, d := range descs {
+		rs.Columns[i] = column{Name: d.Name, OID: d.DataTypeOID}
+		if t, ok := m.TypeForOID(d.DataTypeOID); ok {
+			rs.Columns[i].Type = t.Name
+		}
+		if d.DataTypeOID == pgtype.ByteaOID || d.DataTypeOID == pgtype.ByteaArrayOID {
+			rs.Columns[i].Encoding = "base64"
+		}
+	}
+
+	for rows.Next() {
+		if lim.rows > 0 && len(rs.Rows) == lim.rows {
+			rs.More = true
+			break
+		}
+		vals, err := rows.Values()
+		if err != nil {
+			return nil, err
+		}
+		raw := rows.RawValues()
+		row := make([]interface{}, len(descs))
+		for i, d := range descs {
+			if row[i], err = encodeColumn(m, d.DataTypeOID, d.Format, raw[i], vals[i]); err != nil {
+				return nil, fmt.Errorf("column %s: %w", d.Name, err)
+			}
+			// The wire size plus punctuation is close enough to the JSON
+			// size to bound memory.
+			rs.Size += int64(len(raw[i]) + len(d.Name) + 8)
+		}
+		if lim.bytes > 0 && rs.Size > lim.bytes {
+			return nil, errResultTooLarge
+		}
+		rs.Rows = append(rs.Rows, row)
+	}
+	return rs, rows.Err()
+}
+
+// queryError responds to a failed query: 413 for an oversized result,
+// 400 for one the format cannot lay out, 500 otherwise.
+func queryError(err error) *zap.Message {
+	return respond(queryStatus(err), map[string]string{"error": err.Error()})
+}
+
+func queryStatus(err error) int {
+	switch {
+	case errors.Is(err, errResultTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errDuplicateColumn):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// checkReq validates the result options of a query request.
+func checkReq(req sqlReq) *zap.Message {
+	if !validFormat(req.Format) {
+		return respond(http.StatusBadRequest, map[string]string{"error": "unknown format: " + req.Format})
+	}
+	if req.MaxRows < 0 {
+		return respond(http.StatusBadRequest, map[string]string{"error": "max_rows must not be negative"})
+	}
+	return nil
+}
+
+func encodeColumn(m *pgtype.Map, oid uint32, format int16, raw []byte, v interface{}) (interface{}, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	switch oid {
+	case pgtype.JSONOID:
+		return json.RawMessage(bytes.Clone(raw)), nil
+	case pgtype.JSONBOID:
+		if format == pgtype.BinaryFormatCode {
+			raw = raw[1:] // version byte
+		}
+		return json.RawMessage(bytes.Clone(raw)), nil
+	}
+
+	t, ok := m.TypeForOID(oid)
+	if !ok {
+		return v, nil
+	}
+	ac, ok := t.Codec.(*pgtype.ArrayCodec)
+	if !ok {
+		return encodeValue(v, oid), nil
+	}
+	// Values() flattens multi-dimensional arrays; rescan to keep the shape.
+	var arr pgtype.Array[interface{}]
+	if err := m.Scan(oid, format, raw, &arr); err != nil {
+		return nil, err
+	}
+	elems := make([]interface{}, len(arr.Elements))
+	for i, e := range arr.Elements {
+		elems[i] = encodeValue(e, ac.ElementType.OID)
+	}
+	return nest(elems, arr.Dims), nil
+}
+
+// nest shapes flat row-major elements into nested arrays per dims.
+func nest(elems []interface{}, dims []pgtype.ArrayDimension) []interface{} {
+	if len(dims) <= 1 {
+		return elems
+	}
+	n := int(dims[0].Length)
+	size := len(elems) / n
+	out := make([]interface{}, n)
+	for i := range out {
+		out[i] = nest(elems[i*size:(i+1)*size], dims[1:])
+	}
+	return out
+}
+
+// encodeValue encodes a value decoded by pgx from a column of type oid.
+func encodeValue(v interface{}, oid uint32) interface{} {
+	switch v := v.(type) {
+	case float32:
+		return encodeFloat(float64(v), 32)
+	case float64:
+		return encodeFloat(v, 64)
+	case pgtype.Numeric:
+		return numericString(v)
+	case [16]byte:
+		return fmt.Sprintf("%x-%x-%x-%x-%x", v[0:4], v[4:6], v[6:8], v[8:10], v[10:16])
+	case pgtype.Interval:
+		return intervalString(v)
+	case pgtype.Time:
+		return timeOfDay(v.Microseconds)
+	case pgtype.InfinityModifier:
+		return v.String()
+	case time.Time:
+		switch oid {
+		case pgtype.DateOID:
+			return v.Format("2006-01-02")
+		case pgtype.TimestampOID:
+			return v.Format("2006-01-02T15:04:05.999999")
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	case netip.Prefix:
+		if oid == pgtype.InetOID && v.IsSingleIP() {
+			return v.Addr().String()
+		}
+		return v.String()
+	case net.HardwareAddr:
+		return v.String()
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	}
+	return v
+}
+
+func encodeFloat(f float64, bits int) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, bits))
+}
+
+// numericString renders n exactly, keeping its scale.
+func numericString(n pgtype.Numeric) string {
+	switch {
+	case n.NaN:
+		return "NaN"
+	case n.InfinityModifier == pgtype.Infinity:
+		return "Infinity"
+	case n.InfinityModifier == pgtype.NegativeInfinity:
+		return "-Infinity"
+	}
+	digits := n.Int.String()
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if n.Exp >= 0 {
+		return sign + digits + strings.Repeat("0", int(n.Exp))
+	}
+	point := len(digits) + int(n.Exp)
+	if point <= 0 {
+		return sign + "0." + strings.Repeat("0", -point) + digits
+	}
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// intervalString renders an interval as an ISO 8601 duration, the way
+// Postgres does with intervalstyle = iso_8601.
+func intervalString(iv pgtype.Interval) string {
+	var b strings.Builder
+	b.WriteByte('P')
+	if y := iv.Months / 12; y != 0 {
+		fmt.Fprintf(&b, "%dY", y)
+	}
+	if mo := iv.Months % 12; mo != 0 {
+		fmt.Fprintf(&b, "%dM", mo)
+	}
+	if iv.Days != 0 {
+		fmt.Fprintf(&b, "%dD", iv.Days)
+	}
+	if us := iv.Microseconds; us != 0 {
+		b.WriteByte('T')
+		h := us / int64(time.Hour/time.Microsecond)
+		us -= h * int64(time.Hour/time.Microsecond)
+		mi := us / int64(time.Minute/time.Microsecond)
+		us -= mi * int64(time.Minute/time.Microsecond)
+		if h != 0 {
+			fmt.Fprintf(&b, "%dH", h)
+		}
+		if mi != 0 {
+			fmt.Fprintf(&b, "%dM", mi)
+		}
+		if us != 0 {
+			b.WriteString(seconds(us))
+			b.WriteByte('S')
+		}
+	}
+	if b.Len() == 1 {
+		return "PT0S"
+	}
+	return b.String()
+}
+
+// seconds renders microseconds as decimal seconds without trailing zeros.
+func seconds(us int64) string {
+	sign := ""
+	if us < 0 {
+		sign, us = "-", -us
+	}
+	s := strconv.FormatInt(us/1e6, 10)
+	if frac := us % 1e6; frac != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+	}
+	return sign + s
+}
+
+func timeOfDay(us int64) string {
+	h := us / int64(time.Hour/time.Microsecond)
+	us -= h * int64(time.Hour/time.Microsecond)
+	m := us / int64(time.Minute/time.Microsecond)
+	us -= m * int64(time.Minute/time.Microsecond)
+	s := fmt.Sprintf("%02d:%02d:%02d", h, m, us/1e6)
+	if frac := us % 1e6; frac != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+	}
+	return s
+}
+
+// vectorTypes are pgvector types whose text form is a JSON array.
+var vectorTypes = map[string]bool{"vector": true, "halfvec": true}
+
+// resolveTypes names columns of types pgx does not know, such as
+// extension types, and embeds pgvector values as JSON arrays. It runs on
+// q once the results have been read, so q's connection is free.
+func (p *Proxy) resolveTypes(ctx context.Context, q querier, sets ...*resultSet) error {
+	var unknown []uint32
+	for _, rs := range sets {
+		for i, c := range rs.Columns {
+			if c.Type != "" {
+				continue
+			}
+			if name, ok := p.typeNames.Load(c.OID); ok {
+				rs.Columns[i].Type = name.(string)
+			} else {
+				unknown = append(unknown, c.OID)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		rows, err := q.Query(ctx, "SELECT oid, typname FROM pg_type WHERE oid = ANY($1)", unknown)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var oid uint32
+			var name string
+			if err := rows.Scan(&oid, &name); err != nil {
+				rows.Close()
+				return err
+			}
+			p.typeNames.Store(oid, name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for _, rs := range sets {
+		for i, c := range rs.Columns {
+			if c.Type == "" {
+				if name, ok := p.typeNames.Load(c.OID); ok {
+					rs.Columns[i].Type = name.(string)
+				}
+			}
+			if !vectorTypes[rs.Columns[i].Type] {
+				continue
+			}
+			for _, row := range rs.Rows {
+				if s, ok := row[i].(string); ok && json.Valid([]byte(s)) {
+					row[i] = json.RawMessage(s)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// render lays the result out in format.
+func (rs *resultSet) render(format string) map[string]interface{} {
+	out := map[string]interface{}{"columns": rs.Columns, "count": len(rs.Rows)}
+	if rs.More {
+		out["more"] = true
+	}
+	switch format {
+	case formatArrays:
+		rows := rs.Rows
+		if rows == nil {
+			rows = [][]interface{}{}
+		}
+		out["rows"] = rows
+	case formatColumnar:
+		values := make([][]interface{}, len(rs.Columns))
+		for i := range values {
+			values[i] = make([]interface{}, len(rs.Rows))
+			for j, row := range rs.Rows {
+				values[i][j] = row[i]
+			}
+		}
+		out["values"] = values
+	default:
+		rows := make([]map[string]interface{}, 0, len(rs.Rows))
+		for _, row := range rs.Rows {
+			obj := make(map[string]interface{}, len(row))
+			for i, c := range rs.Columns {
+				obj[c.Name] = row[i]
+			}
+			rows = append(rows, obj)
+		}
+		out["rows"] = rows
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+package sql
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestNumericString(t *testing.T) {
+	tests := []struct {
+		n    pgtype.Numeric
+		want string
+	}{
+		{pgtype.Numeric{Int: big.NewInt(12345), Exp: -2, Valid: true}, "123.45"},
+		{pgtype.Numeric{Int: big.NewInt(-5), Exp: -3, Valid: true}, "-0.005"},
+		{pgtype.Numeric{Int: big.NewInt(100), Exp: -2, Valid: true}, "1.00"},
+		{pgtype.Numeric{Int: big.NewInt(42), Exp: 3, Valid: true}, "42000"},
+		{pgtype.Numeric{Int: new(big.Int).Lsh(big.NewInt(1), 80), Exp: 0, Valid: true}, "1208925819614629174706176"},
+		{pgtype.Numeric{Int: big.NewInt(0), Exp: -2, Valid: true}, "0.00"},
+		{pgtype.Numeric{NaN: true, Valid: true}, "NaN"},
+		{pgtype.Numeric{InfinityModifier: pgtype.Infinity, Valid: true}, "Infinity"},
+		{pgtype.Numeric{InfinityModifier: pgtype.NegativeInfinity, Valid: true}, "-Infinity"},
+	}
+	for _, tt := range tests {
+		if got := numericString(tt.n); got != tt.want {
+			t.Errorf("numericString(%v e%d) = %q, want %q", tt.n.Int, tt.n.Exp, got, tt.want)
+		}
+	}
+}
+
+func TestIntervalString(t *testing.T) {
+	tests := []struct {
+		iv   pgtype.Interval
+		want string
+	}{
+		{pgtype.Interval{}, "PT0S"},
+		{pgtype.Interval{Months: 14, Days: 3}, "P1Y2M3D"},
+		{pgtype.Interval{Microseconds: int64(90*time.Minute/time.Microsecond) + 1_500_000}, "PT1H30M1.5S"},
+		{pgtype.Interval{Days: 1, Microseconds: 1}, "P1DT0.000001S"},
+		{pgtype.Interval{Months: -1, Microseconds: -2_000_000}, "P-1MT-2S"},
+	}
+	for _, tt := range tests {
+		if got := intervalString(tt.iv); got != tt.want {
+			t.Errorf("intervalString(%+v) = %q, want %q", tt.iv, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	ts := time.Date(2024, 2, 29, 13, 4, 5, 120000000, time.FixedZone("", 3600))
+	tests := []struct {
+		v    interface{}
+		oid  uint32
+		want interface{}
+	}{
+		{float64(0.1), pgtype.Float8OID, json.Number("0.1")},
+		{float32(0.1), pgtype.Float4OID, json.Number("0.1")},
+		{math.NaN(), pgtype.Float8OID, "NaN"},
+		{math.Inf(-1), pgtype.Float8OID, "-Infinity"},
+		{[16]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 1, 2, 3, 4, 5, 6, 7, 8}, pgtype.UUIDOID, "12345678-9abc-def0-0102-030405060708"},
+		{ts, pgtype.TimestamptzOID, "2024-02-29T12:04:05.12Z"},
+		{ts, pgtype.TimestampOID, "2024-02-29T13:04:05.12"},
+		{ts, pgtype.DateOID, "2024-02-29"},
+		{pgtype.Infinity, pgtype.DateOID, "infinity"},
+		{pgtype.Time{Microseconds: int64(13*time.Hour/time.Microsecond) + 5}, pgtype.TimeOID, "13:00:00.000005"},
+		{netip.MustParsePrefix("10.0.0.1/32"), pgtype.InetOID, "10.0.0.1"},
+		{netip.MustParsePrefix("10.0.0.1/8"), pgtype.InetOID, "10.0.0.1/8"},
+		{netip.MustParsePrefix("10.0.0.0/32"), pgtype.CIDROID, "10.0.0.0/32"},
+		{net.HardwareAddr{0, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}, pgtype.MacaddrOID, "00:1a:2b:3c:4d:5e"},
+		{[]byte{0, 0xff}, pgtype.ByteaOID, "AP8="},
+		{int64(1) << 60, pgtype.Int8OID, int64(1) << 60},
+	}
+	for _, tt := range tests {
+		if got := encodeValue(tt.v, tt.oid); got != tt.want {
+			t.Errorf("encodeValue(%v, %d) = %#v, want %#v", tt.v, tt.oid, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeColumn(t *testing.T) {
+	m := pgtype.NewMap()
+	tests := []struct {
+		oid    uint32
+		format int16
+		raw    []byte
+		want   interface{}
+	}{
+		{pgtype.Int4OID, pgtype.TextFormatCode, nil, nil},
+		// JSON is passed through verbatim, keeping key order.
+		{pgtype.JSONOID, pgtype.TextFormatCode, []byte(`{"b": 1, "a": 2}`), json.RawMessage(`{"b": 1, "a": 2}`)},
+		{pgtype.JSONBOID, pgtype.BinaryFormatCode, []byte("\x01{\"a\": 1}"), json.RawMessage(`{"a": 1}`)},
+		{pgtype.NumericArrayOID, pgtype.TextFormatCode, []byte("{1.50,NULL}"), []interface{}{"1.50", nil}},
+		{
+			pgtype.Int4ArrayOID, pgtype.TextFormatCode, []byte("{{1,2,3},{4,5,6}}"),
+			[]interface{}{[]interface{}{int32(1), int32(2), int32(3)}, []interface{}{int32(4), int32(5), int32(6)}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := encodeColumn(m, tt.oid, tt.format, tt.raw, nil)
+		if err != nil {
+			t.Errorf("encodeColumn(%d, %q): %v", tt.oid, tt.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("encodeColumn(%d, %q) = %#v, want %#v", tt.oid, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	dup := &resultSet{Columns: []column{{Name: "id"}, {Name: "name"}, {Name: "id"}}}
+	uniq := &resultSet{Columns: []column{{Name: "id"}, {Name: "name"}}}
+	tests := []struct {
+		rs      *resultSet
+		format  string
+		wantErr bool
+	}{
+		{dup, "", true},
+		{dup, formatObjects, true},
+		{dup, formatArrays, false},
+		{dup, formatColumnar, false},
+		{uniq, "", false},
+		{uniq, formatObjects, false},
+	}
+	for _, tt := range tests {
+		err := tt.rs.checkFormat(tt.format)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkFormat(%q) with %d columns: %v", tt.format, len(tt.rs.Columns), err)
+		}
+		if err != nil && !errors.Is(err, errDuplicateColumn) {
+			t.Errorf("checkFormat(%q): %v is not errDuplicateColumn", tt.format, err)
+		}
+		if err != nil && queryStatus(err) != http.StatusBadRequest {
+			t.Errorf("checkFormat(%q): status %d, want 400", tt.format, queryStatus(err))
+		}
+	}
+}
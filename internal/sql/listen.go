@@ -0,0 +1,419 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
+	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+	"github.com/hanzoai/zap-sidecar/internal/push"
+)
+
+// MsgTypeSQLNotify is the type of the messages pushed to subscribers; see
+// package push for their layout.
+const MsgTypeSQLNotify uint16 = 210
+
+const (
+	changesInterval    = time.Second // slot polling interval
+	maxChangesPerPoll  = 1000
+	maxListenerBackoff = 30 * time.Second
+)
+
+var errListenerReset = errors.New("listener reset")
+
+// subscription pushes events to one ZAP peer: notifications on a set of
+// LISTEN channels, or the changes decoded from a logical replication slot.
+type subscription struct {
+	push.Subscription
+	Channels []string `json:"channels,omitempty"`
+	Slot     string   `json:"slot,omitempty"`
+}
+
+// hub runs the dedicated LISTEN connection and the slot pollers for the
+// subscriptions in push.
+type hub struct {
+	p    *Proxy
+	push *push.Hub
+
+	mu        sync.Mutex
+	slots     map[string]bool    // slots with a subscriber
+	listening bool               // the listener goroutine is running
+	wake      context.CancelFunc // interrupts the listener's wait
+	reset     bool               // the listener should reconnect
+}
+
+func newHub(p *Proxy) *hub {
+	h := &hub{p: p, slots: make(map[string]bool)}
+	h.push = push.NewHub(push.Config{
+		Mode:    "sql",
+		MsgType: MsgTypeSQLNotify,
+		Logger:  p.logger,
+		Send: func(ctx context.Context, peer string, msg *zap.Message) error {
+			return p.node.Send(ctx, peer, msg)
+		},
+		Detach: h.detach,
+	})
+	return h
+}
+
+// add starts delivering to s. Notifications are queued for s; changes are
+// pushed by the slot's poller.
+func (h *hub) add(s *subscription, peer, owner string) error {
+	attach := func() error {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.slots[s.Slot] {
+			return errors.New("slot already has a subscriber: " + s.Slot)
+		}
+		h.slots[s.Slot] = true
+		return nil
+	}
+	if s.Slot == "" {
+		attach = nil
+	}
+	if err := h.push.Add(s, peer, owner, s.Slot == "", attach); err != nil {
+		return err
+	}
+	if s.Slot != "" {
+		h.push.Go(func() { h.pollChanges(s) })
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.listening {
+		h.listening = true
+		h.push.Go(h.listen)
+	}
+	h.wakeLocked()
+	return nil
+}
+
+// detach frees the slot of a removed subscription and resyncs the
+// listener's channels.
+func (h *hub) detach(sub push.Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.slots, sub.(*subscription).Slot)
+	h.wakeLocked()
+}
+
+func (h *hub) wakeLocked() {
+	if h.wake != nil {
+		h.wake()
+	}
+}
+
+// reconnect makes the listener reconnect, e.g. after the pool changed.
+func (h *hub) reconnect() {
+	h.mu.Lock()
+	h.reset = true
+	h.wakeLocked()
+	h.mu.Unlock()
+}
+
+// close stops every subscription.
+func (h *hub) close() {
+	h.push.Close()
+}
+
+// ================================================================
+// LISTEN
+// ================================================================
+
+// listen keeps a dedicated connection LISTENing on every subscribed
+// channel, reconnecting with backoff. After a reconnect, subscribers get
+// a "reconnected" event, since notifications sent meanwhile are lost.
+func (h *hub) listen() {
+	ctx := h.push.Context()
+	backoff := time.Second
+	reconnected := false
+	for ctx.Err() == nil {
+		connected, err := h.serve(ctx, reconnected)
+		if ctx.Err() != nil {
+			return
+		}
+		reconnected = true
+		if connected {
+			backoff = time.Second
+		}
+		if errors.Is(err, errListenerReset) {
+			continue
+		}
+		h.p.logger.Warn("sql: listener disconnected", "error", err, "retry", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxListenerBackoff)
+	}
+}
+
+// serve runs one listener connection until it fails or is reset.
+func (h *hub) serve(ctx context.Context, reconnected bool) (bool, error) {
+	cfg := h.p.pool.Load().Config().ConnConfig.Copy()
+	// Waking the listener cancels its wait; that must only interrupt the
+	// read, not send the server a cancel request.
+	cfg.BuildContextWatcherHandler = func(c *pgconn.PgConn) ctxwatch.Handler {
+		return &pgconn.DeadlineContextWatcherHandler{Conn: c.Conn()}
+	}
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	listening := make(map[string]bool)
+	for {
+		wctx, wake := context.WithCancel(ctx)
+		h.mu.Lock()
+		h.wake = wake
+		if h.reset {
+			h.reset = false
+			h.mu.Unlock()
+			wake()
+			return true, errListenerReset
+		}
+		h.mu.Unlock()
+		want := make(map[string]bool)
+		h.push.Each(func(sub push.Subscriber) {
+			for _, ch := range sub.(*subscription).Channels {
+				want[ch] = true
+			}
+		})
+
+		for ch := range want {
+			if !listening[ch] {
+				if _, err := conn.Exec(ctx, "LISTEN "+ident(ch)); err != nil {
+					wake()
+					return true, err
+				}
+				listening[ch] = true
+			}
+		}
+		for ch := range listening {
+			if !want[ch] {
+				if _, err := conn.Exec(ctx, "UNLISTEN "+ident(ch)); err != nil {
+					wake()
+					return true, err
+				}
+				delete(listening, ch)
+			}
+		}
+		if reconnected {
+			reconnected = false
+			h.push.Broadcast(map[string]interface{}{"event": "reconnected"})
+		}
+
+		n, err := conn.WaitForNotification(wctx)
+		wake()
+		if err != nil {
+			if ctx.Err() == nil && wctx.Err() != nil {
+				continue // woken to resync the channels
+			}
+			return true, err
+		}
+		h.notify(n)
+	}
+}
+
+// notify queues n for every subscriber of its channel.
+func (h *hub) notify(n *pgconn.Notification) {
+	h.push.Each(func(sub push.Subscriber) {
+		if s := sub.(*subscription); slices.Contains(s.Channels, n.Channel) {
+			s.Enqueue(map[string]interface{}{"channel": n.Channel, "payload": n.Payload, "pid": n.PID})
+		}
+	})
+}
+
+// ================================================================
+// Logical replication slots
+// ================================================================
+
+// pollChanges pushes the changes of s's slot. Changes are peeked, pushed
+// in order and only then consumed by advancing the slot, so a change is
+// delivered at least once; a slow subscriber holds WAL back instead of
+// losing changes.
+func (h *hub) pollChanges(s *subscription) {
+	tick := time.NewTicker(changesInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-h.push.Context().Done():
+			return
+		case <-s.Done():
+			return
+		case <-tick.C:
+		}
+		if err := h.pollOnce(s); err != nil {
+			h.p.logger.Warn("sql: slot poll failed", "slot", s.Slot, "error", err)
+		}
+	}
+}
+
+func (h *hub) pollOnce(s *subscription) error {
+	ctx, cancel := context.WithTimeout(h.push.Context(), 30*time.Second)
+	defer cancel()
+	pool := h.p.pool.Load()
+	rows, err := pool.Query(ctx, "SELECT lsn::text, xid::text, data FROM pg_logical_slot_peek_changes($1, NULL, $2)", s.Slot, maxChangesPerPoll)
+	if err != nil {
+		return err
+	}
+	type change struct{ lsn, xid, data string }
+	var changes []change
+	for rows.Next() {
+		var c change
+		if err := rows.Scan(&c.lsn, &c.xid, &c.data); err != nil {
+			rows.Close()
+			return err
+		}
+		changes = append(changes, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	last := ""
+	for _, c := range changes {
+		event := map[string]interface{}{"slot": s.Slot, "lsn": c.lsn, "xid": c.xid, "data": c.data}
+		if json.Valid([]byte(c.data)) {
+			event["data"] = json.RawMessage(c.data) // wal2json
+		}
+		if err := s.Push(event); err != nil {
+			break
+		}
+		last = c.lsn
+	}
+	if last == "" {
+		return nil
+	}
+	_, err = pool.Exec(ctx, "SELECT pg_replication_slot_advance($1, $2::pg_lsn)", s.Slot, last)
+	return err
+}
+
+// ================================================================
+// Handlers
+// ================================================================
+
+type listenReq struct {
+	Channels []string `json:"channels"`
+}
+
+type changesReq struct {
+	Slot   string `json:"slot"`
+	Create bool   `json:"create,omitempty"` // create the slot if missing
+	Plugin string `json:"plugin,omitempty"` // output plugin for create; default wal2json
+}
+
+type unlistenReq struct {
+	Subscription string `json:"subscription"`
+}
+
+// listenChannels subscribes the requesting peer to notifications on
+// channels, pushed as MsgTypeSQLNotify messages. Channels belong to no
+// schema and NOTIFY ignores row-level security, so callers confined to
+// schemas or running with a session may not listen, as for changes.
+func (p *Proxy) listenChannels(ctx context.Context, body []byte) *zap.Message {
+	var req listenReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(req.Channels) == 0 {
+		return respond(http.StatusBadRequest, map[string]string{"error": "channels required"})
+	}
+	for _, ch := range req.Channels {
+		if ch == "" {
+			return respond(http.StatusBadRequest, map[string]string{"error": "empty channel name"})
+		}
+	}
+	if len(auth.FromContext(ctx).Schemas) > 0 {
+		return respond(http.StatusForbidden, map[string]string{"error": "channels span all schemas"})
+	}
+	if sessionFrom(ctx) != nil {
+		return respond(http.StatusForbidden, map[string]string{"error": "notifications bypass row-level security"})
+	}
+	return p.subscribe(ctx, &subscription{Channels: req.Channels})
+}
+
+// listenChanges subscribes the requesting peer to the changes of a
+// logical replication slot with a text output plugin such as wal2json.
+// The changes span every schema and ignore row-level security, so callers
+// confined to schemas or running with a session may not subscribe.
+//
+// Polling consumes the slot, and a slot nobody polls retains WAL until
+// it is dropped, filling the disk; /listen/changes is refused in
+// read-only mode and should only be granted to operator-level callers.
+// Creating a slot also needs the REPLICATION attribute on the database
+// role.
+func (p *Proxy) listenChanges(ctx context.Context, body []byte) *zap.Message {
+	var req changesReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Slot == "" {
+		return respond(http.StatusBadRequest, map[string]string{"error": "slot required"})
+	}
+	if len(auth.FromContext(ctx).Schemas) > 0 {
+		return respond(http.StatusForbidden, map[string]string{"error": "changes span all schemas"})
+	}
+	if sessionFrom(ctx) != nil {
+		return respond(http.StatusForbidden, map[string]string{"error": "changes bypass row-level security"})
+	}
+
+	pool := p.pool.Load()
+	if req.Create {
+		if req.Plugin == "" {
+			req.Plugin = "wal2json"
+		}
+		_, err := pool.Exec(ctx, "SELECT pg_create_logical_replication_slot($1, $2)", req.Slot, req.Plugin)
+		var pgErr *pgconn.PgError
+		if err != nil && !(errors.As(err, &pgErr) && pgErr.Code == "42710") { // duplicate_object
+			return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+	var exists bool
+	if err := pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1 AND slot_type = 'logical')", req.Slot).Scan(&exists); err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if !exists {
+		return respond(http.StatusNotFound, map[string]string{"error": "no logical replication slot: " + req.Slot})
+	}
+	return p.subscribe(ctx, &subscription{Slot: req.Slot})
+}
+
+func (p *Proxy) subscribe(ctx context.Context, s *subscription) *zap.Message {
+	peer := push.PeerFrom(ctx)
+	if peer == "" {
+		return respond(http.StatusBadRequest, map[string]string{"error": "subscriptions need a ZAP peer"})
+	}
+	if err := p.subs.add(s, peer, auth.FromContext(ctx).Caller); err != nil {
+		return respond(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, s)
+}
+
+func (p *Proxy) unlisten(ctx context.Context, body []byte) *zap.Message {
+	var req unlistenReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if !p.subs.push.Remove(req.Subscription, auth.FromContext(ctx).Caller) {
+		return respond(http.StatusNotFound, map[string]string{"error": "subscription not found"})
+	}
+	return respond(http.StatusOK, map[string]string{"status": "unsubscribed"})
+}
+
+func (p *Proxy) subscriptions(ctx context.Context) *zap.Message {
+	list := p.subs.push.List(auth.FromContext(ctx).Caller)
+	return respond(http.StatusOK, map[string]interface{}{"subscriptions": list, "count": len(list)})
+}
@@ -0,0 +1,28 @@
+package sql
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+func TestListenConfinedCallers(t *testing.T) {
+	p := &Proxy{}
+	tests := []struct {
+		id   *auth.Identity
+		path string
+		body string
+	}{
+		{&auth.Identity{Caller: "tenant", Schemas: []string{"billing"}}, "/listen", `{"channels": ["orders"]}`},
+		{&auth.Identity{Caller: "portal", Role: "portal_user"}, "/listen", `{"channels": ["orders"]}`},
+		{&auth.Identity{Caller: "portal", Settings: map[string]string{"app.tenant_id": "1"}}, "/listen", `{"channels": ["orders"]}`},
+		{&auth.Identity{Caller: "tenant", Schemas: []string{"billing"}}, "/listen/changes", `{"slot": "s"}`},
+		{&auth.Identity{Caller: "portal", Role: "portal_user"}, "/listen/changes", `{"slot": "s"}`},
+	}
+	for _, tt := range tests {
+		if status, out := call(t, p, tt.id, tt.path, tt.body); status != http.StatusForbidden {
+			t.Errorf("%s as %+v: status %d, want %d: %v", tt.path, *tt.id, status, http.StatusForbidden, out)
+		}
+	}
+}
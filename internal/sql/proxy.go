@@ -2,56 +2,109 @@
 //
 // Accepts ZAP connections and translates to PostgreSQL wire protocol
 // via pgx. Optimized for vector operations and session management.
-// Exposes MCP-compatible tools: sql_query, sql_exec, sql_health.
+// Exposes MCP-compatible sql_* tools alongside the paths in route, and
+// runs statements with the role and settings of the caller's policy
+// rule, so row-level security applies to all traffic.
 package sql
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal"
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+	"github.com/hanzoai/zap-sidecar/internal/drain"
+	"github.com/hanzoai/zap-sidecar/internal/metrics"
+	"github.com/hanzoai/zap-sidecar/internal/push"
 )
 
 const MsgTypeSQL uint16 = 300
 
+// writePaths modify data and are rejected in read-only mode.
+// /listen/changes creates and consumes replication slots.
+var writePaths = map[string]bool{
+	"/exec":           true,
+	"/tx/exec":        true,
+	"/vector/upsert":  true,
+	"/copy_in":        true,
+	"/prepared/exec":  true,
+	"/listen/changes": true,
+}
+
 const (
-	fieldPath = 4
-	fieldBody = 12
-	respStatus  = 0
-	respBody    = 4
-	respHeaders = 8
+	fieldPath    = 4
+	fieldHeaders = 8
+	fieldBody    = 12
+	respStatus   = 0
+	respBody     = 4
+	respHeaders  = 8
 )
 
 type Config struct {
-	NodeID      string
-	Port        int
-	ServiceType string
-	DSN         string
+	DSN string
+
+	// Pool and timeout overrides; zero keeps the DSN or pgx default.
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+	ConnectTimeout  time.Duration
+	QueryTimeout    time.Duration // default per-request timeout; none if zero
+	MaxQueryTimeout time.Duration // default 5m; caps a request's timeout_ms
+	TxIdleTimeout   time.Duration // default 1m; idle transactions and cursors are rolled back
+	MaxResultBytes  int64         // default 32 MiB; larger results fail with 413
+
+	// TLS, when set, replaces the sslmode settings from the DSN.
+	TLS *tls.Config
+
+	// ReadOnly rejects every path that modifies data.
+	ReadOnly bool
+
+	// Statements preloads named statements, name to SQL.
+	Statements map[string]string
 }
 
 type Proxy struct {
-	node   *zap.Node
-	pool   *pgxpool.Pool
-	logger *slog.Logger
+	node *zap.Node
+	// pool and the limits are swapped by Reload.
+	pool            atomic.Pointer[pgxpool.Pool]
+	queryTimeout    atomic.Int64
+	maxQueryTimeout atomic.Int64
+	maxResult       atomic.Int64
+	readOnly        atomic.Bool
+	typeNames       sync.Map // OID -> type name, for types pgx does not know
+	txs             *txRegistry
+	cursors         *txRegistry // read-only transactions holding an open cursor
+	stmts           stmtRegistry
+	subs            *hub
+	running         sync.Map // request_id -> *inflight
+	logger          *slog.Logger
 }
 
 func New(ctx context.Context, logger *slog.Logger, cfg Config) (*Proxy, error) {
+	pcfg, err := poolConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	var pool *pgxpool.Pool
-	var err error
 	for i := 0; i < 30; i++ {
-		pool, err = pgxpool.New(ctx, cfg.DSN)
+		pool, err = connect(ctx, pcfg)
 		if err == nil {
-			if pingErr := pool.Ping(ctx); pingErr == nil {
-				break
-			} else {
-				pool.Close()
-				err = pingErr
-			}
+			break
 		}
 		if i == 29 {
 			return nil, fmt.Errorf("sql: connect failed after retries: %w", err)
@@ -60,50 +113,252 @@ func New(ctx context.Context, logger *slog.Logger, cfg Config) (*Proxy, error) {
 		time.Sleep(2 * time.Second)
 	}
 
-	p := &Proxy{pool: pool, logger: logger}
+	stmts, err := loadStatements(ctx, pool, cfg.Statements)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
 
-	node := zap.NewNode(zap.NodeConfig{
-		NodeID:      cfg.NodeID,
-		ServiceType: cfg.ServiceType,
-		Port:        cfg.Port,
-		Logger:      logger,
-	})
+	p := &Proxy{
+		txs:     newTxRegistry(logger, cfg.TxIdleTimeout),
+		cursors: newTxRegistry(logger, cfg.TxIdleTimeout),
+		logger:  logger,
+	}
+	p.subs = newHub(p)
+	p.pool.Store(pool)
+	p.stmts.setConfig(stmts)
+	p.queryTimeout.Store(int64(cfg.QueryTimeout))
+	p.setMaxQueryTimeout(cfg.MaxQueryTimeout)
+	p.setMaxResult(cfg.MaxResultBytes)
+	p.readOnly.Store(cfg.ReadOnly)
+	metrics.OnScrape(p.poolStats)
 
-	node.Handle(MsgTypeSQL, func(_ context.Context, _ string, msg *zap.Message) (*zap.Message, error) {
-		return p.handle(ctx, msg), nil
-	})
+	logger.Info("sql sidecar ready")
+	return p, nil
+}
 
-	if err := node.Start(); err != nil {
+// Reload connects a new pool from cfg and swaps it in. The old pool is
+// closed once its in-flight connections have been released.
+func (p *Proxy) Reload(ctx context.Context, cfg Config) error {
+	pcfg, err := poolConfig(cfg)
+	if err != nil {
+		return err
+	}
+	pool, err := connect(ctx, pcfg)
+	if err != nil {
+		return fmt.Errorf("sql: reload: %w", err)
+	}
+	stmts, err := loadStatements(ctx, pool, cfg.Statements)
+	if err != nil {
 		pool.Close()
-		return nil, fmt.Errorf("sql: node start failed: %w", err)
+		return fmt.Errorf("sql: reload: %w", err)
+	}
+	old := p.pool.Swap(pool)
+	p.stmts.setConfig(stmts)
+	p.subs.reconnect()
+	p.queryTimeout.Store(int64(cfg.QueryTimeout))
+	p.setMaxQueryTimeout(cfg.MaxQueryTimeout)
+	p.setMaxResult(cfg.MaxResultBytes)
+	p.readOnly.Store(cfg.ReadOnly)
+	p.txs.setIdleTimeout(cfg.TxIdleTimeout)
+	p.cursors.setIdleTimeout(cfg.TxIdleTimeout)
+	p.typeNames.Clear() // the new database may number extension types differently
+	go old.Close()
+	return nil
+}
+
+func (p *Proxy) setMaxResult(n int64) {
+	if n <= 0 {
+		n = defaultMaxResultBytes
 	}
+	p.maxResult.Store(n)
+}
 
+func connect(ctx context.Context, pcfg *pgxpool.Config) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.NewWithConfig(ctx, pcfg.Copy())
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+func poolConfig(cfg Config) (*pgxpool.Config, error) {
+	pcfg, err := pgxpool.ParseConfig(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("sql: invalid DSN: %w", err)
+	}
+	if cfg.MaxConns > 0 {
+		pcfg.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		pcfg.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		pcfg.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		pcfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.ConnectTimeout > 0 {
+		pcfg.ConnConfig.ConnectTimeout = cfg.ConnectTimeout
+	}
+	if cfg.TLS != nil {
+		pcfg.ConnConfig.TLSConfig = cfg.TLS
+		pcfg.ConnConfig.Fallbacks = nil
+	}
+	pcfg.ConnConfig.BuildContextWatcherHandler = cancelOnDone
+	pcfg.ConnConfig.Tracer = tracer{}
+	return pcfg, nil
+}
+
+// Register installs the proxy's handler for MsgTypeSQL on node.
+func (p *Proxy) Register(ctx context.Context, node *zap.Node) {
 	p.node = node
-	logger.Info("sql sidecar ready")
-	return p, nil
+	node.Handle(MsgTypeSQL, func(_ context.Context, peer string, msg *zap.Message) (*zap.Message, error) {
+		return p.handle(ctx, peer, msg), nil
+	})
 }
 
+// Stop ends subscriptions, rolls back open transactions and closes the
+// backend connection.
+// The node is owned by the caller.
 func (p *Proxy) Stop() {
-	if p.node != nil {
-		p.node.Stop()
+	p.subs.close()
+	p.txs.close()
+	p.cursors.close()
+	if pool := p.pool.Load(); pool != nil {
+		pool.Close()
 	}
-	if p.pool != nil {
-		p.pool.Close()
+}
+
+// Ready reports whether the backend answers the /health check.
+func (p *Proxy) Ready(ctx context.Context) error {
+	if status, body := unpack(p.health(ctx)); status != http.StatusOK {
+		return fmt.Errorf("sql: %s", body)
 	}
+	return nil
+}
+
+func (p *Proxy) poolStats() {
+	st := p.pool.Load().Stat()
+	metrics.PoolConns.Set(float64(st.TotalConns()), "sql", "total")
+	metrics.PoolConns.Set(float64(st.IdleConns()), "sql", "idle")
+	metrics.PoolConns.Set(float64(st.AcquiredConns()), "sql", "acquired")
+	metrics.PoolConns.Set(float64(st.ConstructingConns()), "sql", "constructing")
+	metrics.PoolConns.Set(float64(st.MaxConns()), "sql", "max")
+	metrics.PoolAcquires.Mirror(float64(st.AcquireCount()), "sql", "acquired")
+	metrics.PoolAcquires.Mirror(float64(st.CanceledAcquireCount()), "sql", "canceled")
+	metrics.PoolAcquires.Mirror(float64(st.EmptyAcquireCount()), "sql", "waited")
+	metrics.PoolAcquireSeconds.Mirror(st.AcquireDuration().Seconds(), "sql")
 }
 
-func (p *Proxy) handle(ctx context.Context, msg *zap.Message) *zap.Message {
+func (p *Proxy) handle(ctx context.Context, peer string, msg *zap.Message) *zap.Message {
 	root := msg.Root()
 	path := root.Text(fieldPath)
+	done := metrics.Start("sql", path)
+	if !drain.Enter() {
+		done(http.StatusServiceUnavailable)
+		return respond(http.StatusServiceUnavailable, map[string]string{"error": drain.ErrDraining.Error()})
+	}
+	defer drain.Leave()
+
+	id, err := auth.Authenticate(root.Bytes(fieldHeaders))
+	if err != nil {
+		done(http.StatusUnauthorized)
+		return respond(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	ctx = push.WithPeer(auth.WithIdentity(ctx, id), peer)
+
 	body := root.Bytes(fieldBody)
+	qctx, release, resp := p.requestContext(ctx, path, body)
+	if resp != nil {
+		status, _ := unpack(resp)
+		done(status)
+		return resp
+	}
+	defer release()
+	resp = p.route(qctx, path, body)
+	if ctx.Err() != nil {
+		resp = respond(http.StatusServiceUnavailable, map[string]string{"error": drain.ErrAborted.Error()})
+	}
+	status, _ := unpack(resp)
+	done(status)
+	return resp
+}
 
+func (p *Proxy) route(ctx context.Context, path string, body []byte) *zap.Message {
+	// /tools/call is authorized on the path of the tool it dispatches to.
+	if path != "/tools/call" {
+		if err := auth.Authorize(ctx, "sql", path); err != nil {
+			return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+	if writePaths[path] && p.readOnly.Load() {
+		return respond(http.StatusForbidden, map[string]string{"error": "read-only mode: " + path + " is disabled"})
+	}
 	switch path {
 	case "/query":
 		return p.query(ctx, body)
 	case "/exec":
 		return p.exec(ctx, body)
+	case "/query/next":
+		return p.cursorNext(ctx, body)
+	case "/query/close":
+		return p.cursorClose(ctx, body)
+	case "/batch":
+		return p.batch(ctx, body)
+	case "/cancel":
+		return p.cancelRequest(ctx, body)
+	case "/listen":
+		return p.listenChannels(ctx, body)
+	case "/listen/changes":
+		return p.listenChanges(ctx, body)
+	case "/unlisten":
+		return p.unlisten(ctx, body)
+	case "/subscriptions":
+		return p.subscriptions(ctx)
+	case "/prepare":
+		return p.prepare(ctx, body)
+	case "/prepared/query":
+		return p.runPrepared(ctx, body, false)
+	case "/prepared/exec":
+		return p.runPrepared(ctx, body, true)
+	case "/prepared/list":
+		return p.listPrepared(ctx)
+	case "/prepared/delete":
+		return p.deallocate(ctx, body)
+	case "/copy_in":
+		return p.copyIn(ctx, body)
+	case "/copy_out":
+		return p.copyOut(ctx, body)
+	case "/vector/search":
+		return p.vectorSearch(ctx, body)
+	case "/vector/upsert":
+		return p.vectorUpsert(ctx, body)
+	case "/tx/begin":
+		return p.txBegin(ctx, body)
+	case "/tx/query":
+		return p.txStatement(ctx, body, false)
+	case "/tx/exec":
+		return p.txStatement(ctx, body, true)
+	case "/tx/commit":
+		return p.txEnd(ctx, body, true)
+	case "/tx/rollback":
+		return p.txEnd(ctx, body, false)
 	case "/health":
 		return p.health(ctx)
+	case "/tools/list":
+		return respond(http.StatusOK, map[string]interface{}{"tools": internal.SQLTools})
+	case "/tools/call":
+		return p.callTool(ctx, body)
+	case "/resources/list":
+		return respond(http.StatusOK, map[string]interface{}{"resources": internal.SQLResources})
+	case "/resources/read":
+		return p.readResource(ctx, body)
 	default:
 		if len(body) > 0 {
 			return p.query(ctx, body)
@@ -115,47 +370,137 @@ func (p *Proxy) handle(ctx context.Context, msg *zap.Message) *zap.Message {
 type sqlReq struct {
 	SQL  string        `json:"sql"`
 	Args []interface{} `json:"args,omitempty"`
+	// Format lays out query results: objects (default), arrays or columnar.
+	// Objects are keyed by column name, so a result repeating a name is
+	// refused with 400 unless the columns are aliased or another format
+	// is used.
+	Format string `json:"format,omitempty"`
+	// MaxRows limits the rows returned. On /query the rest stay in a
+	// server-side cursor whose token fetches them from /query/next.
+	MaxRows int `json:"max_rows,omitempty"`
 }
 
-func (p *Proxy) query(ctx context.Context, body []byte) *zap.Message {
+// querier runs statements on the pool or inside a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// execOne runs a single statement over the extended protocol. pgx sends an
+// Exec without arguments over the simple protocol, whatever its exec mode,
+// and that runs every statement in the string: "SELECT 1; COMMIT; DROP
+// TABLE t" would end the transaction it was meant to run in. Query takes
+// the extended protocol, which refuses more than one statement.
+func execOne(ctx context.Context, q querier, sql string, args []interface{}) (pgconn.CommandTag, error) {
+	rows, err := q.Query(ctx, sql, append([]interface{}{pgx.QueryExecModeDescribeExec}, args...)...)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	rows.Close()
+	return rows.CommandTag(), rows.Err()
+}
+
+func parseSQLReq(body []byte) sqlReq {
 	var req sqlReq
 	if err := json.Unmarshal(body, &req); err != nil {
 		req.SQL = string(body)
 	}
+	return req
+}
+
+func (p *Proxy) query(ctx context.Context, body []byte) *zap.Message {
+	return p.readQuery(ctx, parseSQLReq(body))
+}
 
-	rows, err := p.pool.Query(ctx, req.SQL, req.Args...)
+// readQuery runs req in a read-only transaction, paging through a
+// cursor when req.MaxRows is set.
+func (p *Proxy) readQuery(ctx context.Context, req sqlReq) *zap.Message {
+	if req.MaxRows > 0 {
+		return p.openCursor(ctx, req)
+	}
+	// A READ ONLY transaction makes Postgres refuse writes, including
+	// data-modifying CTEs and DELETE ... RETURNING. Nothing is ever
+	// committed, so the deferred rollback just ends it.
+	tx, err := p.beginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
 	if err != nil {
 		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
-	defer rows.Close()
+	defer tx.Rollback(ctx)
+	return keepSession(ctx, tx, p.runQuery(ctx, tx, req))
+}
+
+func (p *Proxy) exec(ctx context.Context, body []byte) *zap.Message {
+	return p.writeExec(ctx, parseSQLReq(body))
+}
 
-	descs := rows.FieldDescriptions()
-	var results []map[string]interface{}
-	for rows.Next() {
-		vals, err := rows.Values()
+// writeExec runs req on a pool connection or, when the request has a
+// session, in a transaction carrying it.
+func (p *Proxy) writeExec(ctx context.Context, req sqlReq) *zap.Message {
+	if sessionFrom(ctx) == nil {
+		conn, release, err := p.acquireConn(ctx)
 		if err != nil {
 			return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		}
-		row := make(map[string]interface{}, len(descs))
-		for i, d := range descs {
-			row[string(d.Name)] = vals[i]
+		defer release()
+		return p.runExec(ctx, conn, req)
+	}
+	tx, err := p.beginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	defer tx.Rollback(ctx)
+	resp := keepSession(ctx, tx, p.runExec(ctx, tx, req))
+	if status, _ := unpack(resp); status != http.StatusOK {
+		return resp
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return resp
+}
+
+func (p *Proxy) runQuery(ctx context.Context, q querier, req sqlReq) *zap.Message {
+	if msg := checkReq(req); msg != nil {
+		return msg
+	}
+	if schemas := auth.FromContext(ctx).Schemas; len(schemas) > 0 {
+		if err := checkSchemas(ctx, q, req.SQL, req.Args, schemas); err != nil {
+			return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
 		}
-		results = append(results, row)
+	}
+	if err := checkSessionStatement(ctx, q, req.SQL, req.Args); err != nil {
+		return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
 	}
 
-	return respond(http.StatusOK, map[string]interface{}{
-		"rows":  results,
-		"count": len(results),
-	})
+	rows, err := q.Query(ctx, req.SQL, req.Args...)
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	rs, err := readRows(rows, p.limits(req.MaxRows))
+	if err == nil {
+		err = rs.checkFormat(req.Format)
+	}
+	if err == nil {
+		err = p.resolveTypes(ctx, q, rs)
+	}
+	if err != nil {
+		return queryError(err)
+	}
+	return respond(http.StatusOK, rs.render(req.Format))
 }
 
-func (p *Proxy) exec(ctx context.Context, body []byte) *zap.Message {
-	var req sqlReq
-	if err := json.Unmarshal(body, &req); err != nil {
-		req.SQL = string(body)
+func (p *Proxy) runExec(ctx context.Context, q querier, req sqlReq) *zap.Message {
+	if schemas := auth.FromContext(ctx).Schemas; len(schemas) > 0 {
+		if err := checkSchemas(ctx, q, req.SQL, req.Args, schemas); err != nil {
+			return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+	if err := checkSessionStatement(ctx, q, req.SQL, req.Args); err != nil {
+		return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
 	}
 
-	tag, err := p.pool.Exec(ctx, req.SQL, req.Args...)
+	tag, err := q.Exec(ctx, req.SQL, req.Args...)
 	if err != nil {
 		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -166,20 +511,71 @@ func (p *Proxy) exec(ctx context.Context, body []byte) *zap.Message {
 }
 
 func (p *Proxy) health(ctx context.Context) *zap.Message {
-	if err := p.pool.Ping(ctx); err != nil {
+	if err := p.pool.Load().Ping(ctx); err != nil {
 		return respond(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
 	}
 	return respond(http.StatusOK, map[string]string{"status": "ok", "service": "hanzo-sql"})
 }
 
+func (p *Proxy) callTool(ctx context.Context, body []byte) *zap.Message {
+	var call internal.ToolCall
+	if err := json.Unmarshal(body, &call); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	tool, ok := internal.FindTool(internal.SQLTools, call.Name)
+	if !ok {
+		return respond(http.StatusNotFound, map[string]string{"error": "unknown tool: " + call.Name})
+	}
+	status, out := unpack(p.route(ctx, tool.Path, call.Arguments))
+	return respond(http.StatusOK, internal.NewToolResult(status, out))
+}
+
+func (p *Proxy) readResource(ctx context.Context, body []byte) *zap.Message {
+	var req internal.ResourceRead
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	res, ok := internal.FindResource(internal.SQLResources, req.URI)
+	if !ok {
+		return respond(http.StatusNotFound, map[string]string{"error": "unknown resource: " + req.URI})
+	}
+
+	data, err := p.schema(ctx)
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if schemas := auth.FromContext(ctx).Schemas; len(schemas) > 0 {
+		data = slices.DeleteFunc(data, func(t *tableSchema) bool {
+			return !slices.Contains(schemas, t.Schema)
+		})
+	}
+	contents, err := internal.JSONContents(res, data)
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, map[string]interface{}{"contents": contents})
+}
+
 func respond(status int, data interface{}) *zap.Message {
-	b := zap.NewBuilder(4096)
+	body, _ := json.Marshal(data)
+	return respondRaw(status, "application/json", body)
+}
+
+// respondRaw builds a response carrying body as is.
+func respondRaw(status int, contentType string, body []byte) *zap.Message {
+	headers, _ := json.Marshal(map[string][]string{"Content-Type": {contentType}})
+	b := zap.NewBuilder(len(body) + 256)
 	ob := b.StartObject(12)
 	ob.SetUint32(respStatus, uint32(status))
-	body, _ := json.Marshal(data)
 	ob.SetBytes(respBody, body)
-	ob.SetBytes(respHeaders, []byte(`{"Content-Type":["application/json"]}`))
+	ob.SetBytes(respHeaders, headers)
 	ob.FinishAsRoot()
 	msg, _ := zap.Parse(b.Finish())
 	return msg
 }
+
+// unpack returns the status and body of a response built by respond.
+func unpack(msg *zap.Message) (int, []byte) {
+	root := msg.Root()
+	return int(root.Uint32(respStatus)), root.Bytes(respBody)
+}
@@ -0,0 +1,65 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// testProxy connects to the database in ZAP_SIDECAR_TEST_DSN, skipping
+// the test when it is not set.
+func testProxy(t *testing.T, cfg Config) *Proxy {
+	t.Helper()
+	cfg.DSN = os.Getenv("ZAP_SIDECAR_TEST_DSN")
+	if cfg.DSN == "" {
+		t.Skip("ZAP_SIDECAR_TEST_DSN not set")
+	}
+	p, err := New(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Stop)
+	return p
+}
+
+// call runs a request as id, as handle does once the caller is
+// authenticated, and returns its status and decoded JSON body.
+func call(t *testing.T, p *Proxy, id *auth.Identity, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	ctx := context.Background()
+	if id != nil {
+		ctx = auth.WithIdentity(ctx, id)
+	}
+	qctx, release, resp := p.requestContext(ctx, path, []byte(body))
+	if resp == nil {
+		defer release()
+		resp = p.route(qctx, path, []byte(body))
+	}
+	status, data := unpack(resp)
+	var out map[string]interface{}
+	json.Unmarshal(data, &out)
+	return status, out
+}
+
+// mustExec runs setup SQL directly on the pool.
+func mustExec(t *testing.T, p *Proxy, sql string) {
+	t.Helper()
+	if _, err := p.pool.Load().Exec(context.Background(), sql); err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+}
+
+// relationExists reports whether name resolves to a relation.
+func relationExists(t *testing.T, p *Proxy, name string) bool {
+	t.Helper()
+	var ok bool
+	if err := p.pool.Load().QueryRow(context.Background(), "SELECT to_regclass($1) IS NOT NULL", name).Scan(&ok); err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
@@ -0,0 +1,398 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// tableSchema describes one relation in the hanzo://sql/schema resource.
+type tableSchema struct {
+	Schema      string         `json:"schema"`
+	Name        string         `json:"name"`
+	Kind        string         `json:"kind"`
+	Columns     []columnSchema `json:"columns"`
+	Indexes     []indexSchema  `json:"indexes,omitempty"`
+	ForeignKeys []foreignKey   `json:"foreign_keys,omitempty"`
+}
+
+type columnSchema struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Nullable bool    `json:"nullable"`
+	Default  *string `json:"default,omitempty"`
+}
+
+type indexSchema struct {
+	Name       string `json:"name"`
+	Unique     bool   `json:"unique"`
+	Primary    bool   `json:"primary"`
+	Definition string `json:"definition"`
+}
+
+type foreignKey struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	RefSchema  string   `json:"ref_schema"`
+	RefTable   string   `json:"ref_table"`
+	RefColumns []string `json:"ref_columns"`
+	Definition string   `json:"definition"`
+}
+
+var relKinds = map[string]string{
+	"r": "table",
+	"p": "partitioned table",
+	"v": "view",
+	"m": "materialized view",
+	"f": "foreign table",
+}
+
+const userSchemas = `n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg_toast%'`
+
+const schemaColumnsSQL = `
+SELECT n.nspname, c.relname, c.relkind::text, a.attname,
+       format_type(a.atttypid, a.atttypmod), NOT a.attnotnull,
+       pg_get_expr(d.adbin, d.adrelid)
+FROM pg_attribute a
+JOIN pg_class c ON c.oid = a.attrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f') AND a.attnum > 0 AND NOT a.attisdropped
+  AND ` + userSchemas + `
+ORDER BY n.nspname, c.relname, a.attnum`
+
+const schemaIndexesSQL = `
+SELECT n.nspname, t.relname, i.relname, ix.indisunique, ix.indisprimary,
+       pg_get_indexdef(ix.indexrelid)
+FROM pg_index ix
+JOIN pg_class i ON i.oid = ix.indexrelid
+JOIN pg_class t ON t.oid = ix.indrelid
+JOIN pg_namespace n ON n.oid = t.relnamespace
+WHERE ` + userSchemas + `
+ORDER BY n.nspname, t.relname, i.relname`
+
+const schemaForeignKeysSQL = `
+SELECT n.nspname, t.relname, con.conname,
+       ARRAY(SELECT a.attname::text FROM unnest(con.conkey) WITH ORDINALITY k(attnum, ord)
+             JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum ORDER BY k.ord),
+       rn.nspname, rt.relname,
+       ARRAY(SELECT a.attname::text FROM unnest(con.confkey) WITH ORDINALITY k(attnum, ord)
+             JOIN pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum ORDER BY k.ord),
+       pg_get_constraintdef(con.oid)
+FROM pg_constraint con
+JOIN pg_class t ON t.oid = con.conrelid
+JOIN pg_namespace n ON n.oid = t.relnamespace
+JOIN pg_class rt ON rt.oid = con.confrelid
+JOIN pg_namespace rn ON rn.oid = rt.relnamespace
+WHERE con.contype = 'f' AND ` + userSchemas + `
+ORDER BY n.nspname, t.relname, con.conname`
+
+// schema dumps tables, columns, indexes and foreign keys of all user schemas.
+func (p *Proxy) schema(ctx context.Context) ([]*tableSchema, error) {
+	pool := p.pool.Load()
+	var tables []*tableSchema
+	byName := make(map[string]*tableSchema)
+
+	rows, err := pool.Query(ctx, schemaColumnsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("columns: %w", err)
+	}
+	for rows.Next() {
+		var nsp, rel, kind string
+		var col columnSchema
+		if err := rows.Scan(&nsp, &rel, &kind, &col.Name, &col.Type, &col.Nullable, &col.Default); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("columns: %w", err)
+		}
+		key := nsp + "." + rel
+		t := byName[key]
+		if t == nil {
+			t = &tableSchema{Schema: nsp, Name: rel, Kind: relKinds[kind]}
+			byName[key] = t
+			tables = append(tables, t)
+		}
+		t.Columns = append(t.Columns, col)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("columns: %w", err)
+	}
+
+	rows, err = pool.Query(ctx, schemaIndexesSQL)
+	if err != nil {
+		return nil, fmt.Errorf("indexes: %w", err)
+	}
+	for rows.Next() {
+		var nsp, rel string
+		var idx indexSchema
+		if err := rows.Scan(&nsp, &rel, &idx.Name, &idx.Unique, &idx.Primary, &idx.Definition); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("indexes: %w", err)
+		}
+		if t := byName[nsp+"."+rel]; t != nil {
+			t.Indexes = append(t.Indexes, idx)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("indexes: %w", err)
+	}
+
+	rows, err = pool.Query(ctx, schemaForeignKeysSQL)
+	if err != nil {
+		return nil, fmt.Errorf("foreign keys: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var nsp, rel string
+		var fk foreignKey
+		if err := rows.Scan(&nsp, &rel, &fk.Name, &fk.Columns, &fk.RefSchema, &fk.RefTable, &fk.RefColumns, &fk.Definition); err != nil {
+			return nil, fmt.Errorf("foreign keys: %w", err)
+		}
+		if t := byName[nsp+"."+rel]; t != nil {
+			t.ForeignKeys = append(t.ForeignKeys, fk)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("foreign keys: %w", err)
+	}
+
+	return tables, nil
+}
+
+// deniedFunctions are the pg_catalog functions a schema-confined caller
+// may not call: set_config changes the role and settings row-level
+// security relies on, and the others reach past the database's schemas
+// to its files and backends.
+var deniedFunctions = map[string]bool{
+	"set_config":           true,
+	"pg_read_file":         true,
+	"pg_read_binary_file":  true,
+	"pg_ls_dir":            true,
+	"pg_stat_file":         true,
+	"lo_import":            true,
+	"lo_export":            true,
+	"pg_terminate_backend": true,
+	"pg_cancel_backend":    true,
+	"pg_reload_conf":       true,
+}
+
+// checkSchemas reports an error unless every relation the statement
+// touches and every function or operator it calls lives in one of the
+// allowed schemas, or, for functions, in pg_catalog but not in
+// deniedFunctions. Postgres resolves the names itself through EXPLAIN,
+// which plans without executing, so a statement it cannot explain (DDL,
+// SET and other utility commands) is refused. Calls are read from the
+// expressions of the verbose plan, so a call the planner folds into a
+// constant, or one made inside a function's body, is not seen.
+func checkSchemas(ctx context.Context, q querier, sql string, args []interface{}, allowed []string) error {
+	doc, err := explain(ctx, q, sql, args)
+	if err != nil {
+		return fmt.Errorf("statement cannot be checked against schemas %s: %w", strings.Join(allowed, ", "), err)
+	}
+	var denied []string
+	deny := func(schema string) {
+		if !slices.Contains(allowed, schema) && !slices.Contains(denied, schema) {
+			denied = append(denied, schema)
+		}
+	}
+	walkPlan(doc, deny)
+	fns, err := resolveCalls(ctx, q, planCalls(doc))
+	if err != nil {
+		return err
+	}
+	for _, f := range fns {
+		if f.schema != "pg_catalog" {
+			deny(f.schema)
+		} else if deniedFunctions[f.name] {
+			return fmt.Errorf("statement calls %s", f.name)
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("statement uses schema %s outside %s", strings.Join(denied, ", "), strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// explain returns the verbose JSON plan of a statement.
+func explain(ctx context.Context, q querier, sql string, args []interface{}) (interface{}, error) {
+	var plan []byte
+	if err := q.QueryRow(ctx, "EXPLAIN (VERBOSE, FORMAT JSON) "+sql, args...).Scan(&plan); err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	err := json.Unmarshal(plan, &doc)
+	return doc, err
+}
+
+// walkPlan calls fn with the schema of every plan node that names one.
+func walkPlan(v interface{}, fn func(schema string)) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if schema, ok := v["Schema"].(string); ok {
+			fn(schema)
+		}
+		for _, c := range v {
+			walkPlan(c, fn)
+		}
+	case []interface{}:
+		for _, c := range v {
+			walkPlan(c, fn)
+		}
+	}
+}
+
+// funcCall is a function or operator called by a statement. The schema
+// is empty when the plan leaves the name unqualified, which it does
+// when the search path finds it; an operator has no name.
+type funcCall struct {
+	schema, name string
+}
+
+// planCalls returns the calls in the expressions of a verbose plan: every
+// string but the names of plan nodes, relations and the like.
+func planCalls(v interface{}) []funcCall {
+	var out []funcCall
+	var walk func(key string, v interface{})
+	walk = func(key string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, c := range v {
+				walk(k, c)
+			}
+		case []interface{}:
+			for _, c := range v {
+				walk(key, c)
+			}
+		case string:
+			if key != "Schema" && key != "Alias" && !strings.HasSuffix(key, "Name") {
+				out = append(out, exprCalls(v)...)
+			}
+		}
+	}
+	walk("", v)
+	return out
+}
+
+// exprCalls finds the calls in an expression as Postgres deparses it:
+// a possibly qualified name directly followed by "(", or an operator
+// qualified as OPERATOR(schema.op). String literals are skipped and
+// quoted identifiers unquoted. Keywords such as COALESCE( are returned
+// too; they resolve to no function.
+func exprCalls(expr string) []funcCall {
+	var out []funcCall
+	var parts []string // the dotted name being read
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == '\'':
+			escapes := i > 0 && (expr[i-1] == 'E' || expr[i-1] == 'e')
+			for i++; i < len(expr); i++ {
+				if escapes && expr[i] == '\\' {
+					i++
+				} else if expr[i] == '\'' {
+					if i+1 < len(expr) && expr[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			i++
+			parts = nil
+			continue
+		case c == '"':
+			var b strings.Builder
+			for i++; i < len(expr); i++ {
+				if expr[i] == '"' {
+					if i+1 < len(expr) && expr[i+1] == '"' {
+						i++
+					} else {
+						break
+					}
+				}
+				b.WriteByte(expr[i])
+			}
+			i++
+			parts = append(parts, b.String())
+		case isIdentStart(c):
+			j := i
+			for j < len(expr) && isIdentChar(expr[j]) {
+				j++
+			}
+			parts = append(parts, strings.ToLower(expr[i:j]))
+			i = j
+		default:
+			parts = nil
+			i++
+			continue
+		}
+		if i < len(expr) && expr[i] == '.' {
+			i++
+			continue
+		}
+		if i < len(expr) && expr[i] == '(' && len(parts) > 0 {
+			name := parts[len(parts)-1]
+			switch {
+			case len(parts) == 1 && name == "operator":
+				if schema, _, ok := strings.Cut(expr[i+1:], "."); ok {
+					out = append(out, funcCall{schema: strings.Trim(schema, `"`)})
+				}
+			case len(parts) == 1:
+				out = append(out, funcCall{name: name})
+			default:
+				out = append(out, funcCall{schema: parts[len(parts)-2], name: name})
+			}
+		}
+		parts = nil
+	}
+	return out
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9' || c == '$'
+}
+
+// resolveCalls qualifies the unqualified calls with the schema of every
+// visible function of that name; names that match none, such as
+// keywords, are dropped.
+func resolveCalls(ctx context.Context, q querier, calls []funcCall) ([]funcCall, error) {
+	var out []funcCall
+	var names []string
+	for _, c := range calls {
+		switch {
+		case c.schema != "":
+			out = append(out, c)
+		case !slices.Contains(names, c.name):
+			names = append(names, c.name)
+		}
+	}
+	if len(names) == 0 {
+		return out, nil
+	}
+	rows, err := q.Query(ctx, `
+SELECT DISTINCT n.nspname, p.proname FROM pg_proc p
+JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE p.proname = ANY($1) AND pg_function_is_visible(p.oid)`, names)
+	if err != nil {
+		return nil, fmt.Errorf("resolving functions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c funcCall
+		if err := rows.Scan(&c.schema, &c.name); err != nil {
+			return nil, fmt.Errorf("resolving functions: %w", err)
+		}
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("resolving functions: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,80 @@
+package sql
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+func TestExprCalls(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []funcCall
+	}{
+		{"t.x", nil},
+		{"lower(t.name)", []funcCall{{name: "lower"}}},
+		{"other.fn(t.x)", []funcCall{{schema: "other", name: "fn"}}},
+		{`"Other"."Fn"(1)`, []funcCall{{schema: "Other", name: "Fn"}}},
+		{`"we""ird".f(1)`, []funcCall{{schema: `we"ird`, name: "f"}}},
+		{"pg_catalog.set_config('role'::text, 'x'::text, true)", []funcCall{{schema: "pg_catalog", name: "set_config"}}},
+		{"'other.fn(1)'::text", nil},
+		{`E'it\'s fn(1)'::text`, nil},
+		{"'it''s fn(1)'::text", nil},
+		{"(t.x OPERATOR(other.+) 1)", []funcCall{{schema: "other"}}},
+		{"COALESCE(f(t.x), 0)", []funcCall{{name: "coalesce"}, {name: "f"}}},
+		{"(t.x)::other.mytype", nil},
+	}
+	for _, tt := range tests {
+		if got := exprCalls(tt.expr); !slices.Equal(got, tt.want) {
+			t.Errorf("exprCalls(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestPlanCallsSkipsNames(t *testing.T) {
+	plan := []interface{}{map[string]interface{}{
+		"Plan": map[string]interface{}{
+			"Node Type":     "Function Scan",
+			"Function Name": "odd(name",
+			"Alias":         "f(x",
+			"Output":        []interface{}{"other.fn(f.x)"},
+		},
+	}}
+	want := []funcCall{{schema: "other", name: "fn"}}
+	if got := planCalls(plan); !slices.Equal(got, want) {
+		t.Errorf("planCalls = %v, want %v", got, want)
+	}
+}
+
+func TestCheckSchemasFunctions(t *testing.T) {
+	p := testProxy(t, Config{})
+	mustExec(t, p, `
+DROP SCHEMA IF EXISTS zap_tenant, zap_other CASCADE;
+CREATE SCHEMA zap_tenant;
+CREATE SCHEMA zap_other;
+CREATE TABLE zap_tenant.t (x int);
+CREATE FUNCTION zap_other.leak(int) RETURNS int LANGUAGE sql VOLATILE AS 'SELECT $1';
+CREATE FUNCTION zap_tenant.ok(int) RETURNS int LANGUAGE sql VOLATILE AS 'SELECT $1'`)
+	t.Cleanup(func() { mustExec(t, p, "DROP SCHEMA zap_tenant, zap_other CASCADE") })
+
+	id := &auth.Identity{Caller: "tenant", Schemas: []string{"zap_tenant"}}
+	tests := []struct {
+		sql  string
+		want int
+	}{
+		{"SELECT zap_tenant.ok(x) FROM zap_tenant.t", http.StatusOK},
+		{"SELECT lower('A')", http.StatusOK},
+		{"SELECT zap_other.leak(x) FROM zap_tenant.t", http.StatusForbidden},
+		{"SELECT x FROM zap_tenant.t WHERE zap_other.leak(x) > 0", http.StatusForbidden},
+		{"SELECT set_config('role', 'postgres', false)", http.StatusForbidden},
+		{"SELECT pg_read_file('/etc/passwd')", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		status, out := call(t, p, id, "/query", `{"sql": "`+tt.sql+`"}`)
+		if status != tt.want {
+			t.Errorf("%s: status %d, want %d: %v", tt.sql, status, tt.want, out)
+		}
+	}
+}
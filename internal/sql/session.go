@@ -0,0 +1,181 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// A session is the role and configuration settings a request's
+// transactions run with, so that row-level security policies such as
+//
+//	USING (tenant_id = current_setting('app.tenant_id')::bigint)
+//
+// hold for every statement sent through the proxy. The caller's policy
+// rule fixes the role and some settings; a request may add the settings
+// its rule lists as settable with a "settings" object. Each is set with
+// set_config(..., true), the function form of SET LOCAL, so it ends with
+// the transaction and never outlives it on a pooled connection.
+//
+// A statement sent with a session must not change it. It must be one
+// EXPLAIN can plan, which rules out SET, RESET, SET ROLE, SET SESSION
+// AUTHORIZATION, DISCARD and transaction control, and it must not call
+// set_config or another of deniedFunctions. Functions the role may
+// execute can still change settings from within their bodies, so after
+// each statement the session is read back and the transaction rolled
+// back if it changed. Those functions should nonetheless not be granted.
+type session map[string]string
+
+type sessionKey struct{}
+
+// newSession merges the caller's settings with those of the request.
+func newSession(id *auth.Identity, req map[string]json.RawMessage) (session, *zap.Message) {
+	s := make(session, len(id.Settings)+len(req)+1)
+	for name, v := range id.Settings {
+		s[name] = v
+	}
+	if id.Role != "" {
+		s["role"] = id.Role
+	}
+	for name, v := range req {
+		if !slices.Contains(id.Settable, name) {
+			return nil, respond(http.StatusForbidden, map[string]string{"error": "setting not allowed: " + name})
+		}
+		// Numbers keep their JSON text, so large tenant IDs stay exact.
+		var str string
+		switch {
+		case json.Unmarshal(v, &str) == nil:
+			s[name] = str
+		case len(v) > 0 && strings.IndexByte("-0123456789tf", v[0]) >= 0:
+			s[name] = string(v)
+		default:
+			return nil, respond(http.StatusBadRequest, map[string]string{"error": "setting " + name + " must be a string, number or boolean"})
+		}
+	}
+	if len(s) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+func sessionFrom(ctx context.Context) session {
+	s, _ := ctx.Value(sessionKey{}).(session)
+	return s
+}
+
+// names returns the session's setting names, role first.
+func (s session) names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		if name != "role" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := s["role"]; ok {
+		names = append([]string{"role"}, names...)
+	}
+	return names
+}
+
+// applySession applies the request's session to the transaction q in one
+// round trip, setting the role before the other settings. It keeps the
+// values as Postgres reports them back, normalized ("a,b" becomes "a, b"
+// for search_path), for checkSession to compare against.
+func applySession(ctx context.Context, q querier) error {
+	s := sessionFrom(ctx)
+	if len(s) == 0 {
+		return nil
+	}
+	names := s.names()
+	calls := make([]string, len(names))
+	args := make([]interface{}, 0, 2*len(names))
+	for i, name := range names {
+		args = append(args, name, s[name])
+		calls[i] = fmt.Sprintf("set_config($%d, $%d, true)", 2*i+1, 2*i+2)
+	}
+	vals := make([]string, len(names))
+	dest := make([]interface{}, len(names))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	if err := q.QueryRow(ctx, "SELECT "+strings.Join(calls, ", "), args...).Scan(dest...); err != nil {
+		return err
+	}
+	for i, name := range names {
+		s[name] = vals[i]
+	}
+	return nil
+}
+
+// checkSessionStatement refuses a statement that could change the
+// request's session: one EXPLAIN cannot plan, which includes more than
+// one statement, or one calling a function in deniedFunctions.
+func checkSessionStatement(ctx context.Context, q querier, sql string, args []interface{}) error {
+	if sessionFrom(ctx) == nil {
+		return nil
+	}
+	doc, err := explain(ctx, q, sql, args)
+	if err != nil {
+		return fmt.Errorf("statement cannot be checked against the session: %w", err)
+	}
+	fns, err := resolveCalls(ctx, q, planCalls(doc))
+	if err != nil {
+		return err
+	}
+	for _, f := range fns {
+		if f.schema == "pg_catalog" && deniedFunctions[f.name] {
+			return fmt.Errorf("statement calls %s", f.name)
+		}
+	}
+	return nil
+}
+
+// checkSession reports an error if the role or a setting of the
+// request's session no longer has the value applySession gave it.
+func checkSession(ctx context.Context, q querier) error {
+	s := sessionFrom(ctx)
+	if len(s) == 0 {
+		return nil
+	}
+	names := s.names()
+	calls := make([]string, len(names))
+	args := make([]interface{}, len(names))
+	vals := make([]string, len(names))
+	dest := make([]interface{}, len(names))
+	for i, name := range names {
+		calls[i] = fmt.Sprintf("current_setting($%d)", i+1)
+		args[i] = name
+		dest[i] = &vals[i]
+	}
+	if err := q.QueryRow(ctx, "SELECT "+strings.Join(calls, ", "), args...).Scan(dest...); err != nil {
+		return fmt.Errorf("checking session: %w", err)
+	}
+	for i, name := range names {
+		if vals[i] != s[name] {
+			return fmt.Errorf("statement changed session setting %s", name)
+		}
+	}
+	return nil
+}
+
+// keepSession returns resp, the response of a statement run in q, unless
+// the statement succeeded but changed the request's session; then its
+// result is withheld and the caller must roll q back.
+func keepSession(ctx context.Context, q querier, resp *zap.Message) *zap.Message {
+	if status, _ := unpack(resp); status != http.StatusOK {
+		return resp
+	}
+	if err := checkSession(ctx, q); err != nil {
+		return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	return resp
+}
@@ -0,0 +1,84 @@
+package sql
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// sessionProxy sets up a role and a function that changes a setting from
+// its body, and returns a proxy and a caller running as that role.
+func sessionProxy(t *testing.T) (*Proxy, *auth.Identity) {
+	p := testProxy(t, Config{})
+	mustExec(t, p, `
+DO $$ BEGIN CREATE ROLE zap_tenant_role NOLOGIN; EXCEPTION WHEN duplicate_object THEN NULL; END $$;
+GRANT zap_tenant_role TO CURRENT_USER;
+DROP SCHEMA IF EXISTS zap_session CASCADE;
+CREATE SCHEMA zap_session;
+GRANT USAGE ON SCHEMA zap_session TO zap_tenant_role;
+CREATE FUNCTION zap_session.escape() RETURNS text LANGUAGE plpgsql AS
+  $$ BEGIN RETURN set_config('app.tenant_id', '2', true); END $$`)
+	t.Cleanup(func() { mustExec(t, p, "DROP SCHEMA zap_session CASCADE") })
+	return p, &auth.Identity{Caller: "tenant", Role: "zap_tenant_role", Settable: []string{"app.tenant_id"}}
+}
+
+func sessionBody(t *testing.T, fields map[string]interface{}) string {
+	t.Helper()
+	fields["settings"] = map[string]interface{}{"app.tenant_id": 1}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestSessionStatementsRefused(t *testing.T) {
+	p, id := sessionProxy(t)
+	for _, sql := range []string{
+		"RESET ROLE",
+		"SET ROLE postgres",
+		"SET SESSION AUTHORIZATION postgres",
+		"SET app.tenant_id = 2",
+		"RESET ALL",
+		"DISCARD ALL",
+		"COMMIT",
+		"SELECT set_config('app.tenant_id', '2', false)",
+		"SELECT pg_catalog.set_config('role', 'none', true)",
+		"SELECT 1; RESET ROLE",
+		"SELECT zap_session.escape()",
+	} {
+		for _, path := range []string{"/query", "/exec"} {
+			status, out := call(t, p, id, path, sessionBody(t, map[string]interface{}{"sql": sql}))
+			if status != http.StatusForbidden {
+				t.Errorf("%s %s: status %d, want %d: %v", path, sql, status, http.StatusForbidden, out)
+			}
+		}
+	}
+
+	status, out := call(t, p, id, "/query", sessionBody(t, map[string]interface{}{
+		"sql": "SELECT current_user::text AS role, current_setting('app.tenant_id') AS tenant",
+	}))
+	if status != http.StatusOK {
+		t.Fatalf("session query: status %d: %v", status, out)
+	}
+}
+
+func TestSessionChangedInTxRollsBack(t *testing.T) {
+	p, id := sessionProxy(t)
+	status, out := call(t, p, id, "/tx/begin", sessionBody(t, map[string]interface{}{}))
+	if status != http.StatusOK {
+		t.Fatalf("begin: status %d: %v", status, out)
+	}
+	tx, _ := out["tx"].(string)
+
+	status, out = call(t, p, id, "/tx/query", `{"tx": "`+tx+`", "sql": "SELECT zap_session.escape()"}`)
+	if status != http.StatusForbidden {
+		t.Errorf("escape: status %d, want %d: %v", status, http.StatusForbidden, out)
+	}
+	status, out = call(t, p, id, "/tx/query", `{"tx": "`+tx+`", "sql": "SELECT 1"}`)
+	if status != http.StatusNotFound {
+		t.Errorf("after escape: status %d, want the transaction rolled back: %v", status, out)
+	}
+}
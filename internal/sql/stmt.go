@@ -0,0 +1,196 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// statement is a named SQL statement that callers run by name. Each pool
+// connection prepares it once, through pgx's statement cache, and reuses
+// the plan on every later call.
+type statement struct {
+	Name   string   `json:"name"`
+	SQL    string   `json:"sql"`
+	Params []string `json:"params"`          // parameter type names
+	Source string   `json:"source"`          // "config" or "request"
+	Owner  string   `json:"owner,omitempty"` // caller that registered it
+}
+
+// stmtRegistry holds the named statements, preloaded from config or
+// registered with /prepare.
+type stmtRegistry struct {
+	mu    sync.RWMutex
+	stmts map[string]*statement
+}
+
+func (r *stmtRegistry) get(name string) (*statement, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.stmts[name]
+	return s, ok
+}
+
+// setConfig replaces the preloaded statements, leaving registered ones
+// alone unless a preloaded statement takes their name.
+func (r *stmtRegistry) setConfig(stmts []*statement) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stmts == nil {
+		r.stmts = make(map[string]*statement)
+	}
+	for name, s := range r.stmts {
+		if s.Source == "config" {
+			delete(r.stmts, name)
+		}
+	}
+	for _, s := range stmts {
+		r.stmts[s.Name] = s
+	}
+}
+
+// describe prepares sql as an unnamed statement to check it and learn its
+// parameter types, without touching the connection's statement caches.
+func describe(ctx context.Context, pool *pgxpool.Pool, name, sql string) (*statement, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	sd, err := conn.Conn().PgConn().Prepare(ctx, "", sql, nil)
+	if err != nil {
+		return nil, err
+	}
+	m := conn.Conn().TypeMap()
+	s := &statement{Name: name, SQL: sql, Params: make([]string, len(sd.ParamOIDs))}
+	for i, oid := range sd.ParamOIDs {
+		if t, ok := m.TypeForOID(oid); ok {
+			s.Params[i] = t.Name
+		} else {
+			s.Params[i] = strconv.FormatUint(uint64(oid), 10)
+		}
+	}
+	return s, nil
+}
+
+// loadStatements describes the preloaded statements against pool.
+func loadStatements(ctx context.Context, pool *pgxpool.Pool, stmts map[string]string) ([]*statement, error) {
+	out := make([]*statement, 0, len(stmts))
+	for name, sql := range stmts {
+		s, err := describe(ctx, pool, name, sql)
+		if err != nil {
+			return nil, fmt.Errorf("sql: statement %s: %w", name, err)
+		}
+		s.Source = "config"
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// ================================================================
+// Handlers
+// ================================================================
+
+type prepareReq struct {
+	Name string `json:"name"`
+	SQL  string `json:"sql"`
+}
+
+type preparedReq struct {
+	Name    string        `json:"name"`
+	Args    []interface{} `json:"args,omitempty"`
+	Format  string        `json:"format,omitempty"`
+	MaxRows int           `json:"max_rows,omitempty"`
+}
+
+// prepare registers a named statement. A caller may replace or delete
+// only the statements it registered; preloaded ones are fixed.
+func (p *Proxy) prepare(ctx context.Context, body []byte) *zap.Message {
+	var req prepareReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Name == "" || req.SQL == "" {
+		return respond(http.StatusBadRequest, map[string]string{"error": "name and sql required"})
+	}
+	caller := auth.FromContext(ctx).Caller
+	if old, ok := p.stmts.get(req.Name); ok && (old.Source == "config" || old.Owner != caller) {
+		return respond(http.StatusConflict, map[string]string{"error": "statement exists: " + req.Name})
+	}
+
+	s, err := describe(ctx, p.pool.Load(), req.Name, req.SQL)
+	if err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	s.Source, s.Owner = "request", caller
+
+	p.stmts.mu.Lock()
+	if old, ok := p.stmts.stmts[req.Name]; ok && (old.Source == "config" || old.Owner != caller) {
+		p.stmts.mu.Unlock()
+		return respond(http.StatusConflict, map[string]string{"error": "statement exists: " + req.Name})
+	}
+	p.stmts.stmts[req.Name] = s
+	p.stmts.mu.Unlock()
+	return respond(http.StatusOK, s)
+}
+
+// runPrepared runs a named statement as /query or, with exec, as /exec.
+func (p *Proxy) runPrepared(ctx context.Context, body []byte, exec bool) *zap.Message {
+	var req preparedReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	s, ok := p.stmts.get(req.Name)
+	if !ok {
+		return respond(http.StatusNotFound, map[string]string{"error": "unknown statement: " + req.Name})
+	}
+	if !auth.FromContext(ctx).MayRun(req.Name, s.Source == "config") {
+		return respond(http.StatusForbidden, map[string]string{"error": "statement not allowed: " + req.Name})
+	}
+	sreq := sqlReq{SQL: s.SQL, Args: req.Args, Format: req.Format, MaxRows: req.MaxRows}
+	if exec {
+		return p.writeExec(ctx, sreq)
+	}
+	return p.readQuery(ctx, sreq)
+}
+
+// listPrepared returns the statements the caller may run.
+func (p *Proxy) listPrepared(ctx context.Context) *zap.Message {
+	id := auth.FromContext(ctx)
+	p.stmts.mu.RLock()
+	list := make([]*statement, 0, len(p.stmts.stmts))
+	for name, s := range p.stmts.stmts {
+		if id.MayRun(name, s.Source == "config") {
+			list = append(list, s)
+		}
+	}
+	p.stmts.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return respond(http.StatusOK, map[string]interface{}{"statements": list, "count": len(list)})
+}
+
+// deallocate removes a statement registered by the caller.
+func (p *Proxy) deallocate(ctx context.Context, body []byte) *zap.Message {
+	var req prepareReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	caller := auth.FromContext(ctx).Caller
+	p.stmts.mu.Lock()
+	defer p.stmts.mu.Unlock()
+	s, ok := p.stmts.stmts[req.Name]
+	if !ok || s.Source != "request" || s.Owner != caller {
+		return respond(http.StatusNotFound, map[string]string{"error": "unknown statement: " + req.Name})
+	}
+	delete(p.stmts.stmts, req.Name)
+	return respond(http.StatusOK, map[string]string{"status": "deallocated"})
+}
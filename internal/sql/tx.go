@@ -0,0 +1,282 @@
+package sql
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+// defaultTxIdleTimeout is how long a transaction may sit between
+// statements before it is rolled back.
+const defaultTxIdleTimeout = time.Minute
+
+var errTxNotFound = errors.New("transaction not found or expired")
+
+// txn is a transaction spanning several ZAP requests. It holds one pool
+// connection from /tx/begin until /tx/commit, /tx/rollback or the idle
+// timeout.
+type txn struct {
+	mu      sync.Mutex // serializes statements; a pgx connection is not concurrent
+	tx      pgx.Tx
+	owner   string
+	lastUse atomic.Int64 // unix nanos
+	done    bool         // guarded by mu
+	page    int          // rows per FETCH, for cursors
+	session session      // applied at begin; statements must keep it
+}
+
+// txRegistry holds the open transactions by handle.
+type txRegistry struct {
+	mu     sync.Mutex
+	txs    map[string]*txn
+	idle   atomic.Int64 // time.Duration
+	stop   chan struct{}
+	closed sync.Once
+	logger *slog.Logger
+}
+
+func newTxRegistry(logger *slog.Logger, idle time.Duration) *txRegistry {
+	r := &txRegistry{txs: make(map[string]*txn), stop: make(chan struct{}), logger: logger}
+	r.setIdleTimeout(idle)
+	go r.reap()
+	return r
+}
+
+func (r *txRegistry) setIdleTimeout(d time.Duration) {
+	if d <= 0 {
+		d = defaultTxIdleTimeout
+	}
+	r.idle.Store(int64(d))
+}
+
+func (r *txRegistry) add(tx pgx.Tx, owner string, page int, s session) string {
+	var b [16]byte
+	rand.Read(b[:])
+	id := hex.EncodeToString(b[:])
+
+	t := &txn{tx: tx, owner: owner, page: page, session: s}
+	t.lastUse.Store(time.Now().UnixNano())
+	r.mu.Lock()
+	r.txs[id] = t
+	r.mu.Unlock()
+	return id
+}
+
+// acquire locks the transaction id for one statement by owner. The
+// caller must call release.
+func (r *txRegistry) acquire(id, owner string) (*txn, error) {
+	r.mu.Lock()
+	t, ok := r.txs[id]
+	r.mu.Unlock()
+	if !ok || t.owner != owner {
+		return nil, errTxNotFound
+	}
+	t.mu.Lock()
+	if t.done {
+		t.mu.Unlock()
+		return nil, errTxNotFound
+	}
+	return t, nil
+}
+
+func (r *txRegistry) release(t *txn) {
+	t.lastUse.Store(time.Now().UnixNano())
+	t.mu.Unlock()
+}
+
+// finish removes an acquired transaction; the caller commits or rolls
+// it back and then calls release.
+func (r *txRegistry) finish(id string, t *txn) {
+	t.done = true
+	r.mu.Lock()
+	delete(r.txs, id)
+	r.mu.Unlock()
+}
+
+// reap rolls back transactions idle for longer than the idle timeout,
+// returning their connections to the pool.
+func (r *txRegistry) reap() {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-tick.C:
+		}
+		cutoff := time.Now().Add(-time.Duration(r.idle.Load())).UnixNano()
+		r.mu.Lock()
+		for id, t := range r.txs {
+			// A transaction running a statement is not idle.
+			if t.lastUse.Load() > cutoff || !t.mu.TryLock() {
+				continue
+			}
+			delete(r.txs, id)
+			t.done = true
+			go func() {
+				defer t.mu.Unlock()
+				r.rollback(t)
+				r.logger.Warn("sql: idle transaction rolled back", "tx", id, "owner", t.owner)
+			}()
+		}
+		r.mu.Unlock()
+	}
+}
+
+// close stops the reaper and rolls back every open transaction, waiting
+// for running statements to finish first. It may be called more than
+// once.
+func (r *txRegistry) close() {
+	r.closed.Do(func() { close(r.stop) })
+	r.mu.Lock()
+	txs := r.txs
+	r.txs = make(map[string]*txn)
+	r.mu.Unlock()
+	for _, t := range txs {
+		t.mu.Lock()
+		if !t.done {
+			t.done = true
+			r.rollback(t)
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (r *txRegistry) rollback(t *txn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = t.tx.Rollback(ctx)
+}
+
+// ================================================================
+// Handlers
+// ================================================================
+
+type txBeginReq struct {
+	Isolation  string `json:"isolation,omitempty"` // read committed (default), repeatable read, serializable
+	ReadOnly   bool   `json:"read_only,omitempty"`
+	Deferrable bool   `json:"deferrable,omitempty"`
+}
+
+type txReq struct {
+	Tx      string        `json:"tx"`
+	SQL     string        `json:"sql,omitempty"`
+	Args    []interface{} `json:"args,omitempty"`
+	Format  string        `json:"format,omitempty"`
+	MaxRows int           `json:"max_rows,omitempty"` // truncates; no cursor
+}
+
+var isolationLevels = map[string]pgx.TxIsoLevel{
+	"":                 "",
+	"read committed":   pgx.ReadCommitted,
+	"repeatable read":  pgx.RepeatableRead,
+	"serializable":     pgx.Serializable,
+	"read uncommitted": pgx.ReadUncommitted,
+}
+
+func (p *Proxy) txBegin(ctx context.Context, body []byte) *zap.Message {
+	var req txBeginReq
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+	iso, ok := isolationLevels[strings.ReplaceAll(strings.ToLower(req.Isolation), "_", " ")]
+	if !ok {
+		return respond(http.StatusBadRequest, map[string]string{"error": "unknown isolation level: " + req.Isolation})
+	}
+	opts := pgx.TxOptions{IsoLevel: iso}
+	if req.ReadOnly || p.readOnly.Load() {
+		opts.AccessMode = pgx.ReadOnly
+	}
+	if req.Deferrable {
+		opts.DeferrableMode = pgx.Deferrable
+	}
+
+	tx, err := p.pool.Load().BeginTx(ctx, opts)
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	// The session of /tx/begin holds for the whole transaction.
+	if err := applySession(ctx, tx); err != nil {
+		tx.Rollback(ctx)
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	id := p.txs.add(tx, auth.FromContext(ctx).Caller, 0, sessionFrom(ctx))
+	return respond(http.StatusOK, map[string]interface{}{
+		"tx":              id,
+		"read_only":       opts.AccessMode == pgx.ReadOnly,
+		"idle_timeout_ms": time.Duration(p.txs.idle.Load()).Milliseconds(),
+	})
+}
+
+// txStatement runs a /tx/query or /tx/exec statement.
+func (p *Proxy) txStatement(ctx context.Context, body []byte, exec bool) *zap.Message {
+	var req txReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	t, err := p.txs.acquire(req.Tx, auth.FromContext(ctx).Caller)
+	if err != nil {
+		return respond(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	defer p.txs.release(t)
+
+	if err := setStatementTimeout(ctx, t.tx); err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	ctx = context.WithValue(ctx, sessionKey{}, t.session)
+	var resp *zap.Message
+	if exec {
+		resp = p.runExec(ctx, t.tx, sqlReq{SQL: req.SQL, Args: req.Args})
+	} else {
+		resp = p.runQuery(ctx, t.tx, sqlReq{SQL: req.SQL, Args: req.Args, Format: req.Format, MaxRows: req.MaxRows})
+	}
+	if status, _ := unpack(resp); status == http.StatusOK {
+		if err := checkSession(ctx, t.tx); err != nil {
+			// Nothing done under another role or settings may be committed.
+			p.txs.finish(req.Tx, t)
+			t.tx.Rollback(ctx)
+			return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+	return resp
+}
+
+// txEnd commits or rolls back a transaction and releases its connection.
+func (p *Proxy) txEnd(ctx context.Context, body []byte, commit bool) *zap.Message {
+	var req txReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	t, err := p.txs.acquire(req.Tx, auth.FromContext(ctx).Caller)
+	if err != nil {
+		return respond(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	p.txs.finish(req.Tx, t)
+	defer p.txs.release(t)
+
+	if !commit {
+		if err := t.tx.Rollback(ctx); err != nil {
+			return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return respond(http.StatusOK, map[string]string{"status": "rolled back"})
+	}
+	if err := t.tx.Commit(ctx); err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, map[string]string{"status": "committed"})
+}
@@ -0,0 +1,36 @@
+package sql
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestTxRegistryCloseTwice(t *testing.T) {
+	r := newTxRegistry(slog.New(slog.NewTextHandler(io.Discard, nil)), time.Minute)
+	r.close()
+	r.close() // must not panic
+}
+
+func TestTxRegistryAcquire(t *testing.T) {
+	r := newTxRegistry(slog.New(slog.NewTextHandler(io.Discard, nil)), time.Minute)
+	defer r.close()
+	id := r.add(nil, "alice", 0, nil)
+
+	if _, err := r.acquire(id, "bob"); err != errTxNotFound {
+		t.Errorf("acquire by another caller: err = %v, want errTxNotFound", err)
+	}
+	if _, err := r.acquire("unknown", "alice"); err != errTxNotFound {
+		t.Errorf("acquire of an unknown handle: err = %v, want errTxNotFound", err)
+	}
+	tx, err := r.acquire(id, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.finish(id, tx)
+	r.release(tx)
+	if _, err := r.acquire(id, "alice"); err != errTxNotFound {
+		t.Errorf("acquire after finish: err = %v, want errTxNotFound", err)
+	}
+}
@@ -0,0 +1,237 @@
+package sql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/luxfi/zap"
+
+	"github.com/hanzoai/zap-sidecar/internal/auth"
+)
+
+const (
+	defaultVectorK = 10
+	maxVectorK     = 1000
+)
+
+// vectorOps maps a metric to its pgvector distance operator. The inner
+// product operator returns the negative inner product, so smaller is
+// closer for every metric.
+var vectorOps = map[string]string{
+	"l2":            "<->",
+	"cosine":        "<=>",
+	"inner_product": "<#>",
+}
+
+type vectorSearchReq struct {
+	Table   string    `json:"table"`
+	Column  string    `json:"column"` // vector or halfvec column
+	Vector  []float64 `json:"vector"`
+	Metric  string    `json:"metric,omitempty"` // l2 (default), cosine, inner_product
+	K       int       `json:"k,omitempty"`
+	Columns []string  `json:"columns,omitempty"` // projected columns; default all
+	// Filter restricts matches to rows whose columns equal the given
+	// values; a null value matches NULL.
+	Filter map[string]interface{} `json:"filter,omitempty"`
+	Format string                 `json:"format,omitempty"`
+}
+
+// vectorSearch returns the k rows nearest to a query vector, each with
+// its "distance". Identifiers are quoted and values bound as parameters,
+// so nothing in the request is spliced into the SQL as text.
+func (p *Proxy) vectorSearch(ctx context.Context, body []byte) *zap.Message {
+	var req vectorSearchReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	sql, args, err := req.build()
+	if err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return p.readQuery(ctx, sqlReq{SQL: sql, Args: args, Format: req.Format})
+}
+
+func (r *vectorSearchReq) build() (string, []interface{}, error) {
+	if r.Metric == "" {
+		r.Metric = "l2"
+	}
+	op, ok := vectorOps[r.Metric]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown metric %q, use l2, cosine or inner_product", r.Metric)
+	}
+	if r.Table == "" || r.Column == "" {
+		return "", nil, errors.New("table and column required")
+	}
+	if len(r.Vector) == 0 {
+		return "", nil, errors.New("vector required")
+	}
+	if r.K == 0 {
+		r.K = defaultVectorK
+	}
+	if r.K < 0 || r.K > maxVectorK {
+		return "", nil, fmt.Errorf("k must be 1 to %d", maxVectorK)
+	}
+
+	// Postgres infers the parameter's type from the operator, so the
+	// same text form serves vector and halfvec columns.
+	args := []interface{}{vectorText(r.Vector)}
+	dist := ident(r.Column) + " " + op + " $1"
+
+	proj := "*"
+	if len(r.Columns) > 0 {
+		proj = identList(r.Columns)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT %s, %s AS distance FROM %s", proj, dist, qualified(r.Table))
+
+	keys := make([]string, 0, len(r.Filter))
+	for k := range r.Filter {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if i == 0 {
+			b.WriteString(" WHERE ")
+		} else {
+			b.WriteString(" AND ")
+		}
+		if r.Filter[k] == nil {
+			b.WriteString(ident(k) + " IS NULL")
+			continue
+		}
+		args = append(args, r.Filter[k])
+		fmt.Fprintf(&b, "%s = $%d", ident(k), len(args))
+	}
+	args = append(args, r.K)
+	fmt.Fprintf(&b, " ORDER BY %s LIMIT $%d", dist, len(args))
+	return b.String(), args, nil
+}
+
+// vectorText formats v in pgvector's text form, "[1,2,3]".
+func vectorText(v []float64) string {
+	b := []byte{'['}
+	for i, f := range v {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendFloat(b, f, 'g', -1, 32)
+	}
+	return string(append(b, ']'))
+}
+
+func ident(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+// qualified quotes a possibly schema-qualified table name.
+func qualified(table string) string {
+	return pgx.Identifier(strings.Split(table, ".")).Sanitize()
+}
+
+func identList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = ident(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// ================================================================
+// Upsert
+// ================================================================
+
+type vectorUpsertReq struct {
+	Table   string          `json:"table"`
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+	// Key names the conflict columns. With a key, existing rows are
+	// updated; without one, rows are only inserted.
+	Key []string `json:"key,omitempty"`
+}
+
+// vectorUpsert bulk-writes rows with COPY. Values are sent in COPY text
+// form and parsed by Postgres, so a vector is given as a JSON array of
+// numbers and every other type in its usual JSON or text form. With a key
+// the rows are copied into a temporary table and merged with
+// INSERT ... ON CONFLICT.
+func (p *Proxy) vectorUpsert(ctx context.Context, body []byte) *zap.Message {
+	var req vectorUpsertReq
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber() // keep numbers exact
+	if err := dec.Decode(&req); err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Table == "" || len(req.Columns) == 0 || len(req.Rows) == 0 {
+		return respond(http.StatusBadRequest, map[string]string{"error": "table, columns and rows required"})
+	}
+	data, err := copyText(req.Columns, req.Rows)
+	if err != nil {
+		return respond(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	tx, err := p.beginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	defer tx.Rollback(ctx)
+
+	table, cols := qualified(req.Table), identList(req.Columns)
+	if schemas := auth.FromContext(ctx).Schemas; len(schemas) > 0 {
+		if err := checkTable(ctx, tx, table, cols, schemas); err != nil {
+			return respond(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+	}
+
+	target := table
+	if len(req.Key) > 0 {
+		target = "zap_vector_upsert"
+		if _, err := tx.Exec(ctx, "CREATE TEMP TABLE "+target+" ON COMMIT DROP AS SELECT "+cols+" FROM "+table+" WITH NO DATA"); err != nil {
+			return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+	tag, err := tx.Conn().PgConn().CopyFrom(ctx, bytes.NewReader(data), "COPY "+target+" ("+cols+") FROM STDIN")
+	if err != nil {
+		return copyError(err, 0)
+	}
+	if len(req.Key) > 0 {
+		if tag, err = tx.Exec(ctx, mergeSQL(table, target, req.Columns, req.Key)); err != nil {
+			return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return respond(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return respond(http.StatusOK, map[string]interface{}{
+		"rows_affected": tag.RowsAffected(),
+		"command":       tag.String(),
+	})
+}
+
+// mergeSQL inserts the staged rows into table, updating the non-key
+// columns of rows that already exist.
+func mergeSQL(table, staged string, columns, key []string) string {
+	isKey := make(map[string]bool, len(key))
+	for _, k := range key {
+		isKey[k] = true
+	}
+	var set []string
+	for _, c := range columns {
+		if !isKey[c] {
+			set = append(set, ident(c)+" = EXCLUDED."+ident(c))
+		}
+	}
+	cols := identList(columns)
+	sql := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s ON CONFLICT (%s) DO ", table, cols, cols, staged, identList(key))
+	if len(set) == 0 {
+		return sql + "NOTHING"
+	}
+	return sql + "UPDATE SET " + strings.Join(set, ", ")
+}